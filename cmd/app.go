@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	middleware "github.com/Dnlbb/link-shortener/internal/Middlewares"
+	"github.com/Dnlbb/link-shortener/internal/analytics"
+	"github.com/Dnlbb/link-shortener/internal/config"
+	"github.com/Dnlbb/link-shortener/internal/controller"
+	controllermod "github.com/Dnlbb/link-shortener/internal/controllerMod"
+	"github.com/Dnlbb/link-shortener/internal/deleter"
+	"github.com/Dnlbb/link-shortener/internal/generator"
+	"github.com/Dnlbb/link-shortener/internal/handlers"
+	"github.com/Dnlbb/link-shortener/internal/logger"
+	"github.com/Dnlbb/link-shortener/internal/metrics"
+	"github.com/Dnlbb/link-shortener/internal/quota"
+	"github.com/Dnlbb/link-shortener/internal/reaper"
+	"github.com/Dnlbb/link-shortener/internal/requestid"
+	"github.com/Dnlbb/link-shortener/internal/shorturl"
+	"github.com/Dnlbb/link-shortener/internal/storage"
+	"github.com/Dnlbb/link-shortener/internal/tracing"
+	"github.com/Dnlbb/link-shortener/internal/users"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// stopper is a component stopped on shutdown.
+type stopper struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+// App owns the server and the background workers. Shutdown drains the server
+// first, then stops the workers that write to the storage and closes the
+// storage last.
+type App struct {
+	DB     *sql.DB
+	Server *http.Server
+	// Admin serves the metrics, it is set when METRICS_ADDRESS is.
+	Admin *http.Server
+
+	cfg     config.Config
+	log     logger.Logger
+	users   *users.Service
+	metrics *prometheus.Registry
+	tracer  *tracing.Tracer
+	workers []stopper
+	closers []stopper
+}
+
+func NewApp(ctx context.Context, cfg config.Config) (app *App, err error) {
+	app = &App{cfg: cfg, log: newLogger(cfg.Log)}
+	logger.SetDefault(app.log)
+	defer func() {
+		if err != nil {
+			app.stop(context.Background())
+		}
+	}()
+
+	var repo storage.Repository
+	var userRepo storage.UserRepository
+	switch cfg.ResolveBackend() {
+	case config.BackendPostgres:
+		db, err := openDB(cfg.Storage)
+		if err != nil {
+			return app, fmt.Errorf("database connection: %w", err)
+		}
+		app.DB = db
+		app.closers = append(app.closers, stopper{name: "database", stop: func(context.Context) error { return db.Close() }})
+
+		pgRepo := storage.NewPostgresStorage(db)
+		repo, userRepo = pgRepo, pgRepo
+		if err := repo.CreateTable(ctx); err != nil {
+			return app, fmt.Errorf("creating table: %w", err)
+		}
+	case config.BackendFile:
+		fileRepo, err := storage.NewFileStorage(cfg.Storage.FilePath)
+		if err != nil {
+			return app, fmt.Errorf("opening file storage: %w", err)
+		}
+		app.closers = append(app.closers, stopper{name: "file storage", stop: func(context.Context) error { return fileRepo.Close() }})
+		repo, userRepo = fileRepo, fileRepo
+	default:
+		memRepo := storage.NewInMemoryStorage()
+		repo, userRepo = memRepo, memRepo
+	}
+	var observers []storage.Observer
+	if cfg.Metrics.Address != "" {
+		app.metrics = metrics.NewRegistry()
+		if app.DB != nil {
+			metrics.RegisterDB(app.metrics, app.DB)
+		}
+		observers = append(observers, metrics.NewStorage(app.metrics).Observe)
+	}
+	if cfg.Tracing.Enabled {
+		exporter, err := newExporter(cfg.Tracing)
+		if err != nil {
+			return app, fmt.Errorf("tracing: %w", err)
+		}
+		app.closers = append(app.closers, stopper{name: "span exporter", stop: exporter.Close})
+		app.tracer = tracing.NewTracer(exporter, tracing.RatioSampler(cfg.Tracing.SampleRatio))
+		observers = append(observers, app.tracer.Observe)
+	}
+	// The cache wraps the observed repository, only the calls reaching the
+	// backend are timed and traced.
+	if len(observers) > 0 {
+		repo = storage.NewObservedRepository(repo, observers...)
+	}
+	if cfg.Cache.Enabled {
+		cache := storage.NewCachingRepository(repo, storage.CacheConfig{
+			Size:          cfg.Cache.Size,
+			TTL:           cfg.Cache.TTL,
+			NegativeTTL:   cfg.Cache.NegativeTTL,
+			LookupTimeout: cfg.Server.RequestTimeout,
+		})
+		repo, userRepo = cache, cache.Users(userRepo)
+		if app.metrics != nil {
+			metrics.RegisterCache(app.metrics, cache)
+		}
+	}
+	app.users = users.NewService(userRepo)
+
+	codeGenerator, err := generator.New(cfg.Generator.Strategy, int64(cfg.Generator.NodeID), repo)
+	if err != nil {
+		return app, fmt.Errorf("creating short code generator: %w", err)
+	}
+
+	ipSalt := cfg.Analytics.IPSalt
+	if ipSalt == "" {
+		if ipSalt, err = analytics.NewSalt(); err != nil {
+			return app, fmt.Errorf("creating the click salt: %w", err)
+		}
+		app.log.Warn("CLICKS_IP_SALT is not set, unique visitors are counted per process run")
+	}
+	clickRecorder := analytics.NewRecorder(repo, analytics.Config{
+		QueueSize:     cfg.Analytics.QueueSize,
+		BatchSize:     cfg.Analytics.BatchSize,
+		FlushInterval: cfg.Analytics.FlushInterval,
+	})
+	deleteService := deleter.NewService(repo, deleter.Config{
+		Workers:       cfg.Deleter.Workers,
+		QueueSize:     cfg.Deleter.QueueSize,
+		BatchSize:     cfg.Deleter.BatchSize,
+		FlushInterval: cfg.Deleter.FlushInterval,
+		FlushTimeout:  cfg.Deleter.FlushTimeout,
+	})
+	expiryReaper := reaper.New(repo, reaper.Config{
+		Interval:  cfg.Reaper.Interval,
+		Retention: cfg.Reaper.Retention,
+	})
+	app.workers = append(app.workers,
+		stopper{name: "click recorder", stop: clickRecorder.Close},
+		stopper{name: "delete service", stop: deleteService.Close},
+		stopper{name: "expiry reaper", stop: expiryReaper.Close},
+	)
+
+	options := []handlers.Option{
+		handlers.WithDeleter(deleteService),
+		handlers.WithGenerator(codeGenerator),
+		handlers.WithClickRecorder(clickRecorder, ipSalt),
+		handlers.WithUsers(app.users),
+		handlers.WithQuotas(quota.NewService(repo, quotaConfig(cfg.Quota))),
+	}
+	if app.metrics != nil {
+		metrics.RegisterQueues(app.metrics, map[string]func() int{
+			"clicks":    clickRecorder.Len,
+			"deletions": deleteService.Len,
+		})
+		app.metrics.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "shortener_clicks_dropped_total",
+			Help: "Clicks dropped because the queue was full.",
+		}, func() float64 { return float64(clickRecorder.Dropped()) }))
+		options = append(options, handlers.WithMetrics(metrics.NewLinks(app.metrics)))
+	}
+
+	urls, err := shorturl.New(cfg.Server.BaseURL, cfg.Server.AliasHosts...)
+	if err != nil {
+		return app, err
+	}
+	urls = urls.WithDomains(cfg.Server.Domains...)
+
+	handler := handlers.NewHandler(repo, append(options, handlers.WithURLBuilder(urls))...)
+
+	auth, err := app.authMiddleware()
+	if err != nil {
+		return app, fmt.Errorf("auth: %w", err)
+	}
+
+	app.Server = &http.Server{
+		Addr:              cfg.Server.Address,
+		Handler:           app.routes(ctx, handler, auth),
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+	if app.metrics != nil {
+		admin := http.NewServeMux()
+		admin.Handle("/metrics", metrics.Handler(app.metrics))
+		app.Admin = &http.Server{
+			Addr:              cfg.Metrics.Address,
+			Handler:           admin,
+			ReadHeaderTimeout: cfg.Server.ReadTimeout,
+		}
+	}
+	return app, nil
+}
+
+func quotaConfig(cfg config.QuotaConfig) quota.Config {
+	tiers := make(map[string]quota.Limits, len(cfg.Tiers))
+	for name, tier := range cfg.Tiers {
+		tiers[name] = quota.Limits{MaxLinks: tier.MaxLinks, MaxBatch: tier.MaxBatch}
+	}
+	return quota.Config{
+		Default: quota.Limits{MaxLinks: cfg.Default.MaxLinks, MaxBatch: cfg.Default.MaxBatch},
+		Tiers:   tiers,
+		Users:   cfg.Users,
+	}
+}
+
+func newExporter(cfg config.TracingConfig) (tracing.Exporter, error) {
+	if cfg.Exporter == config.ExporterFile {
+		return tracing.NewFileExporter(cfg.File)
+	}
+	return tracing.NewWriterExporter(os.Stdout), nil
+}
+
+func openDB(cfg config.StorageConfig) (*sql.DB, error) {
+	db, err := sql.Open("pgx", cfg.DatabaseDSN)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	return db, nil
+}
+
+func newLogger(cfg config.LogConfig) logger.Logger {
+	level, err := logrus.ParseLevel(cfg.Level)
+	if err != nil {
+		level = logrus.InfoLevel
+	}
+	log := logrus.New()
+	log.SetFormatter(&logrus.TextFormatter{
+		FullTimestamp:   true,
+		TimestampFormat: "2006-01-02 15:04:05",
+		DisableColors:   false,
+	})
+	log.SetOutput(os.Stdout)
+	log.SetLevel(level)
+	return logger.NewLogrusLogger(log, logger.Redaction{URLs: cfg.RedactURLs, UserIDs: cfg.RedactUserIDs})
+}
+
+// authMiddleware returns the middleware of the configured auth mode.
+func (a *App) authMiddleware() (func(http.Handler) http.Handler, error) {
+	authCfg := a.cfg.Auth
+	authCfg.CookieSecure = a.cfg.SecureCookies()
+	session := middleware.NewSessionAuth(authCfg, a.cfg.Server.RequestTimeout).WithTokens(a.users)
+	if authCfg.Mode != config.AuthJWT {
+		return session.Middleware, nil
+	}
+
+	jwtAuth, err := middleware.NewJWTAuth(authCfg.JWT, a.cfg.Server.RequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if authCfg.SessionFallback {
+		jwtAuth = jwtAuth.WithFallback(session)
+	}
+	return jwtAuth.Middleware, nil
+}
+
+func (a *App) routes(ctx context.Context, handler *handlers.Handler, auth func(http.Handler) http.Handler) http.Handler {
+	limiter := middleware.NewRateLimiter(middleware.NewMemoryRateLimitStore(), a.cfg.RateLimit.TrustForwardedFor)
+	createLimit := limiter.Limit(middleware.GroupCreate, middleware.LimitOf(a.cfg.RateLimit.Create))
+	batchLimit := limiter.Limit(middleware.GroupBatch, middleware.LimitOf(a.cfg.RateLimit.Batch))
+	redirectLimit := limiter.Limit(middleware.GroupRedirect, middleware.LimitOf(a.cfg.RateLimit.Redirect))
+	signupLimit := limiter.Limit(middleware.GroupSignup, middleware.LimitOf(a.cfg.RateLimit.Signup))
+
+	controller := controller.NewBaseController(ctx, *handler).
+		WithRateLimits(createLimit, redirectLimit)
+	modController := controllermod.NewModController(ctx, *handler).
+		WithRateLimits(createLimit, batchLimit, redirectLimit)
+
+	// Every middleware gets a span of its own when tracing, the time it took
+	// is its span less the next one.
+	trace := func(name string, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+		if a.tracer == nil {
+			return mw
+		}
+		return a.tracer.Trace(name, mw)
+	}
+
+	// The request ID comes first, so the errors of every middleware carry it.
+	r := chi.NewRouter()
+	r.Use(requestid.Middleware)
+	r.Use(trace("gzip", middleware.GzipMiddleware))
+	r.Use(logger.Middleware(a.log))
+	r.Use(trace("auth", auth))
+	if a.tracer != nil {
+		r.Use(a.tracer.Span("handler"))
+	}
+	r.Mount("/", controller.Route())
+	r.Mount("/api/", modController.Route())
+	r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
+		if a.DB != nil {
+			err := a.DB.PingContext(r.Context())
+			if err != nil {
+				requestid.Error(r.Context(), w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+	r.Get("/api/user/urls", func(w http.ResponseWriter, r *http.Request) {
+		handler.GetUserURLs(r.Context(), w, r)
+	})
+	r.Delete("/api/user/urls", func(w http.ResponseWriter, r *http.Request) {
+		handler.DelUserUrls(r.Context(), w, r)
+	})
+	r.Get("/api/user/quota", func(w http.ResponseWriter, r *http.Request) {
+		handler.UserQuota(r.Context(), w, r)
+	})
+	r.Get("/api/user/urls/{short}/stats", func(w http.ResponseWriter, r *http.Request) {
+		handler.LinkStats(r.Context(), w, r)
+	})
+	r.With(signupLimit).Post("/api/users", func(w http.ResponseWriter, r *http.Request) {
+		handler.CreateUser(r.Context(), w, r)
+	})
+	r.Get("/api/user", func(w http.ResponseWriter, r *http.Request) {
+		handler.CurrentUser(r.Context(), w, r)
+	})
+	r.Get("/api/user/tokens", func(w http.ResponseWriter, r *http.Request) {
+		handler.ListTokens(r.Context(), w, r)
+	})
+	r.Post("/api/user/tokens", func(w http.ResponseWriter, r *http.Request) {
+		handler.IssueToken(r.Context(), w, r)
+	})
+	r.Delete("/api/user/tokens/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handler.RevokeToken(r.Context(), w, r)
+	})
+	r.Post("/api/user/claim", func(w http.ResponseWriter, r *http.Request) {
+		handler.ClaimLinks(r.Context(), w, r)
+	})
+	if a.metrics == nil && a.tracer == nil {
+		return r
+	}
+
+	// The metrics and tracing middlewares see every request, the auth
+	// included.
+	root := chi.NewRouter()
+	if a.tracer != nil {
+		root.Use(a.tracer.Middleware)
+	}
+	if a.metrics != nil {
+		root.Use(metrics.NewHTTP(a.metrics).Middleware)
+	}
+	root.Mount("/", r)
+	return root
+}
+
+// Run serves until ctx is done or the server fails, then shuts the app down
+// within the configured grace period.
+func (a *App) Run(ctx context.Context) error {
+	serveErr := make(chan error, 2)
+	go func() {
+		a.log.Info(fmt.Sprintf("Server start on port: %s", a.Server.Addr))
+		serveErr <- a.Server.ListenAndServe()
+	}()
+	if a.Admin != nil {
+		go func() {
+			a.log.Info(fmt.Sprintf("Metrics served on: %s", a.Admin.Addr))
+			serveErr <- a.Admin.ListenAndServe()
+		}()
+	}
+
+	var err error
+	select {
+	case err = <-serveErr:
+		err = fmt.Errorf("starting the server: %w", err)
+	case <-ctx.Done():
+		a.log.Info("Shutting down")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), a.cfg.Server.ShutdownTimeout)
+	defer cancel()
+	return errors.Join(err, a.Shutdown(shutdownCtx))
+}
+
+// Shutdown waits for in-flight requests, then stops the workers and closes
+// the storage. The storage is closed even when ctx expires before the
+// workers have drained.
+func (a *App) Shutdown(ctx context.Context) error {
+	var err error
+	if a.Server != nil {
+		if shutdownErr := a.Server.Shutdown(ctx); shutdownErr != nil {
+			err = fmt.Errorf("draining connections: %w", shutdownErr)
+		}
+	}
+	if a.Admin != nil {
+		if shutdownErr := a.Admin.Shutdown(ctx); shutdownErr != nil {
+			err = errors.Join(err, fmt.Errorf("stopping the admin server: %w", shutdownErr))
+		}
+	}
+	return errors.Join(err, a.stop(ctx))
+}
+
+// stop stops the workers before closing what they write to.
+func (a *App) stop(ctx context.Context) error {
+	var errs []error
+	for _, s := range append(a.workers, a.closers...) {
+		if err := s.stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("stopping %s: %w", s.name, err))
+		}
+	}
+	a.workers, a.closers = nil, nil
+	return errors.Join(errs...)
+}
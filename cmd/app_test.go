@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dnlbb/link-shortener/internal/config"
+	"github.com/Dnlbb/link-shortener/internal/requestid"
+	"github.com/Dnlbb/link-shortener/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppStopsInOrder(t *testing.T) {
+	var order []string
+	record := func(name string) stopper {
+		return stopper{name: name, stop: func(context.Context) error {
+			order = append(order, name)
+			return nil
+		}}
+	}
+	app := &App{
+		Server:  &http.Server{},
+		workers: []stopper{record("recorder"), record("deleter")},
+		closers: []stopper{record("storage")},
+	}
+
+	require.NoError(t, app.Shutdown(context.Background()))
+	assert.Equal(t, []string{"recorder", "deleter", "storage"}, order)
+
+	require.NoError(t, app.Shutdown(context.Background()))
+	assert.Len(t, order, 3)
+}
+
+func TestAppRunShutsDownOnCancel(t *testing.T) {
+	cfg := config.Default()
+	cfg.Server.Address = "127.0.0.1:0"
+	cfg.Server.ShutdownTimeout = time.Second
+	cfg.Storage.Backend = config.BackendMemory
+	cfg.Auth.Key = "test-secret-key"
+	require.NoError(t, cfg.Validate())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	app, err := NewApp(ctx, cfg)
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() { done <- app.Run(ctx) }()
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
+
+func TestAppServesMetrics(t *testing.T) {
+	cfg := config.Default()
+	cfg.Storage.Backend = config.BackendMemory
+	cfg.Auth.Key = "test-secret-key"
+	cfg.Metrics.Address = "127.0.0.1:9090"
+	require.NoError(t, cfg.Validate())
+
+	app, err := NewApp(context.Background(), cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { app.Shutdown(context.Background()) })
+	require.NotNil(t, app.Admin)
+
+	rec := httptest.NewRecorder()
+	app.Server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://example.com/")))
+	require.Equal(t, http.StatusCreated, rec.Code)
+	rec = httptest.NewRecorder()
+	app.Server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	rec = httptest.NewRecorder()
+	app.Admin.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, "# TYPE shortener_http_requests_total counter")
+	assert.Contains(t, body, `shortener_http_requests_total{method="POST",route="/",status="201"} 1`)
+	assert.Contains(t, body, `shortener_http_requests_total{method="GET",route="/{shortURL}",status="400"} 1`)
+	assert.Contains(t, body, "shortener_links_created_total 1")
+	assert.Contains(t, body, `shortener_storage_operation_duration_seconds_count{operation="save"} 1`)
+	assert.Contains(t, body, `shortener_queue_depth{queue="clicks"} 0`)
+
+	rec = httptest.NewRecorder()
+	app.Server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.NotContains(t, rec.Body.String(), "shortener_http_requests_total", "the main server never serves the metrics")
+}
+
+func TestAppMetricsOffByDefault(t *testing.T) {
+	cfg := config.Default()
+	cfg.Storage.Backend = config.BackendMemory
+	cfg.Auth.Key = "test-secret-key"
+	require.NoError(t, cfg.Validate())
+
+	app, err := NewApp(context.Background(), cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { app.Shutdown(context.Background()) })
+	assert.Nil(t, app.Admin)
+
+	rec := httptest.NewRecorder()
+	app.Server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.NotContains(t, rec.Body.String(), "shortener_http_requests_total")
+}
+
+func TestAppLimitsSignups(t *testing.T) {
+	cfg := config.Default()
+	cfg.Storage.Backend = config.BackendMemory
+	cfg.Auth.Key = "test-secret-key"
+	cfg.RateLimit.Signup = config.RateLimit{Requests: 1, Period: time.Hour, Burst: 2}
+	require.NoError(t, cfg.Validate())
+
+	app, err := NewApp(context.Background(), cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { app.Shutdown(context.Background()) })
+
+	rec := httptest.NewRecorder()
+	app.Server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://example.com/")))
+	require.Equal(t, http.StatusCreated, rec.Code)
+	session := rec.Result().Cookies()[0]
+
+	signup := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(`{"name": "ci"}`))
+		req.AddCookie(session)
+		rec := httptest.NewRecorder()
+		app.Server.Handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusCreated, signup())
+	assert.Equal(t, http.StatusCreated, signup())
+	assert.Equal(t, http.StatusTooManyRequests, signup())
+}
+
+func TestAppTracesRequestsIntoTheStorage(t *testing.T) {
+	cfg := config.Default()
+	cfg.Storage.Backend = config.BackendMemory
+	cfg.Auth.Key = "test-secret-key"
+	cfg.Tracing.Enabled = true
+	cfg.Tracing.Exporter = config.ExporterFile
+	cfg.Tracing.File = filepath.Join(t.TempDir(), "spans.jsonl")
+	require.NoError(t, cfg.Validate())
+
+	app, err := NewApp(context.Background(), cfg)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://example.com/"))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	app.Server.Handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.NoError(t, app.Shutdown(context.Background()))
+
+	data, err := os.ReadFile(cfg.Tracing.File)
+	require.NoError(t, err)
+	parents := make(map[string]string)
+	ids := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var span tracing.SpanData
+		require.NoError(t, json.Unmarshal([]byte(line), &span))
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.TraceID)
+		parents[span.Name], ids[span.Name] = span.ParentSpanID, span.SpanID
+	}
+	assert.Equal(t, "00f067aa0ba902b7", parents["POST /"])
+	assert.Equal(t, ids["POST /"], parents["gzip"])
+	assert.Equal(t, ids["gzip"], parents["auth"])
+	assert.Equal(t, ids["auth"], parents["handler"])
+	assert.Equal(t, ids["handler"], parents["storage.save"])
+}
+
+func TestAppCorrelatesRequests(t *testing.T) {
+	cfg := config.Default()
+	cfg.Storage.Backend = config.BackendMemory
+	cfg.Auth.Key = "test-secret-key"
+	require.NoError(t, cfg.Validate())
+
+	app, err := NewApp(context.Background(), cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { app.Shutdown(context.Background()) })
+
+	req := httptest.NewRequest(http.MethodGet, "/missing", nil)
+	req.Header.Set(requestid.Header, "support-ticket-42")
+	rec := httptest.NewRecorder()
+	app.Server.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "support-ticket-42", rec.Header().Get(requestid.Header))
+	assert.True(t, strings.HasSuffix(rec.Body.String(), "\nRequest ID: support-ticket-42\n"), rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	app.Server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://example.com/")))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get(requestid.Header), "an ID is generated when none is sent")
+	assert.NotContains(t, rec.Body.String(), "Request ID")
+	shortURL := rec.Body.String()
+
+	rec = httptest.NewRecorder()
+	app.Server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://example.com/")))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, shortURL, rec.Body.String(), "the body of a conflict is the existing short URL only")
+	assert.NotEmpty(t, rec.Header().Get(requestid.Header))
+}
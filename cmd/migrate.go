@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/Dnlbb/link-shortener/internal/config"
+	"github.com/Dnlbb/link-shortener/internal/migrations"
+)
+
+const migrateUsage = "usage: link-shortener [flags] migrate up | down [steps] | version"
+
+// runMigrate handles the migrate subcommand, which changes the schema
+// without starting the server.
+func runMigrate(ctx context.Context, cfg config.Config, args []string) error {
+	if cfg.Storage.DatabaseDSN == "" {
+		return errors.New("migrate needs a database, set -d or DATABASE_DSN")
+	}
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	db, err := openDB(cfg.Storage)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d migrations\n", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Reverted %d migrations\n", reverted)
+	case "version":
+		version, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Schema version %d, latest %d\n", version, migrator.Latest())
+	default:
+		return errors.New(migrateUsage)
+	}
+	return nil
+}
@@ -2,11 +2,9 @@ package middlewares
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -14,70 +12,217 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/Dnlbb/link-shortener/internal/config"
+	"github.com/Dnlbb/link-shortener/internal/logger"
+	"github.com/Dnlbb/link-shortener/internal/requestid"
+	"github.com/Dnlbb/link-shortener/internal/users"
 )
 
-func SignData(data string) string {
-	h := hmac.New(sha256.New, []byte(config.Conf.Key))
-	h.Write([]byte(data))
-	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+// TokenAuthenticator resolves API tokens to the ID of their user.
+type TokenAuthenticator interface {
+	Authenticate(ctx context.Context, token string) (string, error)
 }
 
-func verifyData(data, signature string) bool {
-	expectedSignature := SignData(data)
-	return hmac.Equal([]byte(expectedSignature), []byte(signature))
+// sessionCookie is the name of the session cookie.
+const sessionCookie = "session"
+
+// SessionAuth identifies users by an `Authorization: Bearer` API token or by
+// a signed session cookie, and hands out a new cookie to unknown users.
+// Sessions expire after cookieTTL without requests and are re-issued once
+// half of it has passed or when they were signed with a previous key.
+type SessionAuth struct {
+	keys keyring
+	// legacyUntil ends the support of legacy cookies, the zero time rejects
+	// them.
+	legacyUntil    time.Time
+	cookieTTL      time.Duration
+	secure         bool
+	sameSite       http.SameSite
+	requestTimeout time.Duration
+	tokens         TokenAuthenticator
+	now            func() time.Time
+}
+
+func NewSessionAuth(cfg config.AuthConfig, requestTimeout time.Duration) *SessionAuth {
+	sameSite := http.SameSiteLaxMode
+	switch cfg.CookieSameSite {
+	case config.SameSiteStrict:
+		sameSite = http.SameSiteStrictMode
+	case config.SameSiteNone:
+		sameSite = http.SameSiteNoneMode
+	}
+	// The cutoff is checked by config.Validate, a bad one rejects legacy
+	// cookies.
+	legacyUntil, _ := cfg.LegacyCookiesCutoff()
+	return &SessionAuth{
+		keys:           newKeyring(cfg.Key, cfg.PreviousKeys),
+		legacyUntil:    legacyUntil,
+		cookieTTL:      cfg.CookieTTL,
+		secure:         cfg.CookieSecure,
+		sameSite:       sameSite,
+		requestTimeout: requestTimeout,
+		now:            time.Now,
+	}
 }
 
-func CreateCookie(w http.ResponseWriter) string {
-	UserID := uuid.New().String()
-	signature := SignData(UserID)
-	cookieValue := fmt.Sprintf("%s|%s", UserID, signature)
+// WithTokens enables bearer tokens, without them the Authorization header is
+// rejected.
+func (a *SessionAuth) WithTokens(tokens TokenAuthenticator) *SessionAuth {
+	a.tokens = tokens
+	return a
+}
+
+// SignData signs data with the current key the way cookies of the legacy
+// "uid|signature" format were signed.
+func (a *SessionAuth) SignData(data string) string {
+	return base64.StdEncoding.EncodeToString(a.keys.sign(a.keys.current, []byte(data)))
+}
+
+// CreateCookie starts a session for a new user and returns the user ID.
+func (a *SessionAuth) CreateCookie(w http.ResponseWriter) string {
+	userID := uuid.New().String()
+	a.setCookie(w, userID)
+	return userID
+}
+
+func (a *SessionAuth) setCookie(w http.ResponseWriter, userID string) {
+	now := a.now()
+	value, err := a.keys.encode(session{
+		UserID:    userID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(a.cookieTTL).Unix(),
+	})
+	if err != nil {
+		logger.Default().WithFields(map[string]interface{}{logger.FieldError: err}).Error("Error encoding the session")
+		return
+	}
 	http.SetCookie(w, &http.Cookie{
-		Name:    "session",
-		Value:   cookieValue,
-		Expires: time.Now().Add(24 * time.Hour),
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  now.Add(a.cookieTTL),
+		MaxAge:   int(a.cookieTTL / time.Second),
+		HttpOnly: true,
+		Secure:   a.secure,
+		SameSite: a.sameSite,
 	})
-	return UserID
 }
 
-func ExtractUserIDFromCookie(r *http.Request) (string, error) {
-	cookie, err := r.Cookie("session")
+// readSession returns the verified session of the request. Cookies of the
+// legacy format have no expiry, they are accepted until the configured
+// cutoff and replaced right away.
+func (a *SessionAuth) readSession(r *http.Request) (session, error) {
+	cookie, err := r.Cookie(sessionCookie)
 	if err != nil {
-		return "", err
+		return session{}, err
 	}
-	parts := string(cookie.Value)
-
-	splitParts := strings.Split(parts, "|")
-	if len(splitParts) != 2 {
-		return "", fmt.Errorf("invalid cookie format")
+	if strings.Contains(cookie.Value, "|") {
+		if !a.now().Before(a.legacyUntil) {
+			return session{}, errSessionExpired
+		}
+		return a.keys.decodeLegacy(cookie.Value)
 	}
+	return a.keys.decode(cookie.Value, a.now())
+}
 
-	userID, signature := splitParts[0], splitParts[1]
-	if !verifyData(userID, signature) {
-		return "", fmt.Errorf("invalid signature")
+// needsRefresh reports whether the session is re-issued on this request.
+func (a *SessionAuth) needsRefresh(s session) bool {
+	if s.keyID != a.keys.current {
+		return true
 	}
+	remaining := time.Unix(s.ExpiresAt, 0).Sub(a.now())
+	return remaining < a.cookieTTL/2
+}
 
-	return userID, nil
-
+func (a *SessionAuth) ExtractUserIDFromCookie(r *http.Request) (string, error) {
+	s, err := a.readSession(r)
+	if err != nil {
+		return "", fmt.Errorf("session cookie: %w", err)
+	}
+	return s.UserID, nil
 }
 
 type contextKey string
 
-const UserIDKey contextKey = "userID"
+const (
+	UserIDKey contextKey = "userID"
+	// AccountKey is true when the user was identified by an API token.
+	AccountKey contextKey = "account"
+	// SessionIDKey holds the user ID of a valid session cookie sent along with
+	// an API token.
+	SessionIDKey contextKey = "sessionID"
+	// AuthenticatedKey is true when the user was identified by an API token
+	// or a JWT. Session cookies are minted for anyone on any request, their
+	// user ID says nothing about the client.
+	AuthenticatedKey contextKey = "authenticated"
+)
+
+// bearerToken returns the token of an `Authorization: Bearer` header.
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", false
+	}
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", true
+	}
+	return strings.TrimSpace(token), true
+}
+
+// userArea reports whether the request reads or changes the data of the
+// user, such requests need a valid session instead of a new one. Signing up
+// is one of them, an account is only created by the owner of a session.
+func userArea(r *http.Request) bool {
+	return r.URL.Path == "/api/user" || r.URL.Path == "/api/users" || strings.HasPrefix(r.URL.Path, "/api/user/")
+}
 
-func MiddlewareAuth(next http.Handler) http.Handler {
+func (a *SessionAuth) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		ctx, cancel := context.WithTimeout(r.Context(), a.requestTimeout)
 		defer cancel()
-		userID, err := ExtractUserIDFromCookie(r)
-		if r.URL.Path == "/api/user/urls" && err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		if token, ok := bearerToken(r); ok {
+			a.serveToken(ctx, w, r, token, next)
+			return
+		}
+		s, err := a.readSession(r)
+		userID := s.UserID
+		if userArea(r) && err != nil {
+			requestid.Error(r.Context(), w, "Unauthorized", http.StatusUnauthorized)
 			return
 		} else if err != nil {
-			userID = CreateCookie(w)
+			userID = a.CreateCookie(w)
+		} else if a.needsRefresh(s) {
+			a.setCookie(w, userID)
 		}
 		ctx = context.WithValue(ctx, UserIDKey, userID)
-		log.Printf("userID in context: %v", userID)
+		logger.FromContext(ctx).WithFields(map[string]interface{}{logger.FieldUserID: userID}).Debug("User identified")
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// serveToken serves a request sent with an API token. A bad token is never
+// replaced with a new anonymous session.
+func (a *SessionAuth) serveToken(ctx context.Context, w http.ResponseWriter, r *http.Request, token string, next http.Handler) {
+	userID, err := "", users.ErrInvalidToken
+	if a.tokens != nil && token != "" {
+		userID, err = a.tokens.Authenticate(ctx, token)
+	}
+	if errors.Is(err, users.ErrInvalidToken) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		requestid.Error(r.Context(), w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		logger.FromContext(ctx).WithFields(map[string]interface{}{logger.FieldError: err}).Error("Error authenticating token")
+		requestid.Error(r.Context(), w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	ctx = context.WithValue(ctx, UserIDKey, userID)
+	ctx = context.WithValue(ctx, AccountKey, true)
+	ctx = context.WithValue(ctx, AuthenticatedKey, true)
+	if sessionID, err := a.ExtractUserIDFromCookie(r); err == nil {
+		ctx = context.WithValue(ctx, SessionIDKey, sessionID)
+	}
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
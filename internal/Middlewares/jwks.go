@@ -0,0 +1,128 @@
+package middlewares
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var errUnknownKey = errors.New("no key verifies the token")
+
+// jwk is the part of a JSON Web Key the middleware reads.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// N and E are the modulus and exponent of an RSA key, K is the secret of
+	// an oct key, all base64url encoded.
+	N string `json:"n"`
+	E string `json:"e"`
+	K string `json:"k"`
+}
+
+// verificationKey is a key able to check the signatures of one algorithm.
+type verificationKey struct {
+	kid string
+	alg string
+	key jwt.VerificationKey
+}
+
+// jwtKeys holds the keys tokens are verified with.
+type jwtKeys []verificationKey
+
+// loadJWKS reads the RSA and oct keys of a JWKS file. Keys of other types or
+// meant for encryption are skipped.
+func loadJWKS(path string) (jwtKeys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("jwks %s: %w", path, err)
+	}
+
+	var keys jwtKeys
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key verificationKey
+		switch k.Kty {
+		case "RSA":
+			key, err = rsaKey(k)
+		case "oct":
+			key, err = octKey(k)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("jwks %s: key %d: %w", path, i, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("jwks %s: no RS256 or HS256 signing keys", path)
+	}
+	return keys, nil
+}
+
+func rsaKey(k jwk) (verificationKey, error) {
+	if k.Alg != "" && k.Alg != jwt.SigningMethodRS256.Alg() {
+		return verificationKey{}, fmt.Errorf("unsupported algorithm %s", k.Alg)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil || len(n) == 0 {
+		return verificationKey{}, errors.New("invalid modulus")
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil || len(e) == 0 || len(e) > 4 {
+		return verificationKey{}, errors.New("invalid exponent")
+	}
+	key := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}
+	if key.N.BitLen() < 2048 {
+		return verificationKey{}, fmt.Errorf("RSA key of %d bits is too short", key.N.BitLen())
+	}
+	return verificationKey{kid: k.Kid, alg: jwt.SigningMethodRS256.Alg(), key: key}, nil
+}
+
+func octKey(k jwk) (verificationKey, error) {
+	if k.Alg != "" && k.Alg != jwt.SigningMethodHS256.Alg() {
+		return verificationKey{}, fmt.Errorf("unsupported algorithm %s", k.Alg)
+	}
+	secret, err := base64.RawURLEncoding.DecodeString(k.K)
+	if err != nil || len(secret) == 0 {
+		return verificationKey{}, errors.New("invalid secret")
+	}
+	return verificationKey{kid: k.Kid, alg: jwt.SigningMethodHS256.Alg(), key: secret}, nil
+}
+
+// keyfunc picks the keys of the token's algorithm, narrowed down to its kid
+// when the token names one. Keys are never shared between algorithms, so an
+// RSA public key can not be used as an HMAC secret.
+func (k jwtKeys) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	var set jwt.VerificationKeySet
+	for _, key := range k {
+		if key.alg != token.Method.Alg() || (kid != "" && key.kid != "" && key.kid != kid) {
+			continue
+		}
+		set.Keys = append(set.Keys, key.key)
+	}
+	if len(set.Keys) == 0 {
+		return nil, errUnknownKey
+	}
+	return set, nil
+}
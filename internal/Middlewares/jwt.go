@@ -0,0 +1,161 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/Dnlbb/link-shortener/internal/config"
+	"github.com/Dnlbb/link-shortener/internal/logger"
+	"github.com/Dnlbb/link-shortener/internal/requestid"
+)
+
+var errMissingSubject = errors.New("token has no sub claim")
+
+// JWTAuth identifies users by the sub claim of a JWT issued by another
+// service, read from a header or a cookie. Requests without a token go to the
+// session fallback when there is one. Otherwise only GET and HEAD requests
+// outside /api/, such as redirects, are served anonymously.
+type JWTAuth struct {
+	keys           jwtKeys
+	parser         *jwt.Parser
+	header         string
+	cookie         string
+	requestTimeout time.Duration
+	fallback       *SessionAuth
+}
+
+func NewJWTAuth(cfg config.JWTConfig, requestTimeout time.Duration) (*JWTAuth, error) {
+	var keys jwtKeys
+	if cfg.JWKSFile != "" {
+		var err error
+		if keys, err = loadJWKS(cfg.JWKSFile); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Secret != "" {
+		keys = append(keys, verificationKey{alg: jwt.SigningMethodHS256.Alg(), key: []byte(cfg.Secret)})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwt: no keys configured")
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(cfg.Leeway),
+	}
+	if cfg.Issuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		options = append(options, jwt.WithAudience(cfg.Audience))
+	}
+	return &JWTAuth{
+		keys:           keys,
+		parser:         jwt.NewParser(options...),
+		header:         cfg.Header,
+		cookie:         cfg.Cookie,
+		requestTimeout: requestTimeout,
+	}, nil
+}
+
+// WithFallback identifies requests without a JWT by the session cookie,
+// including the API tokens the session auth accepts.
+func (a *JWTAuth) WithFallback(session *SessionAuth) *JWTAuth {
+	a.fallback = session
+	return a
+}
+
+// token returns the JWT of the request. Header values that are no JWT, such
+// as API tokens, are left to the fallback.
+func (a *JWTAuth) token(r *http.Request) (string, bool) {
+	if a.header != "" {
+		value := strings.TrimSpace(r.Header.Get(a.header))
+		if scheme, token, ok := strings.Cut(value, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			value = strings.TrimSpace(token)
+		}
+		if isJWT(value) {
+			return value, true
+		}
+	}
+	if a.cookie != "" {
+		if cookie, err := r.Cookie(a.cookie); err == nil && isJWT(cookie.Value) {
+			return cookie.Value, true
+		}
+	}
+	return "", false
+}
+
+func isJWT(value string) bool {
+	return strings.Count(value, ".") == 2
+}
+
+// verify checks the signature and the claims of the token and returns its
+// subject.
+func (a *JWTAuth) verify(raw string) (string, error) {
+	var claims jwt.RegisteredClaims
+	if _, err := a.parser.ParseWithClaims(raw, &claims, a.keys.keyfunc); err != nil {
+		return "", err
+	}
+	if claims.Subject == "" {
+		return "", errMissingSubject
+	}
+	return claims.Subject, nil
+}
+
+// ExtractUserID returns the subject of the request's JWT.
+func (a *JWTAuth) ExtractUserID(r *http.Request) (string, error) {
+	raw, ok := a.token(r)
+	if !ok {
+		return "", errors.New("jwt: no token")
+	}
+	return a.verify(raw)
+}
+
+// requiresUser reports whether a request without a JWT is rejected when there
+// is no fallback.
+func requiresUser(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return true
+	}
+	return r.URL.Path == "/api" || strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+func (a *JWTAuth) Middleware(next http.Handler) http.Handler {
+	var fallback http.Handler
+	if a.fallback != nil {
+		fallback = a.fallback.Middleware(next)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, ok := a.token(r)
+		if !ok && fallback != nil {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		if !ok && requiresUser(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			requestid.Error(r.Context(), w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), a.requestTimeout)
+		defer cancel()
+		if ok {
+			userID, err := a.verify(raw)
+			if err != nil {
+				logger.FromContext(ctx).WithFields(map[string]interface{}{logger.FieldError: err}).Warn("Rejected JWT")
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				requestid.Error(r.Context(), w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			ctx = context.WithValue(ctx, UserIDKey, userID)
+			ctx = context.WithValue(ctx, AuthenticatedKey, true)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,190 @@
+package middlewares
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Dnlbb/link-shortener/internal/config"
+)
+
+func writeJWKS(t *testing.T, key *rsa.PublicKey, secret []byte) string {
+	t.Helper()
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks := fmt.Sprintf(`{"keys": [
+		{"kty": "RSA", "kid": "rsa-1", "use": "sig", "alg": "RS256", "n": %q, "e": %q},
+		{"kty": "oct", "kid": "hmac-1", "k": %q},
+		{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": "", "y": ""},
+		{"kty": "RSA", "kid": "enc-1", "use": "enc", "n": "", "e": ""}
+	]}`, b64(key.N.Bytes()), b64(big.NewInt(int64(key.E)).Bytes()), b64(secret))
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, []byte(jwks), 0644))
+	return path
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func TestJWTAuth(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwksSecret := []byte("jwks-hmac-secret-of-enough-length")
+
+	auth, err := NewJWTAuth(config.JWTConfig{
+		Secret:   "shared-secret",
+		JWKSFile: writeJWKS(t, &rsaKey.PublicKey, jwksSecret),
+		Header:   "Authorization",
+		Cookie:   "access_token",
+		Issuer:   "https://auth.example.com",
+		Audience: "shortener",
+	}, time.Second)
+	require.NoError(t, err)
+
+	claims := func(sub string, exp time.Duration) jwt.RegisteredClaims {
+		return jwt.RegisteredClaims{
+			Subject:   sub,
+			Issuer:    "https://auth.example.com",
+			Audience:  jwt.ClaimStrings{"shortener"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(exp)),
+		}
+	}
+	valid := claims("user-1", time.Hour)
+	wrongIssuer := valid
+	wrongIssuer.Issuer = "https://evil.example.com"
+	noExpiry := valid
+	noExpiry.ExpiresAt = nil
+	rsaPublicAsSecret := signToken(t, jwt.SigningMethodHS256, "rsa-1", rsaKey.PublicKey.N.Bytes(), valid)
+
+	tests := []struct {
+		name   string
+		header string
+		cookie string
+		method string
+		path   string
+		user   string
+		status int
+	}{
+		{"RS256 from the header", "Bearer " + signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, valid), "", http.MethodPost, "/", "user-1", http.StatusOK},
+		{"RS256 without kid", "Bearer " + signToken(t, jwt.SigningMethodRS256, "", rsaKey, valid), "", http.MethodPost, "/", "user-1", http.StatusOK},
+		{"HS256 with the secret", "Bearer " + signToken(t, jwt.SigningMethodHS256, "", []byte("shared-secret"), valid), "", http.MethodPost, "/", "user-1", http.StatusOK},
+		{"HS256 with a JWKS key", "Bearer " + signToken(t, jwt.SigningMethodHS256, "hmac-1", jwksSecret, valid), "", http.MethodPost, "/", "user-1", http.StatusOK},
+		{"token from the cookie", "", signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, valid), http.MethodGet, "/api/user/urls", "user-1", http.StatusOK},
+		{"foreign RSA key", "Bearer " + signToken(t, jwt.SigningMethodRS256, "rsa-1", otherKey, valid), "", http.MethodPost, "/", "", http.StatusUnauthorized},
+		{"unknown kid", "Bearer " + signToken(t, jwt.SigningMethodRS256, "rsa-2", rsaKey, valid), "", http.MethodPost, "/", "", http.StatusUnauthorized},
+		{"RSA public key as HMAC secret", "Bearer " + rsaPublicAsSecret, "", http.MethodPost, "/", "", http.StatusUnauthorized},
+		{"expired", "Bearer " + signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims("user-1", -time.Hour)), "", http.MethodPost, "/", "", http.StatusUnauthorized},
+		{"without expiry", "Bearer " + signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, noExpiry), "", http.MethodPost, "/", "", http.StatusUnauthorized},
+		{"wrong issuer", "Bearer " + signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, wrongIssuer), "", http.MethodPost, "/", "", http.StatusUnauthorized},
+		{"without subject", "Bearer " + signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims("", time.Hour)), "", http.MethodPost, "/", "", http.StatusUnauthorized},
+		{"bad token on a redirect", "Bearer " + signToken(t, jwt.SigningMethodRS256, "rsa-1", otherKey, valid), "", http.MethodGet, "/abc", "", http.StatusUnauthorized},
+		{"anonymous redirect", "", "", http.MethodGet, "/abc", "", http.StatusOK},
+		{"anonymous create", "", "", http.MethodPost, "/", "", http.StatusUnauthorized},
+		{"anonymous api read", "", "", http.MethodGet, "/api/user/urls", "", http.StatusUnauthorized},
+		{"API token without fallback", "Bearer lsk_token", "", http.MethodPost, "/api/shorten", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var userID string
+			h := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				userID, _ = r.Context().Value(UserIDKey).(string)
+			}))
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "access_token", Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.user, userID)
+			if tt.status == http.StatusUnauthorized {
+				assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestJWTAuthSessionFallback(t *testing.T) {
+	session := newTestAuth("session-key")
+	auth, err := NewJWTAuth(config.JWTConfig{Secret: "shared-secret", Header: "Authorization"}, time.Second)
+	require.NoError(t, err)
+	auth = auth.WithFallback(session)
+
+	var userID string
+	h := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ = r.Context().Value(UserIDKey).(string)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+	require.Len(t, w.Result().Cookies(), 1, "requests without a token get a session")
+	sessionUser := userID
+	assert.NotEmpty(t, sessionUser)
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.AddCookie(w.Result().Cookies()[0])
+	req.Header.Set("Authorization", "Bearer "+signToken(t, jwt.SigningMethodHS256, "", []byte("shared-secret"), jwt.RegisteredClaims{
+		Subject:   "user-1",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, "user-1", userID, "a JWT wins over the session cookie")
+	assert.Empty(t, w.Result().Cookies())
+
+	req = httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("Authorization", "Bearer lsk_token")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "API tokens are checked by the session auth")
+}
+
+func TestLoadJWKSErrors(t *testing.T) {
+	write := func(content string) string {
+		path := filepath.Join(t.TempDir(), "jwks.json")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"missing file", filepath.Join(t.TempDir(), "missing.json")},
+		{"not json", write("keys")},
+		{"no signing keys", write(`{"keys": [{"kty": "EC", "kid": "ec-1"}]}`)},
+		{"bad modulus", write(`{"keys": [{"kty": "RSA", "n": "!!", "e": "AQAB"}]}`)},
+		{"short RSA key", write(`{"keys": [{"kty": "RSA", "n": "AQAB", "e": "AQAB"}]}`)},
+		{"unsupported algorithm", write(`{"keys": [{"kty": "oct", "alg": "HS512", "k": "c2VjcmV0"}]}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadJWKS(tt.path)
+			assert.Error(t, err)
+		})
+	}
+}
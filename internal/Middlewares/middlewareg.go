@@ -3,9 +3,11 @@ package middlewares
 import (
 	"compress/gzip"
 	"io"
-	"log"
 	"net/http"
 	"strings"
+
+	"github.com/Dnlbb/link-shortener/internal/logger"
+	"github.com/Dnlbb/link-shortener/internal/requestid"
 )
 
 type compressWriter struct {
@@ -78,8 +80,8 @@ func GzipMiddleware(next http.Handler) http.Handler {
 			cw := newCompressWriter(w)
 			defer func() {
 				if err := cw.Close(); err != nil {
-					log.Printf("Error closing gzip writer: %v", err)
-					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					logger.FromContext(r.Context()).WithFields(map[string]interface{}{logger.FieldError: err}).Error("Error closing gzip writer")
+					requestid.Error(r.Context(), w, "Internal Server Error", http.StatusInternalServerError)
 				}
 			}()
 			w = cw
@@ -89,8 +91,8 @@ func GzipMiddleware(next http.Handler) http.Handler {
 		if strings.Contains(contentEncoding, "gzip") {
 			cr, err := newCompressReader(r.Body)
 			if err != nil {
-				log.Printf("Error creating gzip reader: %v", err)
-				http.Error(w, "Error decompressing request body", http.StatusInternalServerError)
+				logger.FromContext(r.Context()).WithFields(map[string]interface{}{logger.FieldError: err}).Warn("Error creating gzip reader")
+				requestid.Error(r.Context(), w, "Error decompressing request body", http.StatusInternalServerError)
 				return
 			}
 			r.Body = cr
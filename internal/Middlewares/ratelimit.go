@@ -0,0 +1,197 @@
+package middlewares
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dnlbb/link-shortener/internal/config"
+	"github.com/Dnlbb/link-shortener/internal/logger"
+	"github.com/Dnlbb/link-shortener/internal/requestid"
+)
+
+// Route groups with their own rate limits.
+const (
+	GroupCreate   = "create"
+	GroupBatch    = "batch"
+	GroupRedirect = "redirect"
+	GroupSignup   = "signup"
+)
+
+// Limit is a token bucket holding up to Burst tokens, refilled with Rate
+// tokens per second. A zero Rate means no limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// LimitOf converts the configured requests per period to a Limit.
+func LimitOf(cfg config.RateLimit) Limit {
+	if cfg.Requests <= 0 || cfg.Period <= 0 {
+		return Limit{}
+	}
+	return Limit{Rate: float64(cfg.Requests) / cfg.Period.Seconds(), Burst: cfg.Burst}
+}
+
+// RateLimitResult is the state of a bucket after taking a token.
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long until the next token when the request was
+	// refused, Reset how long until the bucket is full again.
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+// RateLimitStore keeps the buckets. Take must be atomic per key, a store
+// shared by several instances makes the limits global.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (RateLimitResult, error)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// refill adds the tokens accrued since the last request.
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.Rate)
+		b.last = now
+	}
+}
+
+// sweepInterval is how often the in-memory store forgets full buckets.
+const sweepInterval = time.Minute
+
+// MemoryRateLimitStore keeps the buckets of a single instance. Buckets that
+// refilled completely are dropped, they hold nothing a new bucket would not.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.sweep(now)
+	}
+
+	b, exists := s.buckets[key]
+	if !exists || b.limit != limit {
+		b = &bucket{tokens: float64(limit.Burst), last: now, limit: limit}
+		s.buckets[key] = b
+	}
+	b.refill(now)
+
+	result := RateLimitResult{Allowed: b.tokens >= 1}
+	if result.Allowed {
+		b.tokens--
+	} else {
+		result.RetryAfter = secondsDuration((1 - b.tokens) / limit.Rate)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = secondsDuration((float64(limit.Burst) - b.tokens) / limit.Rate)
+	return result, nil
+}
+
+// sweep drops the full buckets, the caller must hold s.mu.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// RateLimiter limits route groups per authenticated user, or per client IP
+// for everyone else. A failing store lets requests through.
+type RateLimiter struct {
+	store             RateLimitStore
+	trustForwardedFor bool
+	now               func() time.Time
+}
+
+func NewRateLimiter(store RateLimitStore, trustForwardedFor bool) *RateLimiter {
+	return &RateLimiter{store: store, trustForwardedFor: trustForwardedFor, now: time.Now}
+}
+
+// Limit returns the middleware of a route group, the zero Limit lets every
+// request through.
+func (l *RateLimiter) Limit(group string, limit Limit) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limit.Rate <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			result, err := l.store.Take(r.Context(), group+"|"+l.clientKey(r), limit, l.now())
+			if err != nil {
+				logger.FromContext(r.Context()).WithFields(map[string]interface{}{logger.FieldError: err}).Error("Error checking the rate limit")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+			h.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+			if !result.Allowed {
+				h.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				requestid.Error(r.Context(), w, "Too Many Requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// clientKey identifies who a request is counted for. Users of API tokens and
+// JWTs get a bucket of their own. Session users are counted by IP, any
+// request returns a new session cookie and rotating them would reset the
+// limit.
+func (l *RateLimiter) clientKey(r *http.Request) string {
+	userID, _ := r.Context().Value(UserIDKey).(string)
+	if authenticated, _ := r.Context().Value(AuthenticatedKey).(bool); userID != "" && authenticated {
+		return "user:" + userID
+	}
+	return "ip:" + l.clientIP(r)
+}
+
+func (l *RateLimiter) clientIP(r *http.Request) string {
+	if l.trustForwardedFor {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
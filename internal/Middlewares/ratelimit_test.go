@@ -0,0 +1,147 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dnlbb/link-shortener/internal/config"
+)
+
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, Limit, time.Time) (RateLimitResult, error) {
+	return RateLimitResult{}, errors.New("store is down")
+}
+
+type limitedRequest struct {
+	remoteAddr    string
+	userID        string
+	authenticated bool
+	forwardedFor  string
+}
+
+func (lr limitedRequest) serve(h http.Handler) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.RemoteAddr = lr.remoteAddr
+	if lr.forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", lr.forwardedFor)
+	}
+	ctx := req.Context()
+	if lr.userID != "" {
+		ctx = context.WithValue(ctx, UserIDKey, lr.userID)
+	}
+	if lr.authenticated {
+		ctx = context.WithValue(ctx, AuthenticatedKey, true)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req.WithContext(ctx))
+	return w
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(), false)
+	limiter.now = func() time.Time { return now }
+	limit := LimitOf(config.RateLimit{Requests: 6, Period: time.Minute, Burst: 2})
+	create := limiter.Limit(GroupCreate, limit)(okHandler)
+	batch := limiter.Limit(GroupBatch, limit)(okHandler)
+	user1 := limitedRequest{remoteAddr: "10.0.0.1:1234", userID: "user-1", authenticated: true}
+
+	w := user1.serve(create)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "10", w.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, http.StatusOK, user1.serve(create).Code)
+
+	w = limitedRequest{remoteAddr: "10.0.0.2:1234", userID: "user-1", authenticated: true}.serve(create)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "users are limited on every IP")
+	assert.Equal(t, "10", w.Header().Get("Retry-After"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+
+	assert.Equal(t, http.StatusOK, limitedRequest{remoteAddr: "10.0.0.1:1234", userID: "user-2", authenticated: true}.serve(create).Code, "other users have their own bucket")
+	assert.Equal(t, http.StatusOK, user1.serve(batch).Code, "groups have their own bucket")
+
+	now = now.Add(10 * time.Second)
+	assert.Equal(t, http.StatusOK, user1.serve(create).Code, "a token is refilled every 10s")
+	assert.Equal(t, http.StatusTooManyRequests, user1.serve(create).Code)
+}
+
+func TestRateLimiterClientIP(t *testing.T) {
+	limit := Limit{Rate: 1, Burst: 1}
+
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(), false)
+	h := limiter.Limit(GroupRedirect, limit)(okHandler)
+	assert.Equal(t, http.StatusOK, limitedRequest{remoteAddr: "10.0.0.1:1", userID: "session-1"}.serve(h).Code)
+	assert.Equal(t, http.StatusTooManyRequests, limitedRequest{remoteAddr: "10.0.0.1:2", userID: "session-2"}.serve(h).Code,
+		"session users are counted by IP")
+	assert.Equal(t, http.StatusTooManyRequests, limitedRequest{remoteAddr: "10.0.0.1:3", forwardedFor: "10.0.0.9"}.serve(h).Code,
+		"X-Forwarded-For is ignored unless trusted")
+
+	limiter = NewRateLimiter(NewMemoryRateLimitStore(), true)
+	h = limiter.Limit(GroupRedirect, limit)(okHandler)
+	assert.Equal(t, http.StatusOK, limitedRequest{remoteAddr: "10.0.0.1:1", forwardedFor: "1.1.1.1, 10.0.0.7"}.serve(h).Code)
+	assert.Equal(t, http.StatusOK, limitedRequest{remoteAddr: "10.0.0.1:1", forwardedFor: "10.0.0.8"}.serve(h).Code)
+	assert.Equal(t, http.StatusTooManyRequests, limitedRequest{remoteAddr: "10.0.0.1:1", forwardedFor: "2.2.2.2, 10.0.0.7"}.serve(h).Code,
+		"the entry of the proxy counts, not the one the client sent")
+}
+
+func TestRateLimiterRotatedSessionCookies(t *testing.T) {
+	auth := newTestAuth("test-key")
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(), false)
+	h := auth.Middleware(limiter.Limit(GroupCreate, Limit{Rate: 0.1, Burst: 2})(okHandler))
+
+	mint := func() *http.Cookie {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		auth.Middleware(okHandler).ServeHTTP(w, req)
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 {
+			t.Fatalf("expected a session cookie, got %d", len(cookies))
+		}
+		return cookies[0]
+	}
+
+	var codes []int
+	for i := 0; i < 4; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.AddCookie(mint())
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests}, codes,
+		"a fresh cookie per request does not get a fresh bucket")
+}
+
+func TestRateLimiterPassThrough(t *testing.T) {
+	limiter := NewRateLimiter(failingStore{}, false)
+	w := limitedRequest{remoteAddr: "10.0.0.1:1"}.serve(limiter.Limit(GroupCreate, Limit{Rate: 1, Burst: 1})(okHandler))
+	assert.Equal(t, http.StatusOK, w.Code, "a failing store does not block requests")
+
+	w = limitedRequest{remoteAddr: "10.0.0.1:1"}.serve(limiter.Limit(GroupCreate, LimitOf(config.RateLimit{}))(okHandler))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"), "disabled limits add no headers")
+}
+
+func TestMemoryRateLimitStoreSweeps(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	now := time.Unix(1_700_000_000, 0)
+	limit := Limit{Rate: 1, Burst: 5}
+	store.Take(context.Background(), "a", limit, now)
+	store.Take(context.Background(), "b", limit, now.Add(50*time.Second))
+	assert.Len(t, store.buckets, 2)
+
+	store.Take(context.Background(), "b", limit, now.Add(61*time.Second))
+	assert.Len(t, store.buckets, 1, "refilled buckets are dropped")
+}
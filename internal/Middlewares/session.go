@@ -0,0 +1,121 @@
+package middlewares
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// sessionVersion prefixes the cookies of the current format:
+// v1.<kid>.<base64url payload>.<base64url HMAC-SHA256 of the first three parts>.
+const sessionVersion = "v1"
+
+// clockSkew tolerates session issue times slightly in the future.
+const clockSkew = time.Minute
+
+var (
+	errSessionFormat    = errors.New("invalid session format")
+	errSessionKey       = errors.New("unknown session key")
+	errSessionSignature = errors.New("invalid session signature")
+	errSessionExpired   = errors.New("session expired")
+)
+
+// session is the signed payload of the session cookie.
+type session struct {
+	UserID    string `json:"uid"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+
+	// keyID is the kid the cookie was signed with, legacy cookies have none.
+	keyID string
+}
+
+// keyring holds the current signing key and the previous keys that still
+// verify sessions, by key ID.
+type keyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+func newKeyring(current string, previous []string) keyring {
+	k := keyring{current: keyID(current), keys: make(map[string][]byte, len(previous)+1)}
+	for _, key := range previous {
+		k.keys[keyID(key)] = []byte(key)
+	}
+	k.keys[k.current] = []byte(current)
+	return k
+}
+
+// keyID derives the kid of a key, so rotating needs no further settings.
+func keyID(key string) string {
+	sum := sha256.Sum256([]byte("session-kid|" + key))
+	return hex.EncodeToString(sum[:4])
+}
+
+func (k keyring) sign(kid string, data []byte) []byte {
+	h := hmac.New(sha256.New, k.keys[kid])
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// encode signs the session with the current key.
+func (k keyring) encode(s session) (string, error) {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	data := sessionVersion + "." + k.current + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return data + "." + base64.RawURLEncoding.EncodeToString(k.sign(k.current, []byte(data))), nil
+}
+
+// decode verifies the signature and the lifetime of a session cookie.
+func (k keyring) decode(value string, now time.Time) (session, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 4 || parts[0] != sessionVersion {
+		return session{}, errSessionFormat
+	}
+	kid := parts[1]
+	if _, ok := k.keys[kid]; !ok {
+		return session{}, errSessionKey
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return session{}, errSessionFormat
+	}
+	if !hmac.Equal(signature, k.sign(kid, []byte(strings.Join(parts[:3], ".")))) {
+		return session{}, errSessionSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return session{}, errSessionFormat
+	}
+	var s session
+	if err := json.Unmarshal(payload, &s); err != nil || s.UserID == "" {
+		return session{}, errSessionFormat
+	}
+	if !now.Before(time.Unix(s.ExpiresAt, 0)) || time.Unix(s.IssuedAt, 0).After(now.Add(clockSkew)) {
+		return session{}, errSessionExpired
+	}
+	s.keyID = kid
+	return s, nil
+}
+
+// decodeLegacy verifies a "uid|signature" cookie of the old format against
+// the current key only, the old format predates key rotation.
+func (k keyring) decodeLegacy(value string) (session, error) {
+	userID, signature, ok := strings.Cut(value, "|")
+	if !ok || userID == "" {
+		return session{}, errSessionFormat
+	}
+	expected := base64.StdEncoding.EncodeToString(k.sign(k.current, []byte(userID)))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return session{}, errSessionSignature
+	}
+	return session{UserID: userID}, nil
+}
@@ -0,0 +1,180 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Dnlbb/link-shortener/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAuth(key string, previous ...string) *SessionAuth {
+	return NewSessionAuth(config.AuthConfig{
+		Key:            key,
+		PreviousKeys:   previous,
+		CookieTTL:      time.Hour,
+		CookieSecure:   true,
+		CookieSameSite: config.SameSiteStrict,
+	}, time.Second)
+}
+
+// serve runs a request with the cookie through the middleware and returns
+// the user ID it saw and the cookie it set, if any.
+func serve(t *testing.T, a *SessionAuth, path, cookie string) (string, *http.Cookie, int) {
+	t.Helper()
+	var userID string
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ = r.Context().Value(UserIDKey).(string)
+	}))
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if cookie != "" {
+		req.AddCookie(&http.Cookie{Name: sessionCookie, Value: cookie})
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	var set *http.Cookie
+	if cookies := w.Result().Cookies(); len(cookies) > 0 {
+		set = cookies[0]
+	}
+	return userID, set, w.Code
+}
+
+func TestSessionCookie(t *testing.T) {
+	a := newTestAuth("current")
+	now := time.Unix(1_700_000_000, 0)
+	a.now = func() time.Time { return now }
+
+	userID, cookie, _ := serve(t, a, "/", "")
+	require.NotEmpty(t, userID)
+	require.NotNil(t, cookie)
+	assert.True(t, cookie.HttpOnly)
+	assert.True(t, cookie.Secure)
+	assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite)
+	assert.Equal(t, "/", cookie.Path)
+	assert.Equal(t, 3600, cookie.MaxAge)
+	assert.True(t, strings.HasPrefix(cookie.Value, sessionVersion+"."+keyID("current")+"."))
+
+	got, refreshed, _ := serve(t, a, "/", cookie.Value)
+	assert.Equal(t, userID, got)
+	assert.Nil(t, refreshed, "a fresh session is not re-issued")
+
+	now = now.Add(40 * time.Minute)
+	got, refreshed, _ = serve(t, a, "/", cookie.Value)
+	assert.Equal(t, userID, got)
+	require.NotNil(t, refreshed, "sessions past half of their lifetime slide")
+	s, err := a.keys.decode(refreshed.Value, now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(time.Hour).Unix(), s.ExpiresAt)
+
+	now = now.Add(2 * time.Hour)
+	got, _, _ = serve(t, a, "/", refreshed.Value)
+	assert.NotEqual(t, userID, got, "expired sessions start over")
+	_, _, code := serve(t, a, "/api/user/urls", refreshed.Value)
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestSessionRequiredForUserPaths(t *testing.T) {
+	a := newTestAuth("current")
+	for _, path := range []string{"/api/user", "/api/users", "/api/user/urls", "/api/user/urls/abc12345/stats", "/api/user/quota", "/api/user/tokens"} {
+		userID, cookie, code := serve(t, a, path, "")
+		assert.Equal(t, http.StatusUnauthorized, code, path)
+		assert.Empty(t, userID, path)
+		assert.Nil(t, cookie, path)
+
+		_, _, code = serve(t, a, path, "tampered")
+		assert.Equal(t, http.StatusUnauthorized, code, path)
+	}
+	_, cookie, code := serve(t, a, "/api/shorten", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotNil(t, cookie, "other paths start a session")
+}
+
+func TestSessionCookieRejectsTampering(t *testing.T) {
+	a := newTestAuth("current")
+	w := httptest.NewRecorder()
+	a.CreateCookie(w)
+	value := w.Result().Cookies()[0].Value
+	parts := strings.Split(value, ".")
+
+	forged, err := newTestAuth("attacker").keys.encode(session{UserID: "victim", IssuedAt: time.Now().Unix(), ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	require.NoError(t, err)
+	forgedParts := strings.Split(forged, ".")
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"swapped payload", strings.Join([]string{parts[0], parts[1], forgedParts[2], parts[3]}, ".")},
+		{"foreign key", forged},
+		{"claimed kid", strings.Join([]string{parts[0], parts[1], forgedParts[2], forgedParts[3]}, ".")},
+		{"other version", "v0" + value[len(sessionVersion):]},
+		{"truncated", strings.Join(parts[:3], ".")},
+		{"legacy with a bad signature", "victim|c2lnbmF0dXJl"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.ExtractUserIDFromCookie(cookieRequest(tt.value))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSessionKeyRotation(t *testing.T) {
+	old := newTestAuth("old")
+	w := httptest.NewRecorder()
+	userID := old.CreateCookie(w)
+	oldCookie := w.Result().Cookies()[0].Value
+
+	rotated := newTestAuth("new", "old")
+	got, refreshed, _ := serve(t, rotated, "/", oldCookie)
+	assert.Equal(t, userID, got, "sessions of a previous key stay valid")
+	require.NotNil(t, refreshed, "and are re-signed with the current key")
+	assert.Contains(t, refreshed.Value, "."+keyID("new")+".")
+
+	got, _, _ = serve(t, newTestAuth("new"), "/", oldCookie)
+	assert.NotEqual(t, userID, got, "dropped keys no longer verify")
+}
+
+func TestSessionLegacyCookies(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	newAuth := func(until string, keys ...string) *SessionAuth {
+		a := NewSessionAuth(config.AuthConfig{
+			Key:                keys[0],
+			PreviousKeys:       keys[1:],
+			LegacyCookiesUntil: until,
+			CookieTTL:          time.Hour,
+		}, time.Second)
+		a.now = func() time.Time { return now }
+		return a
+	}
+
+	a := newAuth("2026-07-01", "current", "old")
+	legacy := "user1|" + a.SignData("user1")
+	got, refreshed, _ := serve(t, a, "/", legacy)
+	assert.Equal(t, "user1", got)
+	require.NotNil(t, refreshed, "legacy cookies are upgraded")
+	assert.True(t, strings.HasPrefix(refreshed.Value, sessionVersion+"."))
+
+	signedWithOld := "user1|" + newAuth("2026-07-01", "old").SignData("user1")
+	got, _, _ = serve(t, a, "/", signedWithOld)
+	assert.NotEqual(t, "user1", got, "previous keys do not verify legacy cookies")
+
+	now = time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	got, _, _ = serve(t, a, "/", legacy)
+	assert.NotEqual(t, "user1", got, "legacy cookies are rejected from the cutoff")
+	_, _, code := serve(t, a, "/api/user/urls", legacy)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	got, _, _ = serve(t, newAuth("", "current"), "/", legacy)
+	assert.NotEqual(t, "user1", got, "and never accepted without a cutoff")
+}
+
+func cookieRequest(value string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: value})
+	return req
+}
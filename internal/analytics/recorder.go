@@ -0,0 +1,250 @@
+package analytics
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Dnlbb/link-shortener/internal/logger"
+	"github.com/Dnlbb/link-shortener/internal/models"
+)
+
+// Repository is the part of storage.Repository the recorder needs.
+type Repository interface {
+	RecordClicks(ctx context.Context, counts []models.ClickCount) error
+}
+
+type Config struct {
+	QueueSize int
+	// BatchSize is the number of distinct (link, day) counters that triggers
+	// a flush before FlushInterval has passed.
+	BatchSize     int
+	FlushInterval time.Duration
+	// FlushTimeout bounds a single RecordClicks call.
+	FlushTimeout time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		QueueSize:     4096,
+		BatchSize:     500,
+		FlushInterval: 5 * time.Second,
+		FlushTimeout:  10 * time.Second,
+	}
+}
+
+type counterKey struct {
+	domain   string
+	shortURL string
+	day      time.Time
+}
+
+// counter holds the clicks of a link during one day, the hashes of the
+// addresses they came from and their referring hosts and user agents.
+type counter struct {
+	clicks     int64
+	ipHashes   map[string]struct{}
+	referers   map[string]int64
+	userAgents map[string]int64
+}
+
+// maxUserAgentLength bounds the user agents kept with the counters.
+const maxUserAgentLength = 256
+
+// RefererHost returns the host of the referring page, the rest of the URL may
+// carry tokens and is not kept. It is empty for direct visits.
+func RefererHost(referer string) string {
+	u, err := url.Parse(referer)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// Recorder counts clicks asynchronously. Events are queued without blocking
+// the redirect, a single worker aggregates them into per-day counters with
+// their visitors and writes the counters with one RecordClicks call per
+// flush.
+type Recorder struct {
+	repo   Repository
+	cfg    Config
+	events chan models.ClickEvent
+
+	mu      sync.RWMutex
+	closed  bool
+	dropped atomic.Int64
+	wg      sync.WaitGroup
+}
+
+func NewRecorder(repo Repository, cfg Config) *Recorder {
+	def := DefaultConfig()
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = def.QueueSize
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = def.BatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = def.FlushInterval
+	}
+	if cfg.FlushTimeout <= 0 {
+		cfg.FlushTimeout = def.FlushTimeout
+	}
+
+	r := &Recorder{
+		repo:   repo,
+		cfg:    cfg,
+		events: make(chan models.ClickEvent, cfg.QueueSize),
+	}
+	r.wg.Add(1)
+	go r.worker()
+	return r
+}
+
+// Record queues the click. Clicks are dropped when the queue is full or the
+// recorder is closed, a redirect never waits for analytics.
+func (r *Recorder) Record(event models.ClickEvent) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		r.dropped.Add(1)
+		return
+	}
+	select {
+	case r.events <- event:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+// Len returns the number of queued clicks not yet aggregated.
+func (r *Recorder) Len() int {
+	return len(r.events)
+}
+
+// Dropped returns the number of clicks lost because the queue was full.
+func (r *Recorder) Dropped() int64 {
+	return r.dropped.Load()
+}
+
+// Close stops accepting clicks and waits until the queued ones are written
+// or ctx is done.
+func (r *Recorder) Close(ctx context.Context) error {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.events)
+	}
+	r.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Recorder) worker() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	pending := make(map[counterKey]*counter)
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		counts := make([]models.ClickCount, 0, len(pending))
+		for k, c := range pending {
+			ipHashes := make([]string, 0, len(c.ipHashes))
+			for ipHash := range c.ipHashes {
+				ipHashes = append(ipHashes, ipHash)
+			}
+			sort.Strings(ipHashes)
+			counts = append(counts, models.ClickCount{
+				Domain:     k.domain,
+				ShortURL:   k.shortURL,
+				Day:        k.day,
+				Clicks:     c.clicks,
+				IPHashes:   ipHashes,
+				Referers:   c.referers,
+				UserAgents: c.userAgents,
+			})
+		}
+		r.write(counts)
+		pending = make(map[counterKey]*counter)
+	}
+
+	for {
+		select {
+		case event, ok := <-r.events:
+			if !ok {
+				flush()
+				return
+			}
+			y, m, d := event.At.UTC().Date()
+			key := counterKey{domain: event.Domain, shortURL: event.ShortURL, day: time.Date(y, m, d, 0, 0, 0, 0, time.UTC)}
+			c, ok := pending[key]
+			if !ok {
+				c = &counter{ipHashes: make(map[string]struct{}), referers: make(map[string]int64), userAgents: make(map[string]int64)}
+				pending[key] = c
+			}
+			c.clicks++
+			if event.IPHash != "" {
+				c.ipHashes[event.IPHash] = struct{}{}
+			}
+			if host := RefererHost(event.Referer); host != "" {
+				c.referers[host]++
+			}
+			if userAgent := event.UserAgent; userAgent != "" {
+				if len(userAgent) > maxUserAgentLength {
+					userAgent = userAgent[:maxUserAgentLength]
+				}
+				c.userAgents[userAgent]++
+			}
+			if len(pending) >= r.cfg.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (r *Recorder) write(counts []models.ClickCount) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.FlushTimeout)
+	defer cancel()
+	if err := r.repo.RecordClicks(ctx, counts); err != nil {
+		logger.FromContext(ctx).WithFields(map[string]interface{}{"links": len(counts), logger.FieldError: err}).Error("Error recording clicks")
+	}
+}
+
+// NewSalt returns a random salt for HashIP.
+func NewSalt() (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(salt), nil
+}
+
+// HashIP returns a salted hash of the client address, so unique visitors can
+// be told apart without keeping their addresses.
+func HashIP(ip, salt string) string {
+	sum := sha256.Sum256([]byte(salt + "|" + ip))
+	return hex.EncodeToString(sum[:16])
+}
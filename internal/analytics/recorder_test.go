@@ -0,0 +1,119 @@
+package analytics
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dnlbb/link-shortener/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRepo struct {
+	mu         sync.Mutex
+	calls      int
+	clicks     map[string]int64
+	visitors   map[string][]string
+	referers   map[string]int64
+	userAgents map[string]int64
+}
+
+func (f *fakeRepo) RecordClicks(ctx context.Context, counts []models.ClickCount) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	for _, c := range counts {
+		key := c.ShortURL + " " + c.Day.Format(time.DateOnly)
+		f.clicks[key] += c.Clicks
+		if f.visitors != nil {
+			f.visitors[key] = append(f.visitors[key], c.IPHashes...)
+		}
+		if f.referers != nil {
+			for name, clicks := range c.Referers {
+				f.referers[name] += clicks
+			}
+			for name, clicks := range c.UserAgents {
+				f.userAgents[name] += clicks
+			}
+		}
+	}
+	return nil
+}
+
+func (f *fakeRepo) total(key string) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.clicks[key]
+}
+
+func TestRecorderAggregatesOnClose(t *testing.T) {
+	repo := &fakeRepo{clicks: make(map[string]int64)}
+	r := NewRecorder(repo, Config{FlushInterval: time.Hour})
+
+	day1 := time.Date(2024, 3, 1, 23, 59, 0, 0, time.UTC)
+	day2 := day1.Add(2 * time.Minute)
+	for i := 0; i < 3; i++ {
+		r.Record(models.ClickEvent{ShortURL: "abc12345", At: day1})
+	}
+	r.Record(models.ClickEvent{ShortURL: "abc12345", At: day2})
+	r.Record(models.ClickEvent{ShortURL: "def67890", At: day2})
+
+	require.NoError(t, r.Close(context.Background()))
+	assert.Equal(t, 1, repo.calls)
+	assert.Equal(t, int64(3), repo.total("abc12345 2024-03-01"))
+	assert.Equal(t, int64(1), repo.total("abc12345 2024-03-02"))
+	assert.Equal(t, int64(1), repo.total("def67890 2024-03-02"))
+
+	r.Record(models.ClickEvent{ShortURL: "abc12345", At: day1})
+	assert.Equal(t, int64(1), r.Dropped())
+}
+
+func TestRecorderCollectsVisitors(t *testing.T) {
+	repo := &fakeRepo{clicks: make(map[string]int64), visitors: make(map[string][]string)}
+	r := NewRecorder(repo, Config{FlushInterval: time.Hour})
+
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, ipHash := range []string{"b", "a", "b", ""} {
+		r.Record(models.ClickEvent{ShortURL: "abc12345", At: at, IPHash: ipHash})
+	}
+
+	require.NoError(t, r.Close(context.Background()))
+	assert.Equal(t, int64(4), repo.total("abc12345 2024-03-01"))
+	assert.Equal(t, []string{"a", "b"}, repo.visitors["abc12345 2024-03-01"])
+}
+
+func TestRecorderCountsSources(t *testing.T) {
+	repo := &fakeRepo{clicks: make(map[string]int64), referers: make(map[string]int64), userAgents: make(map[string]int64)}
+	r := NewRecorder(repo, Config{FlushInterval: time.Hour})
+
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	r.Record(models.ClickEvent{ShortURL: "abc12345", At: at, Referer: "https://News.example.com/a?token=secret", UserAgent: "agent/1"})
+	r.Record(models.ClickEvent{ShortURL: "abc12345", At: at, Referer: "https://news.example.com/b", UserAgent: "agent/1"})
+	r.Record(models.ClickEvent{ShortURL: "abc12345", At: at, UserAgent: strings.Repeat("x", 1000)})
+
+	require.NoError(t, r.Close(context.Background()))
+	assert.Equal(t, map[string]int64{"news.example.com": 2}, repo.referers, "only the host of a referer is kept")
+	assert.Equal(t, map[string]int64{"agent/1": 2, strings.Repeat("x", maxUserAgentLength): 1}, repo.userAgents)
+}
+
+func TestRecorderFlushesOnInterval(t *testing.T) {
+	repo := &fakeRepo{clicks: make(map[string]int64)}
+	r := NewRecorder(repo, Config{FlushInterval: 10 * time.Millisecond})
+	defer r.Close(context.Background())
+
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	r.Record(models.ClickEvent{ShortURL: "abc12345", At: at})
+	assert.Eventually(t, func() bool {
+		return repo.total("abc12345 2024-03-01") == 1
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestHashIP(t *testing.T) {
+	assert.Equal(t, HashIP("192.0.2.1", "salt"), HashIP("192.0.2.1", "salt"))
+	assert.NotEqual(t, HashIP("192.0.2.1", "salt"), HashIP("192.0.2.2", "salt"))
+	assert.NotEqual(t, HashIP("192.0.2.1", "salt"), HashIP("192.0.2.1", "other"))
+	assert.NotContains(t, HashIP("192.0.2.1", "salt"), "192.0.2.1")
+}
@@ -1,23 +1,762 @@
 package config
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/Dnlbb/link-shortener/internal/generator"
+)
+
+const (
+	BackendMemory   = "memory"
+	BackendFile     = "file"
+	BackendPostgres = "postgres"
+)
+
+type ServerConfig struct {
+	Address string `yaml:"address"`
+	BaseURL string `yaml:"base_url"`
+	// AliasHosts are further host[:port] values short links are accepted on.
+	AliasHosts []string `yaml:"alias_hosts"`
+	// Domains are extra host[:port] values links can be created on, each of
+	// them with its own codes.
+	Domains         []string      `yaml:"domains"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// RequestTimeout bounds the context of a single request.
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+}
+
+type StorageConfig struct {
+	// Backend is memory, file or postgres. When empty it is chosen from
+	// DatabaseDSN and FilePath, see ResolveBackend.
+	Backend         string        `yaml:"backend"`
+	FilePath        string        `yaml:"file_path"`
+	DatabaseDSN     string        `yaml:"database_dsn"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+}
+
+// Auth modes: session identifies users by the signed session cookie, jwt by
+// a JWT issued by another service.
+const (
+	AuthSession = "session"
+	AuthJWT     = "jwt"
+)
+
+// SameSite values of the session cookie.
+const (
+	SameSiteLax    = "lax"
+	SameSiteStrict = "strict"
+	SameSiteNone   = "none"
 )
 
-type ConfigFlags struct {
-	Start  string
-	Result string
-	File   string
-	DB     string
-	Key    string
+type AuthConfig struct {
+	// Mode is session or jwt.
+	Mode string `yaml:"mode"`
+	// SessionFallback lets requests without a JWT use the session cookie in
+	// the jwt mode.
+	SessionFallback bool      `yaml:"session_fallback"`
+	JWT             JWTConfig `yaml:"jwt"`
+	// Key signs the session cookies.
+	Key string `yaml:"key"`
+	// PreviousKeys still verify the sessions signed before a key rotation,
+	// such sessions are re-signed with Key on their next request.
+	PreviousKeys []string `yaml:"previous_keys"`
+	// LegacyCookiesUntil is the date, YYYY-MM-DD in UTC, from which cookies of
+	// the old "uid|signature" format are no longer accepted. They carry no
+	// expiry, so they are only verified with Key and never when empty.
+	LegacyCookiesUntil string `yaml:"legacy_cookies_until"`
+	// CookieTTL is how long a session lasts without requests, every request
+	// in its second half extends it.
+	CookieTTL time.Duration `yaml:"cookie_ttl"`
+	// CookieSecure sends the cookie over HTTPS only, it is always set when
+	// the base URL is https.
+	CookieSecure   bool   `yaml:"cookie_secure"`
+	CookieSameSite string `yaml:"cookie_same_site"`
+}
+
+// JWTConfig verifies the JWTs of the jwt auth mode. HS256 tokens are checked
+// with Secret or the oct keys of the JWKS, RS256 tokens with its RSA keys.
+type JWTConfig struct {
+	Secret   string `yaml:"secret"`
+	JWKSFile string `yaml:"jwks_file"`
+	// Header carries the token, a Bearer scheme is stripped. Cookie is an
+	// optional cookie read when the header is missing.
+	Header   string `yaml:"header"`
+	Cookie   string `yaml:"cookie"`
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+	// Leeway tolerates clock drift when checking exp, nbf and iat.
+	Leeway time.Duration `yaml:"leeway"`
+}
+
+// CacheConfig puts an LRU cache of link lookups in front of the storage.
+type CacheConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Size    int           `yaml:"size"`
+	TTL     time.Duration `yaml:"ttl"`
+	// NegativeTTL is how long unknown codes are remembered, zero disables
+	// caching them.
+	NegativeTTL time.Duration `yaml:"negative_ttl"`
+}
+
+type DeleterConfig struct {
+	Workers       int           `yaml:"workers"`
+	QueueSize     int           `yaml:"queue_size"`
+	BatchSize     int           `yaml:"batch_size"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	FlushTimeout  time.Duration `yaml:"flush_timeout"`
+}
+
+// GeneratorConfig picks the short code strategy. NodeID numbers the instance
+// in the snowflake codes, instances sharing a storage need different ones.
+type GeneratorConfig struct {
+	Strategy string `yaml:"strategy"`
+	NodeID   int    `yaml:"node_id"`
+}
+
+type ReaperConfig struct {
+	Interval  time.Duration `yaml:"interval"`
+	Retention time.Duration `yaml:"retention"`
+}
+
+type AnalyticsConfig struct {
+	QueueSize     int           `yaml:"queue_size"`
+	BatchSize     int           `yaml:"batch_size"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	// IPSalt salts the hashes of the client addresses unique visitors are
+	// counted by. A random one is used when empty, visitors are then told
+	// apart per process run only.
+	IPSalt string `yaml:"ip_salt"`
+}
+
+// RateLimit is a token bucket of Burst requests refilled with Requests per
+// Period. Zero Requests disables the limit.
+type RateLimit struct {
+	Requests int           `yaml:"requests"`
+	Period   time.Duration `yaml:"period"`
+	Burst    int           `yaml:"burst"`
+}
+
+// RateLimitConfig limits each route group per API token or JWT user, or per
+// client IP for session and anonymous requests.
+type RateLimitConfig struct {
+	Create   RateLimit `yaml:"create"`
+	Batch    RateLimit `yaml:"batch"`
+	Redirect RateLimit `yaml:"redirect"`
+	Signup   RateLimit `yaml:"signup"`
+	// TrustForwardedFor takes the client IP from the last X-Forwarded-For
+	// entry, only enable it behind a proxy that sets the header.
+	TrustForwardedFor bool `yaml:"trust_forwarded_for"`
+}
+
+// Quota limits the links of one owner, zero means unlimited.
+type Quota struct {
+	// MaxLinks caps the links that are neither deleted nor expired,
+	// MaxBatch the links of one batch request.
+	MaxLinks int `yaml:"max_links"`
+	MaxBatch int `yaml:"max_batch"`
+}
+
+// QuotaConfig assigns the quotas. Users maps user IDs to the name of their
+// tier in Tiers, everyone else gets Default. Tiers are read from the file
+// only.
+type QuotaConfig struct {
+	Default Quota             `yaml:"default"`
+	Tiers   map[string]Quota  `yaml:"tiers"`
+	Users   map[string]string `yaml:"users"`
+}
+
+// MetricsConfig exposes the metrics on /metrics of a separate admin listener.
+// They are off while Address is empty, the main server never serves them.
+type MetricsConfig struct {
+	Address string `yaml:"address"`
+}
+
+const (
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// TracingConfig turns on spans for the requests and storage calls. The
+// traces started here keep SampleRatio of them, incoming traceparent headers
+// decide for the traces started upstream.
+type TracingConfig struct {
+	Enabled     bool    `yaml:"enabled"`
+	Exporter    string  `yaml:"exporter"`
+	File        string  `yaml:"file"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+const (
+	LogDebug = "debug"
+	LogInfo  = "info"
+	LogWarn  = "warn"
+	LogError = "error"
+)
+
+// LogConfig sets the lowest level written and which values are hidden from
+// the logs.
+type LogConfig struct {
+	Level         string `yaml:"level"`
+	RedactURLs    bool   `yaml:"redact_urls"`
+	RedactUserIDs bool   `yaml:"redact_user_ids"`
+}
+
+// Config holds every tunable of the service. It is read from the defaults, a
+// YAML or JSON file, the flags and the environment, each source overriding
+// the previous one.
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Cache     CacheConfig     `yaml:"cache"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Generator GeneratorConfig `yaml:"generator"`
+	Deleter   DeleterConfig   `yaml:"deleter"`
+	Reaper    ReaperConfig    `yaml:"reaper"`
+	Analytics AnalyticsConfig `yaml:"analytics"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Quota     QuotaConfig     `yaml:"quota"`
+	Metrics   MetricsConfig   `yaml:"metrics"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+	Log       LogConfig       `yaml:"log"`
+}
+
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Address:         ":8080",
+			BaseURL:         "http://localhost:8080",
+			AliasHosts:      []string{"127.0.0.1:8080"},
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    35 * time.Second,
+			IdleTimeout:     2 * time.Minute,
+			ShutdownTimeout: 15 * time.Second,
+			RequestTimeout:  30 * time.Second,
+		},
+		Storage: StorageConfig{
+			FilePath:        "./tmp/short-url-db.json",
+			MaxOpenConns:    20,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+		},
+		Cache: CacheConfig{
+			Size:        10000,
+			TTL:         5 * time.Minute,
+			NegativeTTL: 30 * time.Second,
+		},
+		Auth: AuthConfig{
+			Mode: AuthSession,
+			JWT: JWTConfig{
+				Header: "Authorization",
+				Leeway: 30 * time.Second,
+			},
+			CookieTTL:      24 * time.Hour,
+			CookieSameSite: SameSiteLax,
+		},
+		Generator: GeneratorConfig{
+			Strategy: generator.StrategyHash,
+		},
+		Deleter: DeleterConfig{
+			Workers:       4,
+			QueueSize:     1024,
+			BatchSize:     100,
+			FlushInterval: time.Second,
+			FlushTimeout:  10 * time.Second,
+		},
+		Reaper: ReaperConfig{
+			Interval:  time.Minute,
+			Retention: 24 * time.Hour,
+		},
+		Analytics: AnalyticsConfig{
+			QueueSize:     4096,
+			BatchSize:     500,
+			FlushInterval: 5 * time.Second,
+		},
+		RateLimit: RateLimitConfig{
+			Create:   RateLimit{Requests: 60, Period: time.Minute, Burst: 20},
+			Batch:    RateLimit{Requests: 10, Period: time.Minute, Burst: 5},
+			Redirect: RateLimit{Requests: 600, Period: time.Minute, Burst: 100},
+			Signup:   RateLimit{Requests: 5, Period: time.Hour, Burst: 3},
+		},
+		Quota: QuotaConfig{
+			Default: Quota{MaxLinks: 10000, MaxBatch: 1000},
+		},
+		Tracing: TracingConfig{
+			Exporter:    ExporterStdout,
+			SampleRatio: 1,
+		},
+		Log: LogConfig{
+			Level:         LogInfo,
+			RedactURLs:    true,
+			RedactUserIDs: true,
+		},
+	}
+}
+
+// option binds a field to its flag and environment variable. value is a
+// *string, *[]string, *int, *bool or *time.Duration. Lists are comma
+// separated.
+type option struct {
+	flag  string
+	env   string
+	usage string
+	value interface{}
+}
+
+func (c *Config) options() []option {
+	return []option{
+		{"a", "SERVER_ADDRESS", "Address and port to run server.", &c.Server.Address},
+		{"b", "BASE_URL", "The server address before the short url.", &c.Server.BaseURL},
+		{"alias-hosts", "ALIAS_HOSTS", "Comma separated hosts short links are accepted on besides the base URL host.", &c.Server.AliasHosts},
+		{"domains", "DOMAINS", "Comma separated extra domains short links can be created on.", &c.Server.Domains},
+		{"read-timeout", "READ_TIMEOUT", "Maximum duration for reading a request.", &c.Server.ReadTimeout},
+		{"write-timeout", "WRITE_TIMEOUT", "Maximum duration for writing a response.", &c.Server.WriteTimeout},
+		{"idle-timeout", "IDLE_TIMEOUT", "How long keep-alive connections stay idle.", &c.Server.IdleTimeout},
+		{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "Grace period for in-flight requests and workers on shutdown.", &c.Server.ShutdownTimeout},
+		{"request-timeout", "REQUEST_TIMEOUT", "Maximum duration of a single request.", &c.Server.RequestTimeout},
+
+		{"storage", "STORAGE_BACKEND", "Storage backend: memory, file or postgres, chosen from -d and -f when empty.", &c.Storage.Backend},
+		{"f", "FILE_STORAGE_PATH", "The path to the file to save.", &c.Storage.FilePath},
+		{"d", "DATABASE_DSN", "The path to the postgresql.", &c.Storage.DatabaseDSN},
+		{"db-max-open-conns", "DB_MAX_OPEN_CONNS", "Maximum number of open database connections, 0 is unlimited.", &c.Storage.MaxOpenConns},
+		{"db-max-idle-conns", "DB_MAX_IDLE_CONNS", "Maximum number of idle database connections.", &c.Storage.MaxIdleConns},
+		{"db-conn-max-lifetime", "DB_CONN_MAX_LIFETIME", "Maximum lifetime of a database connection, 0 is unlimited.", &c.Storage.ConnMaxLifetime},
+
+		{"cache", "CACHE_ENABLED", "Cache link lookups in memory.", &c.Cache.Enabled},
+		{"cache-size", "CACHE_SIZE", "Number of cached links.", &c.Cache.Size},
+		{"cache-ttl", "CACHE_TTL", "How long a cached link is served.", &c.Cache.TTL},
+		{"cache-negative-ttl", "CACHE_NEGATIVE_TTL", "How long unknown codes are cached, 0 disables it.", &c.Cache.NegativeTTL},
+
+		{"auth-mode", "AUTH_MODE", "Authentication mode: session or jwt.", &c.Auth.Mode},
+		{"session-fallback", "SESSION_FALLBACK", "In the jwt mode identify requests without a token by the session cookie.", &c.Auth.SessionFallback},
+		{"jwt-secret", "JWT_SECRET", "Shared secret verifying HS256 tokens.", &c.Auth.JWT.Secret},
+		{"jwt-jwks-file", "JWT_JWKS_FILE", "Path to a JWKS file with the keys verifying tokens.", &c.Auth.JWT.JWKSFile},
+		{"jwt-header", "JWT_HEADER", "Header carrying the token.", &c.Auth.JWT.Header},
+		{"jwt-cookie", "JWT_COOKIE", "Cookie carrying the token when the header is missing.", &c.Auth.JWT.Cookie},
+		{"jwt-issuer", "JWT_ISSUER", "Required iss claim, any issuer when empty.", &c.Auth.JWT.Issuer},
+		{"jwt-audience", "JWT_AUDIENCE", "Required aud claim, any audience when empty.", &c.Auth.JWT.Audience},
+		{"jwt-leeway", "JWT_LEEWAY", "Allowed clock drift when checking token times.", &c.Auth.JWT.Leeway},
+		{"k", "KEY", "The key signing the session cookies.", &c.Auth.Key},
+		{"previous-keys", "PREVIOUS_KEYS", "Comma separated former keys that still verify session cookies.", &c.Auth.PreviousKeys},
+		{"legacy-cookies-until", "LEGACY_COOKIES_UNTIL", "Date (YYYY-MM-DD) from which cookies of the old format are rejected, never accepted when empty.", &c.Auth.LegacyCookiesUntil},
+		{"cookie-ttl", "COOKIE_TTL", "How long a session cookie lasts without requests.", &c.Auth.CookieTTL},
+		{"cookie-secure", "COOKIE_SECURE", "Send the session cookie over HTTPS only.", &c.Auth.CookieSecure},
+		{"cookie-same-site", "COOKIE_SAME_SITE", "SameSite attribute of the session cookie: lax, strict or none.", &c.Auth.CookieSameSite},
+
+		{"g", "SHORT_CODE_GENERATOR", "Short code generation strategy: hash, random, snowflake or sequence.", &c.Generator.Strategy},
+		{"generator-node-id", "GENERATOR_NODE_ID", "Node number of the instance in snowflake codes, from 0 to 1023.", &c.Generator.NodeID},
+
+		{"delete-workers", "DELETE_WORKERS", "Number of workers deleting links.", &c.Deleter.Workers},
+		{"delete-queue-size", "DELETE_QUEUE_SIZE", "Number of queued links to delete.", &c.Deleter.QueueSize},
+		{"delete-batch-size", "DELETE_BATCH_SIZE", "Number of links deleted at once.", &c.Deleter.BatchSize},
+		{"delete-flush-interval", "DELETE_FLUSH_INTERVAL", "How often pending deletions are flushed.", &c.Deleter.FlushInterval},
+		{"delete-flush-timeout", "DELETE_FLUSH_TIMEOUT", "Maximum duration of a single delete batch.", &c.Deleter.FlushTimeout},
+
+		{"reaper-interval", "REAPER_INTERVAL", "How often expired links are purged.", &c.Reaper.Interval},
+		{"reaper-retention", "REAPER_RETENTION", "How long expired links answer 410 before they are purged.", &c.Reaper.Retention},
+
+		{"clicks-queue-size", "CLICKS_QUEUE_SIZE", "Number of queued clicks, further clicks are dropped.", &c.Analytics.QueueSize},
+		{"clicks-batch-size", "CLICKS_BATCH_SIZE", "Number of click counters written at once.", &c.Analytics.BatchSize},
+		{"clicks-flush-interval", "CLICKS_FLUSH_INTERVAL", "How often click counters are written.", &c.Analytics.FlushInterval},
+		{"clicks-ip-salt", "CLICKS_IP_SALT", "Salt of the client address hashes counting unique visitors, random per run when empty.", &c.Analytics.IPSalt},
+
+		{"rate-create-requests", "RATE_CREATE_REQUESTS", "Links one user or IP may create per period, 0 is unlimited.", &c.RateLimit.Create.Requests},
+		{"rate-create-period", "RATE_CREATE_PERIOD", "Period of the link creation limit.", &c.RateLimit.Create.Period},
+		{"rate-create-burst", "RATE_CREATE_BURST", "Link creations allowed at once.", &c.RateLimit.Create.Burst},
+		{"rate-batch-requests", "RATE_BATCH_REQUESTS", "Batch requests one user or IP may send per period, 0 is unlimited.", &c.RateLimit.Batch.Requests},
+		{"rate-batch-period", "RATE_BATCH_PERIOD", "Period of the batch limit.", &c.RateLimit.Batch.Period},
+		{"rate-batch-burst", "RATE_BATCH_BURST", "Batch requests allowed at once.", &c.RateLimit.Batch.Burst},
+		{"rate-redirect-requests", "RATE_REDIRECT_REQUESTS", "Redirects one user or IP may follow per period, 0 is unlimited.", &c.RateLimit.Redirect.Requests},
+		{"rate-redirect-period", "RATE_REDIRECT_PERIOD", "Period of the redirect limit.", &c.RateLimit.Redirect.Period},
+		{"rate-redirect-burst", "RATE_REDIRECT_BURST", "Redirects allowed at once.", &c.RateLimit.Redirect.Burst},
+		{"rate-signup-requests", "RATE_SIGNUP_REQUESTS", "Accounts one IP may create per period, 0 is unlimited.", &c.RateLimit.Signup.Requests},
+		{"rate-signup-period", "RATE_SIGNUP_PERIOD", "Period of the sign-up limit.", &c.RateLimit.Signup.Period},
+		{"rate-signup-burst", "RATE_SIGNUP_BURST", "Sign-ups allowed at once.", &c.RateLimit.Signup.Burst},
+		{"trust-forwarded-for", "TRUST_FORWARDED_FOR", "Take the client IP of rate limits from X-Forwarded-For.", &c.RateLimit.TrustForwardedFor},
+
+		{"quota-max-links", "QUOTA_MAX_LINKS", "Active links one owner may keep, 0 is unlimited.", &c.Quota.Default.MaxLinks},
+		{"quota-max-batch", "QUOTA_MAX_BATCH", "Links one batch request may create, 0 is unlimited.", &c.Quota.Default.MaxBatch},
+
+		{"metrics-address", "METRICS_ADDRESS", "Address of the admin listener serving Prometheus metrics on /metrics, none when empty.", &c.Metrics.Address},
+
+		{"tracing", "TRACING_ENABLED", "Trace requests and storage calls.", &c.Tracing.Enabled},
+		{"tracing-exporter", "TRACING_EXPORTER", "Where finished spans go: stdout or file.", &c.Tracing.Exporter},
+		{"tracing-file", "TRACING_FILE", "File the spans are appended to by the file exporter.", &c.Tracing.File},
+		{"tracing-sample-ratio", "TRACING_SAMPLE_RATIO", "Share of the traces started here that are kept, from 0 to 1.", &c.Tracing.SampleRatio},
+
+		{"log-level", "LOG_LEVEL", "Lowest level logged: debug, info, warn or error.", &c.Log.Level},
+		{"log-redact-urls", "LOG_REDACT_URLS", "Log only the scheme and host of URLs.", &c.Log.RedactURLs},
+		{"log-redact-user-ids", "LOG_REDACT_USER_IDS", "Log hashes instead of user IDs.", &c.Log.RedactUserIDs},
+	}
+}
+
+func (c *Config) flagSet(configPath *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("link-shortener", flag.ContinueOnError)
+	fs.StringVar(configPath, "c", *configPath, "Path to a YAML or JSON config file.")
+	for _, o := range c.options() {
+		switch v := o.value.(type) {
+		case *string:
+			fs.StringVar(v, o.flag, *v, o.usage)
+		case *[]string:
+			fs.Func(o.flag, fmt.Sprintf("%s (default %q)", o.usage, strings.Join(*v, ",")), func(raw string) error {
+				*v = splitList(raw)
+				return nil
+			})
+		case *int:
+			fs.IntVar(v, o.flag, *v, o.usage)
+		case *bool:
+			fs.BoolVar(v, o.flag, *v, o.usage)
+		case *float64:
+			fs.Float64Var(v, o.flag, *v, o.usage)
+		case *time.Duration:
+			fs.DurationVar(v, o.flag, *v, o.usage)
+		}
+	}
+	return fs
+}
+
+// Load builds the config from args and the environment. The returned args
+// are the positional arguments left after the flags. Defaults are overridden
+// by the file given with -c or CONFIG, then by the flags, then by the
+// environment variables.
+func Load(args []string) (Config, []string, error) {
+	configPath := os.Getenv("CONFIG")
+	scratch := Default()
+	probe := scratch.flagSet(&configPath)
+	probe.SetOutput(io.Discard)
+	probe.Parse(args)
+	if env := os.Getenv("CONFIG"); env != "" {
+		configPath = env
+	}
+
+	cfg := Default()
+	if configPath != "" {
+		if err := cfg.loadFile(configPath); err != nil {
+			return Config{}, nil, err
+		}
+	}
+
+	fs := cfg.flagSet(&configPath)
+	if err := fs.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return Config{}, nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// loadFile reads a YAML file, JSON is accepted as well since it is valid
+// YAML. Unknown keys are rejected to catch typos.
+func (c *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer file.Close()
+
+	dec := yaml.NewDecoder(file)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
 }
 
-var Conf ConfigFlags
+func (c *Config) loadEnv() error {
+	var errs []error
+	for _, o := range c.options() {
+		raw, ok := os.LookupEnv(o.env)
+		if !ok || raw == "" {
+			continue
+		}
+		switch v := o.value.(type) {
+		case *string:
+			*v = raw
+		case *[]string:
+			*v = splitList(raw)
+		case *int:
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: некорректное число: %s", o.env, raw))
+				continue
+			}
+			*v = n
+		case *float64:
+			f, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: некорректное число: %s", o.env, raw))
+				continue
+			}
+			*v = f
+		case *bool:
+			b, err := strconv.ParseBool(raw)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: некорректное логическое значение: %s", o.env, raw))
+				continue
+			}
+			*v = b
+		case *time.Duration:
+			d, err := time.ParseDuration(raw)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: некорректная длительность: %s", o.env, raw))
+				continue
+			}
+			*v = d
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func splitList(raw string) []string {
+	var list []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// LegacyCookiesCutoff returns the time from which legacy cookies are
+// rejected, the zero time when they are not accepted at all.
+func (a AuthConfig) LegacyCookiesCutoff() (time.Time, error) {
+	if a.LegacyCookiesUntil == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.DateOnly, a.LegacyCookiesUntil)
+}
+
+// SecureCookies reports whether the session cookie gets the Secure
+// attribute.
+func (c Config) SecureCookies() bool {
+	return c.Auth.CookieSecure || strings.HasPrefix(c.Server.BaseURL, "https://")
+}
+
+// ResolveBackend returns the configured backend, or the one implied by the
+// database DSN and file path when none is set.
+func (c Config) ResolveBackend() string {
+	switch {
+	case c.Storage.Backend != "":
+		return c.Storage.Backend
+	case c.Storage.DatabaseDSN != "":
+		return BackendPostgres
+	case c.Storage.FilePath != "":
+		return BackendFile
+	default:
+		return BackendMemory
+	}
+}
+
+// Validate returns every problem of the config at once.
+func (c Config) Validate() error {
+	var errs []error
+	if err := validateAddress(c.Server.Address); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateBaseURL(c.Server.BaseURL); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Metrics.Address != "" {
+		if err := validateAddress(c.Metrics.Address); err != nil {
+			errs = append(errs, fmt.Errorf("METRICS_ADDRESS: %w", err))
+		} else if c.Metrics.Address == c.Server.Address {
+			errs = append(errs, errors.New("METRICS_ADDRESS совпадает с адресом сервера"))
+		}
+	}
+	for _, host := range c.Server.AliasHosts {
+		if !validHost(host) {
+			errs = append(errs, fmt.Errorf("некорректный хост: %s, ожидается формат host[:port]", host))
+		}
+	}
+	for _, domain := range c.Server.Domains {
+		if !validHost(domain) {
+			errs = append(errs, fmt.Errorf("некорректный домен: %s, ожидается формат host[:port]", domain))
+			continue
+		}
+		if c.isPrimaryHost(domain) {
+			errs = append(errs, fmt.Errorf("домен %s уже обслуживает BASE_URL", domain))
+		}
+	}
+	if c.Auth.Key == "" {
+		errs = append(errs, errors.New("KEY не задан: без ключа подпись cookie можно подделать"))
+	}
+	for _, key := range c.Auth.PreviousKeys {
+		if key == c.Auth.Key {
+			errs = append(errs, errors.New("PREVIOUS_KEYS не должен содержать текущий KEY"))
+		}
+	}
+	if c.Analytics.IPSalt != "" && c.Analytics.IPSalt == c.Auth.Key {
+		errs = append(errs, errors.New("CLICKS_IP_SALT не должен совпадать с KEY"))
+	}
+	if _, err := c.Auth.LegacyCookiesCutoff(); err != nil {
+		errs = append(errs, fmt.Errorf("некорректная дата LEGACY_COOKIES_UNTIL: %s, ожидается формат YYYY-MM-DD", c.Auth.LegacyCookiesUntil))
+	}
+	switch c.Auth.Mode {
+	case AuthSession:
+	case AuthJWT:
+		if c.Auth.JWT.Secret == "" && c.Auth.JWT.JWKSFile == "" {
+			errs = append(errs, errors.New("для режима jwt нужен JWT_SECRET или JWT_JWKS_FILE"))
+		}
+		if c.Auth.JWT.Header == "" && c.Auth.JWT.Cookie == "" {
+			errs = append(errs, errors.New("для режима jwt нужен JWT_HEADER или JWT_COOKIE"))
+		}
+		if c.Auth.JWT.Leeway < 0 {
+			errs = append(errs, errors.New("jwt leeway не может быть отрицательным"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("неизвестный режим аутентификации: %s", c.Auth.Mode))
+	}
+	switch c.Auth.CookieSameSite {
+	case SameSiteLax, SameSiteStrict:
+	case SameSiteNone:
+		if !c.SecureCookies() {
+			errs = append(errs, errors.New("SameSite=none требует COOKIE_SECURE или https в BASE_URL"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("неизвестное значение SameSite: %s", c.Auth.CookieSameSite))
+	}
+
+	switch c.ResolveBackend() {
+	case BackendMemory:
+	case BackendFile:
+		if c.Storage.FilePath == "" {
+			errs = append(errs, errors.New("для хранилища file нужен путь к файлу"))
+		}
+	case BackendPostgres:
+		if c.Storage.DatabaseDSN == "" {
+			errs = append(errs, errors.New("для хранилища postgres нужен DATABASE_DSN"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("неизвестное хранилище: %s", c.Storage.Backend))
+	}
+
+	switch c.Generator.Strategy {
+	case generator.StrategyHash, generator.StrategyRandom, generator.StrategySnowflake, generator.StrategySequence:
+	default:
+		errs = append(errs, fmt.Errorf("неизвестная стратегия генерации: %s", c.Generator.Strategy))
+	}
+	if c.Generator.NodeID < 0 || c.Generator.NodeID > generator.SnowflakeMaxNode {
+		errs = append(errs, fmt.Errorf("GENERATOR_NODE_ID должен быть от 0 до %d", generator.SnowflakeMaxNode))
+	}
+
+	positive := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"read timeout", c.Server.ReadTimeout},
+		{"write timeout", c.Server.WriteTimeout},
+		{"idle timeout", c.Server.IdleTimeout},
+		{"shutdown timeout", c.Server.ShutdownTimeout},
+		{"request timeout", c.Server.RequestTimeout},
+		{"cookie ttl", c.Auth.CookieTTL},
+		{"delete flush interval", c.Deleter.FlushInterval},
+		{"delete flush timeout", c.Deleter.FlushTimeout},
+		{"reaper interval", c.Reaper.Interval},
+		{"clicks flush interval", c.Analytics.FlushInterval},
+	}
+	for _, p := range positive {
+		if p.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s должен быть больше нуля", p.name))
+		}
+	}
+	counts := []struct {
+		name  string
+		value int
+	}{
+		{"delete workers", c.Deleter.Workers},
+		{"delete queue size", c.Deleter.QueueSize},
+		{"delete batch size", c.Deleter.BatchSize},
+		{"clicks queue size", c.Analytics.QueueSize},
+		{"clicks batch size", c.Analytics.BatchSize},
+	}
+	for _, n := range counts {
+		if n.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s должен быть больше нуля", n.name))
+		}
+	}
+	switch c.Log.Level {
+	case LogDebug, LogInfo, LogWarn, LogError:
+	default:
+		errs = append(errs, fmt.Errorf("неизвестный уровень логирования: %s", c.Log.Level))
+	}
+	if c.Tracing.Enabled {
+		switch c.Tracing.Exporter {
+		case ExporterStdout:
+		case ExporterFile:
+			if c.Tracing.File == "" {
+				errs = append(errs, errors.New("для экспорта спанов в файл нужен TRACING_FILE"))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("неизвестный экспортер спанов: %s", c.Tracing.Exporter))
+		}
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, errors.New("TRACING_SAMPLE_RATIO должен быть от 0 до 1"))
+	}
+	if c.Cache.Enabled && (c.Cache.Size <= 0 || c.Cache.TTL <= 0) {
+		errs = append(errs, errors.New("для кэша нужны положительные size и ttl"))
+	}
+	if c.Cache.NegativeTTL < 0 {
+		errs = append(errs, errors.New("cache negative ttl не может быть отрицательным"))
+	}
+	limits := []struct {
+		name  string
+		limit RateLimit
+	}{
+		{"create", c.RateLimit.Create},
+		{"batch", c.RateLimit.Batch},
+		{"redirect", c.RateLimit.Redirect},
+		{"signup", c.RateLimit.Signup},
+	}
+	for _, l := range limits {
+		switch {
+		case l.limit.Requests < 0:
+			errs = append(errs, fmt.Errorf("rate limit %s не может быть отрицательным", l.name))
+		case l.limit.Requests > 0 && (l.limit.Period <= 0 || l.limit.Burst <= 0):
+			errs = append(errs, fmt.Errorf("для rate limit %s нужны положительные period и burst", l.name))
+		}
+	}
+	quotas := map[string]Quota{"default": c.Quota.Default}
+	for tier, quota := range c.Quota.Tiers {
+		quotas["tier "+tier] = quota
+	}
+	for name, quota := range quotas {
+		if quota.MaxLinks < 0 || quota.MaxBatch < 0 {
+			errs = append(errs, fmt.Errorf("квота %s не может быть отрицательной", name))
+		}
+	}
+	for user, tier := range c.Quota.Users {
+		if _, ok := c.Quota.Tiers[tier]; !ok {
+			errs = append(errs, fmt.Errorf("у пользователя %s неизвестный тариф квот: %s", user, tier))
+		}
+	}
+	if c.Storage.MaxOpenConns < 0 || c.Storage.MaxIdleConns < 0 || c.Storage.ConnMaxLifetime < 0 {
+		errs = append(errs, errors.New("параметры пула соединений не могут быть отрицательными"))
+	}
+	if c.Reaper.Retention < 0 {
+		errs = append(errs, errors.New("reaper retention не может быть отрицательным"))
+	}
+	return errors.Join(errs...)
+}
 
 func validateAddress(address string) error {
 	_, port, err := net.SplitHostPort(address)
@@ -48,36 +787,20 @@ func validateBaseURL(rawURL string) error {
 
 }
 
-func ParseFlags() {
-	flag.StringVar(&Conf.Start, "a", ":8080", "Address and port to run server.")
-	flag.StringVar(&Conf.Result, "b", "http://localhost:8080", "The server address before the short url.")
-	flag.StringVar(&Conf.File, "f", "./tmp/short-url-db.json", "The path to the file to save.")
-	flag.StringVar(&Conf.DB, "d", "", "The path to the postgresql.")
-	flag.Parse()
-
-	if RunAddr := os.Getenv("SERVER_ADDRESS"); RunAddr != "" {
-		Conf.Start = RunAddr
-	}
-	if ResAddr := os.Getenv("BASE_URL"); ResAddr != "" {
-		Conf.Result = ResAddr
-	}
-	if FilePath := os.Getenv("FILE_STORAGE_PATH"); FilePath != "" {
-		Conf.File = FilePath
-	}
-	if PathDB := os.Getenv("DATABASE_DSN"); PathDB != "" {
-		Conf.DB = PathDB
-	}
-	Conf.Key = os.Getenv("KEY")
+func validHost(host string) bool {
+	u, err := url.Parse("//" + host)
+	return err == nil && host != "" && u.Host == host && u.Path == ""
+}
 
-	if err := validateAddress(Conf.Start); err != nil {
-		fmt.Println(err)
-		flag.Usage()
-		return
+// isPrimaryHost reports whether links on the host belong to the base URL.
+func (c Config) isPrimaryHost(host string) bool {
+	if u, err := url.Parse(c.Server.BaseURL); err == nil && strings.EqualFold(u.Host, host) {
+		return true
 	}
-
-	if err := validateBaseURL(Conf.Result); err != nil {
-		fmt.Println(err)
-		flag.Usage()
-		return
+	for _, alias := range c.Server.AliasHosts {
+		if strings.EqualFold(alias, host) {
+			return true
+		}
 	}
+	return false
 }
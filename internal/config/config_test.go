@@ -0,0 +1,250 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+server:
+  address: ":9000"
+  base_url: "http://file.example.com"
+  read_timeout: 3s
+storage:
+  backend: memory
+auth:
+  key: file-key
+deleter:
+  workers: 2
+`)
+	t.Setenv("KEY", "")
+	t.Setenv("BASE_URL", "http://env.example.com")
+	t.Setenv("ALIAS_HOSTS", "sho.rt, go.sho.rt")
+	t.Setenv("DOMAINS", "")
+
+	cfg, args, err := Load([]string{"-c", path, "-a", ":9100", "-b", "http://flag.example.com", "-delete-workers", "7", "-domains", "brand.example,go.brand.example:8443", "migrate", "up"})
+	require.NoError(t, err)
+
+	assert.Equal(t, ":9100", cfg.Server.Address, "flags override the file")
+	assert.Equal(t, "http://env.example.com", cfg.Server.BaseURL, "env overrides flags")
+	assert.Equal(t, 3*time.Second, cfg.Server.ReadTimeout, "file overrides defaults")
+	assert.Equal(t, Default().Server.WriteTimeout, cfg.Server.WriteTimeout)
+	assert.Equal(t, []string{"sho.rt", "go.sho.rt"}, cfg.Server.AliasHosts)
+	assert.Equal(t, []string{"brand.example", "go.brand.example:8443"}, cfg.Server.Domains)
+	assert.Equal(t, "file-key", cfg.Auth.Key)
+	assert.Equal(t, 7, cfg.Deleter.Workers)
+	assert.False(t, cfg.Cache.Enabled)
+	assert.Empty(t, cfg.Metrics.Address, "metrics are off by default")
+	assert.Equal(t, BackendMemory, cfg.ResolveBackend())
+	assert.Equal(t, []string{"migrate", "up"}, args)
+}
+
+func TestLoadJSONFile(t *testing.T) {
+	path := writeFile(t, "config.json", `{"auth": {"key": "json-key", "cookie_ttl": "2h"}, "generator": {"strategy": "random", "node_id": 3}}`)
+	t.Setenv("CONFIG", path)
+	t.Setenv("KEY", "")
+
+	cfg, _, err := Load(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "json-key", cfg.Auth.Key)
+	assert.Equal(t, 2*time.Hour, cfg.Auth.CookieTTL)
+	assert.Equal(t, GeneratorConfig{Strategy: "random", NodeID: 3}, cfg.Generator)
+}
+
+func TestLoadRejectsUnknownFileKeys(t *testing.T) {
+	path := writeFile(t, "config.yaml", "auth:\n  kee: typo\n")
+	_, _, err := Load([]string{"-c", path})
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	valid := Default()
+	valid.Auth.Key = "secret"
+	require.NoError(t, valid.Validate())
+
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+	}{
+		{"empty key", func(c *Config) { c.Auth.Key = "" }},
+		{"current key among previous keys", func(c *Config) { c.Auth.PreviousKeys = []string{"old", "secret"} }},
+		{"ip salt equal to the key", func(c *Config) { c.Analytics.IPSalt = "secret" }},
+		{"bad legacy cookie date", func(c *Config) { c.Auth.LegacyCookiesUntil = "31.12.2026" }},
+		{"unknown same site", func(c *Config) { c.Auth.CookieSameSite = "loose" }},
+		{"same site none over http", func(c *Config) { c.Auth.CookieSameSite = SameSiteNone }},
+		{"unknown auth mode", func(c *Config) { c.Auth.Mode = "oauth" }},
+		{"jwt without keys", func(c *Config) { c.Auth.Mode = AuthJWT }},
+		{"jwt without header and cookie", func(c *Config) {
+			c.Auth.Mode = AuthJWT
+			c.Auth.JWT.Secret = "jwt-secret"
+			c.Auth.JWT.Header = ""
+		}},
+		{"unknown log level", func(c *Config) { c.Log.Level = "verbose" }},
+		{"file exporter without a file", func(c *Config) { c.Tracing.Enabled, c.Tracing.Exporter = true, ExporterFile }},
+		{"unknown exporter", func(c *Config) { c.Tracing.Enabled, c.Tracing.Exporter = true, "jaeger" }},
+		{"sample ratio above one", func(c *Config) { c.Tracing.SampleRatio = 1.5 }},
+		{"bad metrics address", func(c *Config) { c.Metrics.Address = "9090" }},
+		{"metrics on the server address", func(c *Config) { c.Metrics.Address = ":8080" }},
+		{"bad address", func(c *Config) { c.Server.Address = "localhost" }},
+		{"alias host with scheme", func(c *Config) { c.Server.AliasHosts = []string{"https://sho.rt"} }},
+		{"domain with path", func(c *Config) { c.Server.Domains = []string{"brand.example/s"} }},
+		{"domain of the base url", func(c *Config) { c.Server.Domains = []string{"LOCALHOST:8080"} }},
+		{"domain of an alias host", func(c *Config) { c.Server.Domains = []string{"127.0.0.1:8080"} }},
+		{"base url with trailing slash", func(c *Config) { c.Server.BaseURL = "http://localhost:8080/" }},
+		{"unknown backend", func(c *Config) { c.Storage.Backend = "redis" }},
+		{"postgres without dsn", func(c *Config) { c.Storage.Backend = BackendPostgres }},
+		{"unknown generator", func(c *Config) { c.Generator.Strategy = "uuid" }},
+		{"negative node ID", func(c *Config) { c.Generator.NodeID = -1 }},
+		{"node ID over the node bits", func(c *Config) { c.Generator.NodeID = 1024 }},
+		{"zero timeout", func(c *Config) { c.Server.RequestTimeout = 0 }},
+		{"no delete workers", func(c *Config) { c.Deleter.Workers = 0 }},
+		{"negative rate limit", func(c *Config) { c.RateLimit.Create.Requests = -1 }},
+		{"rate limit without burst", func(c *Config) { c.RateLimit.Batch.Burst = 0 }},
+		{"sign-up limit without period", func(c *Config) { c.RateLimit.Signup.Period = 0 }},
+		{"cache without size", func(c *Config) {
+			c.Cache.Enabled = true
+			c.Cache.Size = 0
+		}},
+		{"negative cache ttl", func(c *Config) { c.Cache.NegativeTTL = -time.Second }},
+		{"negative quota", func(c *Config) { c.Quota.Default.MaxBatch = -1 }},
+		{"negative tier quota", func(c *Config) { c.Quota.Tiers = map[string]Quota{"pro": {MaxLinks: -1}} }},
+		{"user of an unknown tier", func(c *Config) { c.Quota.Users = map[string]string{"user1": "gold"} }},
+		{"negative pool size", func(c *Config) { c.Storage.MaxOpenConns = -1 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+			assert.Error(t, cfg.Validate())
+		})
+	}
+}
+
+func TestLoadCookieSettings(t *testing.T) {
+	t.Setenv("KEY", "new-key")
+	t.Setenv("PREVIOUS_KEYS", "old-key, older-key")
+	t.Setenv("COOKIE_SAME_SITE", "none")
+	t.Setenv("LEGACY_COOKIES_UNTIL", "2026-12-31")
+	t.Setenv("BASE_URL", "")
+
+	cfg, _, err := Load([]string{"-cookie-secure"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"old-key", "older-key"}, cfg.Auth.PreviousKeys)
+	assert.True(t, cfg.Auth.CookieSecure)
+	assert.True(t, cfg.SecureCookies())
+	assert.Equal(t, SameSiteNone, cfg.Auth.CookieSameSite)
+	cutoff, err := cfg.Auth.LegacyCookiesCutoff()
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC), cutoff)
+
+	t.Setenv("COOKIE_SECURE", "sometimes")
+	_, _, err = Load(nil)
+	assert.ErrorContains(t, err, "COOKIE_SECURE")
+}
+
+func TestLoadJWTSettings(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+auth:
+  key: file-key
+  mode: jwt
+  session_fallback: true
+  jwt:
+    jwks_file: /etc/shortener/jwks.json
+    cookie: access_token
+    issuer: https://auth.example.com
+`)
+	t.Setenv("KEY", "")
+	t.Setenv("JWT_AUDIENCE", "shortener")
+
+	cfg, _, err := Load([]string{"-c", path, "-jwt-leeway", "5s"})
+	require.NoError(t, err)
+	assert.Equal(t, AuthJWT, cfg.Auth.Mode)
+	assert.True(t, cfg.Auth.SessionFallback)
+	assert.Equal(t, JWTConfig{
+		JWKSFile: "/etc/shortener/jwks.json",
+		Header:   "Authorization",
+		Cookie:   "access_token",
+		Issuer:   "https://auth.example.com",
+		Audience: "shortener",
+		Leeway:   5 * time.Second,
+	}, cfg.Auth.JWT)
+}
+
+func TestLoadRateLimits(t *testing.T) {
+	t.Setenv("KEY", "secret")
+	t.Setenv("RATE_REDIRECT_REQUESTS", "0")
+	t.Setenv("RATE_REDIRECT_BURST", "0")
+
+	cfg, _, err := Load([]string{"-rate-create-requests", "5", "-rate-create-period", "1s", "-trust-forwarded-for"})
+	require.NoError(t, err)
+	assert.Equal(t, RateLimit{Requests: 5, Period: time.Second, Burst: 20}, cfg.RateLimit.Create)
+	assert.Equal(t, Default().RateLimit.Batch, cfg.RateLimit.Batch)
+	assert.Equal(t, 0, cfg.RateLimit.Redirect.Requests, "zero disables a limit without further settings")
+	assert.True(t, cfg.RateLimit.TrustForwardedFor)
+}
+
+func TestLoadQuotas(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+auth:
+  key: file-key
+quota:
+  default:
+    max_links: 100
+    max_batch: 10
+  tiers:
+    pro:
+      max_links: 0
+      max_batch: 500
+  users:
+    user1: pro
+`)
+	t.Setenv("KEY", "")
+	t.Setenv("QUOTA_MAX_BATCH", "20")
+
+	cfg, _, err := Load([]string{"-c", path})
+	require.NoError(t, err)
+	assert.Equal(t, QuotaConfig{
+		Default: Quota{MaxLinks: 100, MaxBatch: 20},
+		Tiers:   map[string]Quota{"pro": {MaxBatch: 500}},
+		Users:   map[string]string{"user1": "pro"},
+	}, cfg.Quota)
+}
+
+func TestLoadTracing(t *testing.T) {
+	t.Setenv("KEY", "secret")
+	t.Setenv("TRACING_SAMPLE_RATIO", "0.25")
+	cfg, _, err := Load([]string{"-tracing", "-tracing-exporter", "file", "-tracing-file", "spans.jsonl"})
+	require.NoError(t, err)
+	assert.Equal(t, TracingConfig{Enabled: true, Exporter: ExporterFile, File: "spans.jsonl", SampleRatio: 0.25}, cfg.Tracing)
+
+	t.Setenv("TRACING_SAMPLE_RATIO", "most")
+	_, _, err = Load(nil)
+	assert.ErrorContains(t, err, "TRACING_SAMPLE_RATIO")
+}
+
+func TestLoadLogSettings(t *testing.T) {
+	t.Setenv("KEY", "secret")
+	t.Setenv("LOG_REDACT_URLS", "false")
+	cfg, _, err := Load([]string{"-log-level", "debug"})
+	require.NoError(t, err)
+	assert.Equal(t, LogConfig{Level: LogDebug, RedactURLs: false, RedactUserIDs: true}, cfg.Log)
+}
+
+func TestLoadInvalidEnv(t *testing.T) {
+	t.Setenv("KEY", "secret")
+	t.Setenv("READ_TIMEOUT", "soon")
+	_, _, err := Load(nil)
+	assert.ErrorContains(t, err, "READ_TIMEOUT")
+}
@@ -11,23 +11,36 @@ import (
 )
 
 type BaseController struct {
-	logger  logger.Logger
-	storage handlers.Handler
-	ctx     context.Context
+	storage       handlers.Handler
+	ctx           context.Context
+	createLimit   func(http.Handler) http.Handler
+	redirectLimit func(http.Handler) http.Handler
 }
 
-func NewBaseController(ctx context.Context, logger logger.Logger, handler handlers.Handler) *BaseController {
+func NewBaseController(ctx context.Context, handler handlers.Handler) *BaseController {
 	return &BaseController{
-		logger:  logger,
-		storage: handler,
-		ctx:     ctx,
+		storage:       handler,
+		ctx:           ctx,
+		createLimit:   noLimit,
+		redirectLimit: noLimit,
 	}
 }
 
+// WithRateLimits limits link creation and redirects.
+func (c *BaseController) WithRateLimits(create, redirect func(http.Handler) http.Handler) *BaseController {
+	c.createLimit = create
+	c.redirectLimit = redirect
+	return c
+}
+
+func noLimit(next http.Handler) http.Handler {
+	return next
+}
+
 func (c *BaseController) Route() *chi.Mux {
 	r := chi.NewRouter()
-	r.Post("/", c.WithLogging(c.storage.Fpost))
-	r.Get("/{shortURL}", c.WithLogging(c.storage.Fget))
+	r.With(c.createLimit).Post("/", c.WithLogging(c.storage.Fpost))
+	r.With(c.redirectLimit).Get("/{shortURL}", c.WithLogging(c.storage.Fget))
 	return r
 }
 
@@ -47,7 +60,7 @@ func (c *BaseController) WithLogging(h func(ctx context.Context, w http.Response
 
 		duration := time.Since(start)
 
-		c.logger.WithFields(map[string]interface{}{
+		logger.FromContext(r.Context()).WithFields(map[string]interface{}{
 			"uri":      r.RequestURI,
 			"method":   r.Method,
 			"status":   responseData.Status,
@@ -2,7 +2,6 @@ package controllermod
 
 import (
 	"context"
-	"fmt"
 	"net/http"
 	"time"
 
@@ -12,24 +11,41 @@ import (
 )
 
 type ModController struct {
-	logger  logger.Logger
-	storage handlers.Handler
-	ctx     context.Context
+	storage     handlers.Handler
+	ctx         context.Context
+	createLimit func(http.Handler) http.Handler
+	batchLimit  func(http.Handler) http.Handler
+	lookupLimit func(http.Handler) http.Handler
 }
 
-func NewModController(ctx context.Context, logger logger.Logger, handler handlers.Handler) *ModController {
+func NewModController(ctx context.Context, handler handlers.Handler) *ModController {
 	return &ModController{
-		logger:  logger,
-		storage: handler,
-		ctx:     ctx,
+		storage:     handler,
+		ctx:         ctx,
+		createLimit: noLimit,
+		batchLimit:  noLimit,
+		lookupLimit: noLimit,
 	}
 }
 
+// WithRateLimits limits link creation, batches and the lookups of original
+// URLs, which count as redirects.
+func (c *ModController) WithRateLimits(create, batch, lookup func(http.Handler) http.Handler) *ModController {
+	c.createLimit = create
+	c.batchLimit = batch
+	c.lookupLimit = lookup
+	return c
+}
+
+func noLimit(next http.Handler) http.Handler {
+	return next
+}
+
 func (c *ModController) Route() *chi.Mux {
 	r := chi.NewRouter()
-	r.Post("/shorten", c.WithLogging(c.storage.ModifPost))
-	r.Get("/shortenGet", c.WithLogging(c.storage.ModifFget))
-	r.Post("/shorten/batch", c.WithLogging(c.storage.Batch))
+	r.With(c.createLimit).Post("/shorten", c.WithLogging(c.storage.ModifPost))
+	r.With(c.lookupLimit).Get("/shortenGet", c.WithLogging(c.storage.ModifFget))
+	r.With(c.batchLimit).Post("/shorten/batch", c.WithLogging(c.storage.Batch))
 
 	return r
 }
@@ -46,10 +62,9 @@ func (c *ModController) WithLogging(h func(ctx context.Context, w http.ResponseW
 
 		h(r.Context(), &lw, r)
 
-		fmt.Println("Отправленный JSON:", lw.ResponseData)
 		duration := time.Since(start)
 
-		c.logger.WithFields(map[string]interface{}{
+		logger.FromContext(r.Context()).WithFields(map[string]interface{}{
 			"uri":      r.RequestURI,
 			"method":   r.Method,
 			"status":   responseData.Status,
@@ -0,0 +1,181 @@
+package deleter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Dnlbb/link-shortener/internal/logger"
+	"github.com/Dnlbb/link-shortener/internal/models"
+)
+
+var ErrClosed = errors.New("deleter: service closed")
+
+// Repository is the part of storage.Repository the service needs.
+type Repository interface {
+	DeleteBatch(ctx context.Context, owner string, keys []models.LinkKey) error
+}
+
+type Config struct {
+	Workers       int
+	QueueSize     int
+	BatchSize     int
+	FlushInterval time.Duration
+	// FlushTimeout bounds a single DeleteBatch call.
+	FlushTimeout time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Workers:       4,
+		QueueSize:     1024,
+		BatchSize:     100,
+		FlushInterval: time.Second,
+		FlushTimeout:  10 * time.Second,
+	}
+}
+
+type task struct {
+	owner string
+	key   models.LinkKey
+}
+
+// Service deletes links asynchronously. Enqueued (owner, link) pairs are
+// spread over a fixed pool of workers, each of them collects pairs and
+// deletes them with one DeleteBatch call per owner once BatchSize pairs are
+// pending or FlushInterval has passed.
+type Service struct {
+	repo  Repository
+	cfg   Config
+	tasks chan task
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func NewService(repo Repository, cfg Config) *Service {
+	def := DefaultConfig()
+	if cfg.Workers <= 0 {
+		cfg.Workers = def.Workers
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = def.QueueSize
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = def.BatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = def.FlushInterval
+	}
+	if cfg.FlushTimeout <= 0 {
+		cfg.FlushTimeout = def.FlushTimeout
+	}
+
+	s := &Service{
+		repo:  repo,
+		cfg:   cfg,
+		tasks: make(chan task, cfg.QueueSize),
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		s.wg.Add(1)
+		go s.worker()
+	}
+	return s
+}
+
+// Enqueue queues the owner's links for deletion. It blocks while the queue is
+// full until ctx is done.
+func (s *Service) Enqueue(ctx context.Context, owner string, keys []models.LinkKey) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return ErrClosed
+	}
+	for _, key := range keys {
+		select {
+		case s.tasks <- task{owner: owner, key: key}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Len returns the number of queued pairs not yet picked up by a worker.
+func (s *Service) Len() int {
+	return len(s.tasks)
+}
+
+// Close stops accepting new pairs and waits until the queued ones are
+// deleted or ctx is done.
+func (s *Service) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.tasks)
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Service) worker() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	pending := make(map[string][]models.LinkKey)
+	count := 0
+	flush := func() {
+		if count == 0 {
+			return
+		}
+		for owner, keys := range pending {
+			s.delete(owner, keys)
+		}
+		pending = make(map[string][]models.LinkKey)
+		count = 0
+	}
+
+	for {
+		select {
+		case t, ok := <-s.tasks:
+			if !ok {
+				flush()
+				return
+			}
+			pending[t.owner] = append(pending[t.owner], t.key)
+			count++
+			if count >= s.cfg.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (s *Service) delete(owner string, keys []models.LinkKey) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.FlushTimeout)
+	defer cancel()
+	if err := s.repo.DeleteBatch(ctx, owner, keys); err != nil {
+		logger.FromContext(ctx).WithFields(map[string]interface{}{
+			logger.FieldUserID: owner,
+			"links":            len(keys),
+			logger.FieldError:  err,
+		}).Error("Error deleting urls")
+	}
+}
@@ -0,0 +1,62 @@
+package deleter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dnlbb/link-shortener/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingRepo struct {
+	mu      sync.Mutex
+	calls   int
+	deleted map[string][]models.LinkKey
+}
+
+func (r *recordingRepo) DeleteBatch(ctx context.Context, owner string, keys []models.LinkKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls++
+	r.deleted[owner] = append(r.deleted[owner], keys...)
+	return nil
+}
+
+func TestServiceDrainsOnClose(t *testing.T) {
+	repo := &recordingRepo{deleted: make(map[string][]models.LinkKey)}
+	s := NewService(repo, Config{Workers: 2, BatchSize: 10, FlushInterval: time.Hour})
+
+	var urls []models.LinkKey
+	for i := 0; i < 25; i++ {
+		urls = append(urls, models.LinkKey{ShortURL: fmt.Sprintf("code%04d", i)})
+	}
+	other := []models.LinkKey{{Domain: "sho.rt", ShortURL: "other000"}}
+	require.NoError(t, s.Enqueue(context.Background(), "user1", urls))
+	require.NoError(t, s.Enqueue(context.Background(), "user2", other))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, s.Close(ctx))
+
+	assert.ElementsMatch(t, urls, repo.deleted["user1"])
+	assert.Equal(t, other, repo.deleted["user2"])
+	assert.Less(t, repo.calls, 26)
+	assert.ErrorIs(t, s.Enqueue(context.Background(), "user1", urls), ErrClosed)
+}
+
+func TestServiceFlushesOnInterval(t *testing.T) {
+	repo := &recordingRepo{deleted: make(map[string][]models.LinkKey)}
+	s := NewService(repo, Config{Workers: 1, BatchSize: 100, FlushInterval: 10 * time.Millisecond})
+	defer s.Close(context.Background())
+
+	require.NoError(t, s.Enqueue(context.Background(), "user1", []models.LinkKey{{ShortURL: "code0001"}}))
+	assert.Eventually(t, func() bool {
+		repo.mu.Lock()
+		defer repo.mu.Unlock()
+		return len(repo.deleted["user1"]) == 1
+	}, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,195 @@
+package generator
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	StrategyHash      = "hash"
+	StrategyRandom    = "random"
+	StrategySnowflake = "snowflake"
+	StrategySequence  = "sequence"
+)
+
+const base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// ShortCodeGenerator produces short codes for original URLs. attempt starts
+// at 0 and is increased by the caller every time the previous code turned out
+// to be taken, so deterministic strategies can derive a different code.
+type ShortCodeGenerator interface {
+	Generate(ctx context.Context, originalURL string, attempt int) (string, error)
+}
+
+// Sequence hands out unique increasing IDs, usually backed by the database.
+type Sequence interface {
+	NextID(ctx context.Context) (int64, error)
+}
+
+// IDGenerator is implemented by the generators whose codes encode an ID
+// reserved in the storage. The link must be saved with that ID.
+type IDGenerator interface {
+	GenerateID(ctx context.Context) (int64, string, error)
+}
+
+// New returns the generator for the configured strategy. node is only used
+// by the snowflake strategy and seq by the sequence one.
+func New(strategy string, node int64, seq Sequence) (ShortCodeGenerator, error) {
+	switch strategy {
+	case "", StrategyHash:
+		return NewHash(), nil
+	case StrategyRandom:
+		return NewRandom(8), nil
+	case StrategySnowflake:
+		if node < 0 || node > SnowflakeMaxNode {
+			return nil, fmt.Errorf("snowflake node %d is out of range 0-%d", node, SnowflakeMaxNode)
+		}
+		return NewSnowflake(node), nil
+	case StrategySequence:
+		return NewSequence(seq), nil
+	default:
+		return nil, fmt.Errorf("unknown short code strategy: %s", strategy)
+	}
+}
+
+func EncodeBase62(n uint64) string {
+	if n == 0 {
+		return base62Alphabet[:1]
+	}
+	var buf [11]byte
+	i := len(buf)
+	for n > 0 {
+		i--
+		buf[i] = base62Alphabet[n%62]
+		n /= 62
+	}
+	return string(buf[i:])
+}
+
+// HashGenerator takes the first 8 hex characters of the SHA-1 of the URL,
+// retries hash the URL together with the attempt number.
+type HashGenerator struct{}
+
+func NewHash() *HashGenerator {
+	return &HashGenerator{}
+}
+
+func (g *HashGenerator) Generate(ctx context.Context, originalURL string, attempt int) (string, error) {
+	return Hash(originalURL, attempt), nil
+}
+
+func Hash(originalURL string, attempt int) string {
+	hash := sha1.New()
+	hash.Write([]byte(originalURL))
+	if attempt > 0 {
+		hash.Write([]byte("#" + strconv.Itoa(attempt)))
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:8]
+}
+
+// RandomGenerator returns random base62 codes of a fixed length.
+type RandomGenerator struct {
+	length int
+}
+
+func NewRandom(length int) *RandomGenerator {
+	return &RandomGenerator{length: length}
+}
+
+func (g *RandomGenerator) Generate(ctx context.Context, originalURL string, attempt int) (string, error) {
+	max := big.NewInt(int64(len(base62Alphabet)))
+	code := make([]byte, g.length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = base62Alphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+const (
+	snowflakeNodeBits = 10
+	snowflakeSeqBits  = 12
+	snowflakeMaxSeq   = 1<<snowflakeSeqBits - 1
+)
+
+// SnowflakeMaxNode is the largest node number of the snowflake strategy.
+// Instances generating codes at the same time need different nodes.
+const SnowflakeMaxNode = 1<<snowflakeNodeBits - 1
+
+// snowflakeEpoch is the start of the 41-bit millisecond timestamp.
+var snowflakeEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// SnowflakeGenerator builds IDs from a millisecond timestamp, a node number
+// and a per-millisecond sequence, and encodes them in base62.
+type SnowflakeGenerator struct {
+	mu       sync.Mutex
+	node     int64
+	lastTime int64
+	seq      int64
+	now      func() time.Time
+}
+
+func NewSnowflake(node int64) *SnowflakeGenerator {
+	return &SnowflakeGenerator{
+		node: node & SnowflakeMaxNode,
+		now:  time.Now,
+	}
+}
+
+func (g *SnowflakeGenerator) Generate(ctx context.Context, originalURL string, attempt int) (string, error) {
+	return EncodeBase62(uint64(g.next())), nil
+}
+
+func (g *SnowflakeGenerator) next() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := g.now().Sub(snowflakeEpoch).Milliseconds()
+	if ms < g.lastTime {
+		// The clock went backwards, keep counting from the last timestamp.
+		ms = g.lastTime
+	}
+	if ms == g.lastTime {
+		g.seq = (g.seq + 1) & snowflakeMaxSeq
+		if g.seq == 0 {
+			ms++
+		}
+	} else {
+		g.seq = 0
+	}
+	g.lastTime = ms
+	return ms<<(snowflakeNodeBits+snowflakeSeqBits) | g.node<<snowflakeSeqBits | g.seq
+}
+
+// SequenceGenerator encodes the next database ID in base62, the link is
+// saved with that ID so the code always decodes to its row.
+type SequenceGenerator struct {
+	seq Sequence
+}
+
+func NewSequence(seq Sequence) *SequenceGenerator {
+	return &SequenceGenerator{seq: seq}
+}
+
+func (g *SequenceGenerator) Generate(ctx context.Context, originalURL string, attempt int) (string, error) {
+	_, code, err := g.GenerateID(ctx)
+	return code, err
+}
+
+func (g *SequenceGenerator) GenerateID(ctx context.Context) (int64, string, error) {
+	id, err := g.seq.NextID(ctx)
+	if err != nil {
+		return 0, "", err
+	}
+	return id, EncodeBase62(uint64(id)), nil
+}
@@ -0,0 +1,84 @@
+package generator
+
+import (
+	"context"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var base62Code = regexp.MustCompile(`^[0-9A-Za-z]+$`)
+
+type counter struct {
+	id int64
+}
+
+func (c *counter) NextID(ctx context.Context) (int64, error) {
+	return atomic.AddInt64(&c.id, 1), nil
+}
+
+func TestEncodeBase62(t *testing.T) {
+	assert.Equal(t, "0", EncodeBase62(0))
+	assert.Equal(t, "z", EncodeBase62(61))
+	assert.Equal(t, "10", EncodeBase62(62))
+	assert.Equal(t, "AzL8n0Y58m7", EncodeBase62(1<<63-1))
+}
+
+func TestHashGenerator(t *testing.T) {
+	g := NewHash()
+	first, err := g.Generate(context.Background(), "https://practicum.yandex.ru/", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "a6499d23", first)
+
+	retry, err := g.Generate(context.Background(), "https://practicum.yandex.ru/", 1)
+	require.NoError(t, err)
+	assert.Len(t, retry, 8)
+	assert.NotEqual(t, first, retry)
+}
+
+func TestUniqueStrategies(t *testing.T) {
+	for _, strategy := range []string{StrategyRandom, StrategySnowflake, StrategySequence} {
+		t.Run(strategy, func(t *testing.T) {
+			g, err := New(strategy, 0, &counter{})
+			require.NoError(t, err)
+
+			seen := make(map[string]bool)
+			for i := 0; i < 10000; i++ {
+				code, err := g.Generate(context.Background(), "https://practicum.yandex.ru/", 0)
+				require.NoError(t, err)
+				require.Regexp(t, base62Code, code)
+				require.LessOrEqual(t, len(code), 11)
+				require.False(t, seen[code], code)
+				seen[code] = true
+			}
+		})
+	}
+}
+
+func TestUnknownStrategy(t *testing.T) {
+	_, err := New("md5", 0, nil)
+	assert.Error(t, err)
+}
+
+func TestSnowflakeNode(t *testing.T) {
+	at := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	ids := make(map[int64]int64)
+	for _, node := range []int64{0, 7, SnowflakeMaxNode} {
+		g, err := New(StrategySnowflake, node, nil)
+		require.NoError(t, err)
+		sf := g.(*SnowflakeGenerator)
+		sf.now = func() time.Time { return at }
+		ids[node] = sf.next()
+		assert.Equal(t, node, ids[node]>>snowflakeSeqBits&SnowflakeMaxNode)
+	}
+	assert.NotEqual(t, ids[0], ids[7], "nodes generating at the same time get different IDs")
+
+	for _, node := range []int64{-1, SnowflakeMaxNode + 1} {
+		_, err := New(StrategySnowflake, node, nil)
+		assert.Error(t, err, node)
+	}
+}
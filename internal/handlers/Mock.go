@@ -1,49 +1,206 @@
 package handlers
 
 import (
+	"context"
 	"sync"
-)
+	"time"
 
-type URLData struct {
-	OriginalURL string
-	OwnerID     string
-}
+	"github.com/Dnlbb/link-shortener/internal/models"
+	"github.com/Dnlbb/link-shortener/internal/storage"
+)
 
 type MockRepository struct {
-	data map[string]URLData
-	mu   sync.RWMutex
-	UUID int
+	data   map[models.LinkKey]models.Link
+	clicks map[models.LinkKey][]models.ClickCount
+	mu     sync.RWMutex
+	UUID   int
 }
 
 func NewMockRepository() *MockRepository {
 	return &MockRepository{
-		data: make(map[string]URLData),
+		data:   make(map[models.LinkKey]models.Link),
+		clicks: make(map[models.LinkKey][]models.ClickCount),
 	}
 }
 
-func (m *MockRepository) GetUUID() int {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	return m.UUID
+func (m *MockRepository) NextID(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.UUID++
+	return int64(m.UUID), nil
 }
 
-func (m *MockRepository) Save(shortURL, originalURL, owner string) error {
+func (m *MockRepository) Save(ctx context.Context, link models.Link) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.data[shortURL] = URLData{OriginalURL: originalURL, OwnerID: owner}
+	for _, existing := range m.data {
+		if link.ExpiresAt == nil && existing.ExpiresAt == nil && existing.Domain == link.Domain && existing.OriginalURL == link.OriginalURL {
+			return &storage.ConflictError{ShortURL: existing.ShortURL}
+		}
+	}
+	if _, exists := m.data[link.Key()]; exists {
+		return storage.ErrCodeTaken
+	}
+	link.CreatedAt = time.Now()
+	link.Status = models.LinkStatusActive
+	m.data[link.Key()] = link
 	return nil
 }
 
-func (m *MockRepository) Find(shortURL string) (string, bool) {
+func (m *MockRepository) Find(ctx context.Context, key models.LinkKey) (models.Link, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	urlData, exists := m.data[shortURL]
+	link, exists := m.data[key]
 	if !exists {
-		return "", false
+		return models.Link{}, storage.ErrNotFound
+	}
+	if link.IsDeleted() {
+		return link, storage.ErrDeleted
+	}
+	if link.IsExpired(time.Now()) {
+		return link, storage.ErrExpired
+	}
+	return link, nil
+}
+
+func (m *MockRepository) SaveBatch(ctx context.Context, inputs []models.LinkInput) ([]models.BatchResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	byOriginal := make(map[[2]string]models.Link, len(m.data))
+	for _, link := range m.data {
+		if link.ExpiresAt == nil {
+			byOriginal[[2]string{link.Domain, link.OriginalURL}] = link
+		}
+	}
+	results := make([]models.BatchResult, len(inputs))
+	created := make(map[models.LinkKey]models.Link)
+	for i, in := range inputs {
+		key := models.LinkKey{Domain: in.Domain, ShortURL: in.ShortURL}
+		if link, exists := byOriginal[[2]string{in.Domain, in.OriginalURL}]; exists && in.ExpiresAt == nil {
+			_, isNew := created[link.Key()]
+			results[i] = models.BatchResult{Link: link, Existed: !isNew}
+			continue
+		}
+		if _, exists := m.data[key]; exists {
+			return nil, storage.ErrCodeTaken
+		}
+		if _, exists := created[key]; exists {
+			return nil, storage.ErrCodeTaken
+		}
+		link := models.Link{
+			Domain:      in.Domain,
+			ShortURL:    in.ShortURL,
+			OriginalURL: in.OriginalURL,
+			Owner:       in.Owner,
+			ExpiresAt:   in.ExpiresAt,
+			CreatedAt:   time.Now(),
+			Status:      models.LinkStatusActive,
+		}
+		created[key] = link
+		if link.ExpiresAt == nil {
+			byOriginal[[2]string{link.Domain, link.OriginalURL}] = link
+		}
+		results[i] = models.BatchResult{Link: link}
+	}
+	for key, link := range created {
+		m.data[key] = link
+	}
+	return results, nil
+}
+
+func (m *MockRepository) FindAllByOwner(ctx context.Context, owner string, filter models.LinkFilter) ([]models.Link, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var links []models.Link
+	for _, link := range m.data {
+		if link.Owner == owner && filter.Match(link) {
+			links = append(links, link)
+		}
+	}
+	return links, nil
+}
+
+func (m *MockRepository) CountActiveByOwner(ctx context.Context, owner string, now time.Time) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	count := 0
+	for _, link := range m.data {
+		if link.Owner == owner && !link.IsDeleted() && !link.IsExpired(now) {
+			count++
+		}
 	}
-	return urlData.OriginalURL, true
+	return count, nil
+}
+
+func (m *MockRepository) CountNewLinks(ctx context.Context, inputs []models.LinkInput) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	seen := make(map[[2]string]bool)
+	for _, link := range m.data {
+		if link.ExpiresAt == nil {
+			seen[[2]string{link.Domain, link.OriginalURL}] = true
+		}
+	}
+	n := 0
+	for _, in := range inputs {
+		if in.ExpiresAt == nil {
+			original := [2]string{in.Domain, in.OriginalURL}
+			if seen[original] {
+				continue
+			}
+			seen[original] = true
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (m *MockRepository) DeleteBatch(ctx context.Context, owner string, keys []models.LinkKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for _, key := range keys {
+		link, exists := m.data[key]
+		if !exists || link.Owner != owner {
+			continue
+		}
+		link.DeletedAt = &now
+		link.Status = models.LinkStatusDeleted
+		m.data[key] = link
+	}
+	return nil
+}
+
+func (m *MockRepository) PurgeExpired(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var purged int64
+	for key, link := range m.data {
+		if link.ExpiresAt != nil && link.ExpiresAt.Before(before) {
+			delete(m.data, key)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (m *MockRepository) RecordClicks(ctx context.Context, counts []models.ClickCount) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range counts {
+		key := models.LinkKey{Domain: c.Domain, ShortURL: c.ShortURL}
+		c.Visitors = int64(len(c.IPHashes))
+		m.clicks[key] = append(m.clicks[key], c)
+	}
+	return nil
+}
+
+func (m *MockRepository) ClickStats(ctx context.Context, key models.LinkKey) ([]models.ClickCount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]models.ClickCount(nil), m.clicks[key]...), nil
 }
 
-func (m *MockRepository) CreateTable() error {
+func (m *MockRepository) CreateTable(ctx context.Context) error {
 	return nil
 }
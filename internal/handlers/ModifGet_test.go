@@ -10,6 +10,9 @@ import (
 	"time"
 
 	"github.com/Dnlbb/link-shortener/internal/models"
+	"github.com/Dnlbb/link-shortener/internal/shorturl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestModifFget(t *testing.T) {
@@ -221,7 +224,7 @@ func TestModifFget(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			mockRepo := NewMockRepository()
 			if tc.expectedStatus == http.StatusOK {
-				mockRepo.Save(GenerateShortURL(tc.expectedBody), tc.expectedBody, tc.owner)
+				mockRepo.Save(context.Background(), models.Link{ShortURL: GenerateShortURL(tc.expectedBody), OriginalURL: tc.expectedBody, Owner: tc.owner})
 			}
 			h := NewHandler(mockRepo)
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -258,3 +261,35 @@ func TestModifFget(t *testing.T) {
 		})
 	}
 }
+
+func TestCustomBaseURL(t *testing.T) {
+	mockRepo := NewMockRepository()
+	h := NewHandler(mockRepo, WithURLBuilder(shorturl.MustNew("https://sho.rt", "go.sho.rt")))
+	code := GenerateShortURL("https://practicum.yandex.ru/")
+
+	w := httptest.NewRecorder()
+	h.Fpost(context.Background(), w, userRequest(http.MethodPost, "/", []byte("https://practicum.yandex.ru/"), "user1"))
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "https://sho.rt/"+code, w.Body.String())
+
+	w = httptest.NewRecorder()
+	h.Fpost(context.Background(), w, userRequest(http.MethodPost, "/", []byte("https://practicum.yandex.ru/"), "user1"))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "https://sho.rt/"+code, w.Body.String())
+
+	tests := []struct {
+		shortURL       string
+		expectedStatus int
+	}{
+		{"https://sho.rt/" + code, http.StatusOK},
+		{"https://go.sho.rt/" + code, http.StatusOK},
+		{"http://localhost:8080/" + code, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		body, err := json.Marshal(models.RequestModifyGet{Body: tt.shortURL})
+		require.NoError(t, err)
+		w = httptest.NewRecorder()
+		h.ModifFget(context.Background(), w, httptest.NewRequest(http.MethodGet, "/api/shortenGet", bytes.NewReader(body)))
+		assert.Equal(t, tt.expectedStatus, w.Code, tt.shortURL)
+	}
+}
@@ -6,11 +6,15 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	middleware "github.com/Dnlbb/link-shortener/internal/Middlewares"
 	"github.com/Dnlbb/link-shortener/internal/models"
+	"github.com/Dnlbb/link-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestModifPost(t *testing.T) {
@@ -149,7 +153,7 @@ func TestModifPost(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockRepo := NewMockRepository()
-			handler := middleware.MiddlewareAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler := testAuth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				h := NewHandler(mockRepo)
 				ctx := r.Context()
 
@@ -175,7 +179,7 @@ func TestModifPost(t *testing.T) {
 				t.Fatal(err)
 			}
 			userID := "mockUserID"
-			signature := middleware.SignData(userID)
+			signature := testAuth.SignData(userID)
 			cookieValue := userID + "|" + signature
 			req.AddCookie(&http.Cookie{Name: "session_id", Value: cookieValue})
 
@@ -194,3 +198,201 @@ func TestModifPost(t *testing.T) {
 		})
 	}
 }
+
+func TestModifPostAlias(t *testing.T) {
+	mockRepo := NewMockRepository()
+	h := NewHandler(mockRepo)
+
+	post := func(body models.RequestModifyPost, userID string) *httptest.ResponseRecorder {
+		requestBody, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBuffer(requestBody))
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, userID))
+		w := httptest.NewRecorder()
+		h.ModifPost(req.Context(), w, req)
+		return w
+	}
+
+	tests := []struct {
+		name           string
+		requestBody    models.RequestModifyPost
+		userID         string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "#1 new alias",
+			requestBody:    models.RequestModifyPost{Body: "https://shop.example.com/sale", Alias: "spring-sale"},
+			userID:         "marketing",
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `"result":"http://localhost:8080/spring-sale"`,
+		},
+		{
+			name:           "#2 alias taken by the same owner",
+			requestBody:    models.RequestModifyPost{Body: "https://shop.example.com/other", Alias: "spring-sale"},
+			userID:         "marketing",
+			expectedStatus: http.StatusConflict,
+			expectedBody:   `"original_url":"https://shop.example.com/sale"`,
+		},
+		{
+			name:           "#3 alias taken by another owner",
+			requestBody:    models.RequestModifyPost{Body: "https://shop.example.com/other", Alias: "spring-sale"},
+			userID:         "someone",
+			expectedStatus: http.StatusConflict,
+			expectedBody:   `{"result":"http://localhost:8080/spring-sale"}`,
+		},
+		{
+			name:           "#4 reserved alias",
+			requestBody:    models.RequestModifyPost{Body: "https://shop.example.com/api", Alias: "API"},
+			userID:         "marketing",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "#5 alias with invalid characters",
+			requestBody:    models.RequestModifyPost{Body: "https://shop.example.com/x", Alias: "sale/2024"},
+			userID:         "marketing",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "#6 alias too short",
+			requestBody:    models.RequestModifyPost{Body: "https://shop.example.com/y", Alias: "ab"},
+			userID:         "marketing",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := post(tt.requestBody, tt.userID)
+			if w.Code != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", w.Code, tt.expectedStatus)
+			}
+			if tt.expectedBody != "" && !bytes.Contains(w.Body.Bytes(), []byte(tt.expectedBody)) {
+				t.Errorf("handler returned unexpected body: got %v want %v", w.Body.String(), tt.expectedBody)
+			}
+		})
+	}
+}
+
+func TestModifPostExpiry(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name           string
+		requestBody    models.RequestModifyPost
+		expectedStatus int
+		expectExpiry   bool
+	}{
+		{
+			name:           "#1 ttl",
+			requestBody:    models.RequestModifyPost{Body: "https://example.com/ttl", TTL: "1h"},
+			expectedStatus: http.StatusCreated,
+			expectExpiry:   true,
+		},
+		{
+			name:           "#2 expires_at",
+			requestBody:    models.RequestModifyPost{Body: "https://example.com/at", ExpiresAt: &future},
+			expectedStatus: http.StatusCreated,
+			expectExpiry:   true,
+		},
+		{
+			name:           "#3 no expiry",
+			requestBody:    models.RequestModifyPost{Body: "https://example.com/forever"},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "#4 expires_at in the past",
+			requestBody:    models.RequestModifyPost{Body: "https://example.com/past", ExpiresAt: &past},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "#5 invalid ttl",
+			requestBody:    models.RequestModifyPost{Body: "https://example.com/bad", TTL: "tomorrow"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "#6 negative ttl",
+			requestBody:    models.RequestModifyPost{Body: "https://example.com/neg", TTL: "-5m"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "#7 ttl and expires_at together",
+			requestBody:    models.RequestModifyPost{Body: "https://example.com/both", TTL: "1h", ExpiresAt: &future},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := NewMockRepository()
+			h := NewHandler(mockRepo)
+			requestBody, err := json.Marshal(tt.requestBody)
+			require.NoError(t, err)
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBuffer(requestBody))
+			req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+			w := httptest.NewRecorder()
+			h.ModifPost(req.Context(), w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus != http.StatusCreated {
+				return
+			}
+			link, err := mockRepo.Find(context.Background(), models.LinkKey{ShortURL: GenerateShortURL(tt.requestBody.Body)})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectExpiry, link.ExpiresAt != nil)
+		})
+	}
+}
+
+func TestModifPostExpiredAndExpiringLinks(t *testing.T) {
+	post := func(h *Handler, body models.RequestModifyPost) (int, string) {
+		requestBody, err := json.Marshal(body)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBuffer(requestBody))
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+		w := httptest.NewRecorder()
+		h.ModifPost(req.Context(), w, req)
+		var resp models.ResponseModifyPost
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return w.Code, strings.TrimPrefix(resp.Body, "http://localhost:8080/")
+	}
+
+	t.Run("expired link frees its URL", func(t *testing.T) {
+		repo := storage.NewInMemoryStorage()
+		h := NewHandler(repo)
+		original := "https://example.com/expired"
+		past := time.Now().Add(-time.Hour)
+		require.NoError(t, repo.Save(context.Background(), models.Link{ShortURL: GenerateShortURL(original), OriginalURL: original, ExpiresAt: &past}))
+
+		status, code := post(h, models.RequestModifyPost{Body: original})
+		require.Equal(t, http.StatusCreated, status)
+		assert.NotEqual(t, GenerateShortURL(original), code)
+		link, err := repo.Find(context.Background(), models.LinkKey{ShortURL: code})
+		require.NoError(t, err)
+		assert.Equal(t, original, link.OriginalURL)
+		assert.Nil(t, link.ExpiresAt)
+	})
+
+	t.Run("expiring request gets its own link", func(t *testing.T) {
+		repo := storage.NewInMemoryStorage()
+		h := NewHandler(repo)
+		original := "https://example.com/campaign"
+
+		status, permanent := post(h, models.RequestModifyPost{Body: original})
+		require.Equal(t, http.StatusCreated, status)
+
+		status, expiring := post(h, models.RequestModifyPost{Body: original, TTL: "1h"})
+		require.Equal(t, http.StatusCreated, status)
+		assert.NotEqual(t, permanent, expiring)
+		link, err := repo.Find(context.Background(), models.LinkKey{ShortURL: expiring})
+		require.NoError(t, err)
+		assert.NotNil(t, link.ExpiresAt)
+
+		status, again := post(h, models.RequestModifyPost{Body: original})
+		assert.Equal(t, http.StatusConflict, status)
+		assert.Equal(t, permanent, again)
+	})
+}
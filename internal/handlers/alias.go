@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Dnlbb/link-shortener/internal/models"
+	"github.com/Dnlbb/link-shortener/internal/storage"
+)
+
+const (
+	minAliasLength = 3
+	maxAliasLength = 64
+)
+
+// reservedAliases collide with the service routes.
+var reservedAliases = map[string]bool{
+	"api":     true,
+	"ping":    true,
+	"user":    true,
+	"shorten": true,
+	"metrics": true,
+	"health":  true,
+	"admin":   true,
+	"static":  true,
+}
+
+func validateAlias(alias string) error {
+	if len(alias) < minAliasLength || len(alias) > maxAliasLength {
+		return fmt.Errorf("alias must be between %d and %d characters long", minAliasLength, maxAliasLength)
+	}
+	for _, c := range alias {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return errors.New("alias may only contain latin letters, digits, '-' and '_'")
+		}
+	}
+	if reservedAliases[strings.ToLower(alias)] {
+		return fmt.Errorf("alias %q is reserved", alias)
+	}
+	return nil
+}
+
+// aliasTakenError reports a requested alias that is already used by another
+// link of the domain. index is the position of the alias in a batch request.
+type aliasTakenError struct {
+	key   models.LinkKey
+	index int
+	link  models.Link
+}
+
+func (e *aliasTakenError) Error() string {
+	return fmt.Sprintf("alias %s is taken", e.key.ShortURL)
+}
+
+func (e *aliasTakenError) Unwrap() error {
+	return storage.ErrCodeTaken
+}
+
+// findTakenAlias returns an aliasTakenError for the first alias that is
+// already stored, or nil when all of them are free.
+func (h *Handler) findTakenAlias(ctx context.Context, aliases map[int]models.LinkKey) error {
+	for index, key := range aliases {
+		link, err := h.repo.Find(ctx, key)
+		if err == nil || errors.Is(err, storage.ErrDeleted) || errors.Is(err, storage.ErrExpired) {
+			return &aliasTakenError{key: key, index: index, link: link}
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
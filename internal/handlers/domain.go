@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Dnlbb/link-shortener/internal/models"
+)
+
+// linkDomain normalises the domain of a request, the empty domain is the one
+// of the base URL.
+func (h *Handler) linkDomain(domain string) (string, error) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if !h.urls.HasDomain(domain) {
+		return "", fmt.Errorf("unknown domain %q", domain)
+	}
+	return domain, nil
+}
+
+// queryDomain returns the domain in the domain query parameter.
+func (h *Handler) queryDomain(r *http.Request) (string, error) {
+	return h.linkDomain(r.URL.Query().Get("domain"))
+}
+
+// queryFilter returns the filter of the domain query parameters, an empty
+// value selects the links of the base URL. Without the parameter all domains
+// are listed.
+func (h *Handler) queryFilter(r *http.Request) (models.LinkFilter, error) {
+	var filter models.LinkFilter
+	for _, domain := range r.URL.Query()["domain"] {
+		domain, err := h.linkDomain(domain)
+		if err != nil {
+			return models.LinkFilter{}, err
+		}
+		filter.Domains = append(filter.Domains, domain)
+	}
+	return filter, nil
+}
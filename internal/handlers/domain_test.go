@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Dnlbb/link-shortener/internal/models"
+	"github.com/Dnlbb/link-shortener/internal/shorturl"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDomains(t *testing.T) {
+	mockRepo := NewMockRepository()
+	h := NewHandler(mockRepo, WithURLBuilder(shorturl.MustNew("https://sho.rt").WithDomains("brand.example")))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	shorten := func(req models.RequestModifyPost) *httptest.ResponseRecorder {
+		body, err := json.Marshal(req)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		h.ModifPost(ctx, w, userRequest(http.MethodPost, "/api/shorten", body, "user1"))
+		return w
+	}
+
+	tests := []struct {
+		name     string
+		req      models.RequestModifyPost
+		wantCode int
+		wantURL  string
+	}{
+		{
+			name:     "base url",
+			req:      models.RequestModifyPost{Body: "https://practicum.yandex.ru/", Alias: "sale"},
+			wantCode: http.StatusCreated,
+			wantURL:  "https://sho.rt/sale",
+		},
+		{
+			name:     "same alias on another domain",
+			req:      models.RequestModifyPost{Body: "https://google.com/", Alias: "sale", Domain: "Brand.example"},
+			wantCode: http.StatusCreated,
+			wantURL:  "https://brand.example/sale",
+		},
+		{
+			name:     "same url on another domain",
+			req:      models.RequestModifyPost{Body: "https://practicum.yandex.ru/", Domain: "brand.example"},
+			wantCode: http.StatusCreated,
+			wantURL:  "https://brand.example/" + GenerateShortURL("https://practicum.yandex.ru/"),
+		},
+		{
+			name:     "taken alias on the domain",
+			req:      models.RequestModifyPost{Body: "https://example.com/", Alias: "sale", Domain: "brand.example"},
+			wantCode: http.StatusConflict,
+			wantURL:  "https://brand.example/sale",
+		},
+		{
+			name:     "unknown domain",
+			req:      models.RequestModifyPost{Body: "https://example.com/", Domain: "evil.example"},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := shorten(tt.req)
+			require.Equal(t, tt.wantCode, w.Code, w.Body.String())
+			if tt.wantURL == "" {
+				return
+			}
+			var resp models.ResponseModifyPost
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, tt.wantURL, resp.Body)
+		})
+	}
+
+	r := chi.NewRouter()
+	r.Get("/{shortURL}", h.FgetAdapter())
+	for host, want := range map[string]string{
+		"sho.rt":        "https://practicum.yandex.ru/",
+		"BRAND.example": "https://google.com/",
+		"other.example": "https://practicum.yandex.ru/",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/sale", nil)
+		req.Host = host
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusTemporaryRedirect, w.Code, host)
+		assert.Equal(t, want, w.Header().Get("Location"), host)
+	}
+
+	listURLs := func(query string) (int, []models.ResponseToOwner) {
+		w := httptest.NewRecorder()
+		h.GetUserURLs(ctx, w, userRequest(http.MethodGet, "/api/user/urls"+query, nil, "user1"))
+		var urls []models.ResponseToOwner
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &urls))
+		}
+		return w.Code, urls
+	}
+	code, urls := listURLs("")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, urls, 3)
+	code, urls = listURLs("?domain=brand.example")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, urls, 2)
+	code, urls = listURLs("?domain=")
+	assert.Equal(t, http.StatusOK, code)
+	require.Len(t, urls, 1)
+	assert.Equal(t, "https://sho.rt/sale", urls[0].ShortURL)
+	code, _ = listURLs("?domain=evil.example")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	del, err := json.Marshal(models.UserDelUrls{"sale"})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	h.DelUserUrls(ctx, w, userRequest(http.MethodDelete, "/api/user/urls?domain=brand.example", del, "user1"))
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	link, err := mockRepo.Find(ctx, models.LinkKey{ShortURL: "sale"})
+	require.NoError(t, err)
+	assert.False(t, link.IsDeleted())
+	link, _ = mockRepo.Find(ctx, models.LinkKey{Domain: "brand.example", ShortURL: "sale"})
+	assert.True(t, link.IsDeleted())
+}
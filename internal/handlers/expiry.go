@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// parseExpiry turns the expires_at or ttl fields of a request into the expiry
+// time of the link, nil means the link never expires.
+func parseExpiry(expiresAt *time.Time, ttl string, now time.Time) (*time.Time, error) {
+	if expiresAt != nil && ttl != "" {
+		return nil, errors.New("expires_at and ttl cannot be used together")
+	}
+	if ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ttl %q", ttl)
+		}
+		if d <= 0 {
+			return nil, errors.New("ttl must be positive")
+		}
+		at := now.Add(d)
+		return &at, nil
+	}
+	if expiresAt != nil && !expiresAt.After(now) {
+		return nil, errors.New("expires_at must be in the future")
+	}
+	return expiresAt, nil
+}
@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Dnlbb/link-shortener/internal/models"
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -36,6 +37,8 @@ func TestFget(t *testing.T) {
 		request     Request
 		originalURL string
 		owner       string
+		deleted     bool
+		expired     bool
 	}{
 		{
 			name: "#1 Valid short URL",
@@ -349,6 +352,44 @@ func TestFget(t *testing.T) {
 			originalURL: "https://example.com/page&section",
 			owner:       "user1",
 		},
+		{
+			name: "#27 Deleted short URL",
+			want: Want{
+				statusCode: http.StatusGone,
+				location:   "",
+			},
+			request: Request{
+				path: "/" + GenerateShortURL("https://example.com/deleted"),
+			},
+			originalURL: "https://example.com/deleted",
+			owner:       "user1",
+			deleted:     true,
+		},
+		{
+			name: "#28 Original URL equal to the old deleted marker",
+			want: Want{
+				statusCode: http.StatusTemporaryRedirect,
+				location:   "deleted",
+			},
+			request: Request{
+				path: "/" + GenerateShortURL("deleted"),
+			},
+			originalURL: "deleted",
+			owner:       "user1",
+		},
+		{
+			name: "#29 Expired short URL",
+			want: Want{
+				statusCode: http.StatusGone,
+				location:   "",
+			},
+			request: Request{
+				path: "/" + GenerateShortURL("https://example.com/expired"),
+			},
+			originalURL: "https://example.com/expired",
+			owner:       "user1",
+			expired:     true,
+		},
 	}
 
 	for _, test := range testCases {
@@ -358,7 +399,15 @@ func TestFget(t *testing.T) {
 			shortURL := GenerateShortURL(test.originalURL)
 
 			if test.originalURL != "" {
-				mockRepo.Save(shortURL, test.originalURL, test.owner)
+				link := models.Link{ShortURL: shortURL, OriginalURL: test.originalURL, Owner: test.owner}
+				if test.expired {
+					expiresAt := time.Now().Add(-time.Minute)
+					link.ExpiresAt = &expiresAt
+				}
+				mockRepo.Save(context.Background(), link)
+			}
+			if test.deleted {
+				require.NoError(t, mockRepo.DeleteBatch(context.Background(), test.owner, []models.LinkKey{{ShortURL: shortURL}}))
 			}
 
 			r := chi.NewRouter()
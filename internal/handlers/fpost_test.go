@@ -9,8 +9,11 @@ import (
 	"time"
 
 	middleware "github.com/Dnlbb/link-shortener/internal/Middlewares"
-	"github.com/Dnlbb/link-shortener/internal/config"
+	"github.com/Dnlbb/link-shortener/internal/generator"
+	"github.com/Dnlbb/link-shortener/internal/models"
+	"github.com/Dnlbb/link-shortener/internal/storage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFpost(t *testing.T) {
@@ -295,12 +298,11 @@ func TestFpost(t *testing.T) {
 			},
 		},
 	}
-	config.Conf.Key = "test-secret-key"
 
 	for _, test := range testCases {
 		t.Run(test.name, func(t *testing.T) {
 			mockRepo := NewMockRepository()
-			handler := middleware.MiddlewareAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler := testAuth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				h := NewHandler(mockRepo)
 				ctx := r.Context()
 
@@ -310,7 +312,7 @@ func TestFpost(t *testing.T) {
 			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.request.body))
 
 			userID := "mockUserID"
-			signature := middleware.SignData(userID)
+			signature := testAuth.SignData(userID)
 			cookieValue := userID + "|" + signature
 			req.AddCookie(&http.Cookie{Name: "session_id", Value: cookieValue})
 
@@ -328,3 +330,47 @@ func TestFpost(t *testing.T) {
 		})
 	}
 }
+
+func TestFpostCodeCollision(t *testing.T) {
+	mockRepo := NewMockRepository()
+	taken := GenerateShortURL("https://practicum.yandex.ru/")
+	err := mockRepo.Save(context.Background(), models.Link{ShortURL: taken, OriginalURL: "https://other.example.com/", Owner: "user2"})
+	assert.NoError(t, err)
+
+	h := NewHandler(mockRepo)
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://practicum.yandex.ru/"))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+	w := httptest.NewRecorder()
+	h.Fpost(req.Context(), w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NotContains(t, w.Body.String(), taken)
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("https://practicum.yandex.ru/"))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+	conflict := httptest.NewRecorder()
+	h.Fpost(req.Context(), conflict, req)
+
+	assert.Equal(t, http.StatusConflict, conflict.Code)
+	assert.Equal(t, w.Body.String(), conflict.Body.String())
+}
+
+func TestFpostSequenceCodes(t *testing.T) {
+	repo := storage.NewInMemoryStorage()
+	require.NoError(t, repo.Save(context.Background(), models.Link{ShortURL: "spring-sale", OriginalURL: "https://shop.example.com/sale"}))
+	h := NewHandler(repo, WithGenerator(generator.NewSequence(repo)))
+
+	for i, original := range []string{"https://a.example.com/", "https://b.example.com/"} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(original))
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+		w := httptest.NewRecorder()
+		h.Fpost(req.Context(), w, req)
+		require.Equal(t, http.StatusCreated, w.Code)
+
+		code := strings.TrimPrefix(w.Body.String(), "http://localhost:8080/")
+		link, err := repo.Find(context.Background(), models.LinkKey{ShortURL: code})
+		require.NoError(t, err)
+		assert.Equal(t, int64(i+2), link.ID)
+		assert.Equal(t, generator.EncodeBase62(uint64(link.ID)), code)
+	}
+}
@@ -3,60 +3,128 @@ package handlers
 import (
 	"bytes"
 	"context"
-	"crypto/sha1"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
-	"os"
-	"strings"
-	"sync"
-
-	"path/filepath"
+	"time"
 
 	middlewares "github.com/Dnlbb/link-shortener/internal/Middlewares"
-	"github.com/Dnlbb/link-shortener/internal/config"
+	"github.com/Dnlbb/link-shortener/internal/deleter"
+	"github.com/Dnlbb/link-shortener/internal/generator"
+	"github.com/Dnlbb/link-shortener/internal/logger"
 	"github.com/Dnlbb/link-shortener/internal/models"
+	"github.com/Dnlbb/link-shortener/internal/requestid"
+	"github.com/Dnlbb/link-shortener/internal/shorturl"
 	"github.com/Dnlbb/link-shortener/internal/storage"
 	"github.com/go-chi/chi/v5"
 )
 
+// Deleter queues links for asynchronous deletion.
+type Deleter interface {
+	Enqueue(ctx context.Context, owner string, keys []models.LinkKey) error
+}
+
 type Handler struct {
-	repo storage.Repository
+	repo      storage.Repository
+	deleter   Deleter
+	generator generator.ShortCodeGenerator
+	clicks    ClickRecorder
+	ipSalt    string
+	urls      *shorturl.Builder
+	users     Users
+	quotas    Quotas
+	metrics   Metrics
 }
 
-func NewHandler(repo storage.Repository) *Handler {
-	return &Handler{repo: repo}
+type Option func(*Handler)
+
+// Metrics counts the links created and the redirects served.
+type Metrics interface {
+	LinksCreated(n int)
+	Redirected()
+}
+
+type noMetrics struct{}
+
+func (noMetrics) LinksCreated(int) {}
+func (noMetrics) Redirected()      {}
+
+// WithMetrics counts the links created and the redirects served.
+func WithMetrics(m Metrics) Option {
+	return func(h *Handler) {
+		h.metrics = m
+	}
 }
 
-func saveToFile(filename string, data []byte) error {
-	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
-		return err
+// defaultURLs matches the links the service rendered before BASE_URL was
+// honoured everywhere.
+var defaultURLs = shorturl.MustNew("http://localhost:8080", "127.0.0.1:8080")
+
+// maxGenerateAttempts bounds the retries after short code collisions.
+const maxGenerateAttempts = 5
+
+// WithDeleter makes DelUserUrls queue deletions instead of running them
+// within the request.
+func WithDeleter(d Deleter) Option {
+	return func(h *Handler) {
+		h.deleter = d
 	}
+}
 
-	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
-	if err != nil {
-		return err
+// WithGenerator sets the short code strategy, the SHA-1 hash is used by
+// default.
+func WithGenerator(g generator.ShortCodeGenerator) Option {
+	return func(h *Handler) {
+		h.generator = g
 	}
-	defer file.Close()
+}
 
-	stat, err := file.Stat()
-	if err != nil {
-		return err
+// WithURLBuilder sets how short links are rendered and parsed, by default
+// under http://localhost:8080.
+func WithURLBuilder(b *shorturl.Builder) Option {
+	return func(h *Handler) {
+		h.urls = b
 	}
-	if stat.Size() > 0 {
-		if _, err = file.WriteString("\n"); err != nil {
-			return err
-		}
+}
+
+func NewHandler(repo storage.Repository, opts ...Option) *Handler {
+	h := &Handler{repo: repo, generator: generator.NewHash(), urls: defaultURLs, metrics: noMetrics{}}
+	for _, opt := range opts {
+		opt(h)
 	}
-	_, err = file.Write(data)
-	if err != nil {
-		return err
+	return h
+}
+
+// writeError replies like http.Error with the request ID at the end of the
+// body, for the client to quote.
+func writeError(ctx context.Context, w http.ResponseWriter, msg string, code int) {
+	requestid.Error(ctx, w, msg, code)
+}
+
+// writeStorageError maps a repository error to the HTTP response.
+func writeStorageError(ctx context.Context, w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		writeError(ctx, w, "The link was not found in the repository.", http.StatusBadRequest)
+	case errors.Is(err, storage.ErrDeleted):
+		w.WriteHeader(http.StatusGone)
+	case errors.Is(err, storage.ErrExpired):
+		writeError(ctx, w, "The link has expired.", http.StatusGone)
+	case errors.Is(err, storage.ErrConflict):
+		writeError(ctx, w, "The link already exists.", http.StatusConflict)
+	case errors.Is(err, context.DeadlineExceeded):
+		writeError(ctx, w, "Request timed out", http.StatusGatewayTimeout)
+	case errors.Is(err, context.Canceled):
+		writeError(ctx, w, "Request cancelled by the client", http.StatusRequestTimeout)
+	case errors.Is(err, deleter.ErrClosed):
+		writeError(ctx, w, "Service is shutting down", http.StatusServiceUnavailable)
+	default:
+		logger.FromContext(ctx).WithFields(map[string]interface{}{logger.FieldError: err}).Error("Repository error")
+		writeError(ctx, w, "Internal Server Error", http.StatusInternalServerError)
 	}
-	return nil
 }
 
 func (h *Handler) Fpost(ctx context.Context, w http.ResponseWriter, r *http.Request) {
@@ -64,15 +132,15 @@ func (h *Handler) Fpost(ctx context.Context, w http.ResponseWriter, r *http.Requ
 	case <-ctx.Done():
 
 		if ctx.Err() == context.DeadlineExceeded {
-			http.Error(w, "Request timed out", http.StatusGatewayTimeout)
+			writeError(ctx, w, "Request timed out", http.StatusGatewayTimeout)
 		} else {
-			http.Error(w, "Request cancelled by the client", http.StatusRequestTimeout)
+			writeError(ctx, w, "Request cancelled by the client", http.StatusRequestTimeout)
 		}
 		return
 	default:
 		userID, ok := r.Context().Value(middlewares.UserIDKey).(string)
 		if !ok {
-			http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+			writeError(ctx, w, "User ID not found in context", http.StatusInternalServerError)
 			return
 		}
 		body, err := io.ReadAll(r.Body)
@@ -91,52 +159,115 @@ func (h *Handler) Fpost(ctx context.Context, w http.ResponseWriter, r *http.Requ
 			return
 		}
 
-		shortURL := GenerateShortURL(originalURL)
-
-		_, exists := h.repo.Find(shortURL)
-		if exists {
+		input := models.LinkInput{OriginalURL: originalURL, Owner: userID}
+		if !h.checkQuota(ctx, w, userID, []models.LinkInput{input}) {
+			return
+		}
+		shortURL, err := h.shorten(ctx, input)
+		var conflict *storage.ConflictError
+		if errors.As(err, &conflict) {
 			w.Header().Set("Content-Type", "text/plain")
 			w.WriteHeader(http.StatusConflict)
-			w.Write([]byte("http://localhost:8080/" + shortURL))
+			w.Write([]byte(h.urls.Build(conflict.ShortURL)))
 			return
 		}
-
-		err = h.repo.Save(shortURL, originalURL, userID)
 		if err != nil {
-			log.Printf("Error saving to repository: %v", err)
-			http.Error(w, "Error saving the link to the repository", http.StatusInternalServerError)
-			return
-		}
-		path := config.Conf.Result
-		if path == "" {
-			path = "http://localhost:8080"
-		}
-		if config.Conf.File != "" {
-			ToFile := struct {
-				UUID        int    `json:"uuid"`
-				ShortURL    string `json:"short_url"`
-				OriginalURL string `json:"original_url"`
-			}{
-				UUID:        h.repo.GetUUID(),
-				ShortURL:    "http://localhost:8080/" + shortURL,
-				OriginalURL: originalURL,
-			}
-
-			if jsonToFile, err := json.Marshal(ToFile); err == nil {
-				saveToFile(config.Conf.File, jsonToFile)
-			}
+			writeStorageError(ctx, w, err)
+			return
 		}
-		response := fmt.Sprintf("%s/%s", path, shortURL)
+		h.metrics.LinksCreated(1)
+		response := h.urls.Build(shortURL)
 		w.Header().Set("Content-Type", "text/plain")
 		w.WriteHeader(http.StatusCreated)
 		w.Write([]byte(response))
 	}
 }
 
+// shorten saves the input under its ShortURL, which is the alias, or under a
+// generated short code, generating a new code while the previous one is taken
+// by another URL. When the URL has already been shortened the
+// storage.ConflictError of Save is returned, a taken alias is reported with an
+// aliasTakenError.
+func (h *Handler) shorten(ctx context.Context, in models.LinkInput) (string, error) {
+	link := models.Link{Domain: in.Domain, ShortURL: in.ShortURL, OriginalURL: in.OriginalURL, Owner: in.Owner, ExpiresAt: in.ExpiresAt}
+	if in.ShortURL != "" {
+		err := h.repo.Save(ctx, link)
+		if errors.Is(err, storage.ErrCodeTaken) {
+			if takenErr := h.findTakenAlias(ctx, map[int]models.LinkKey{0: link.Key()}); takenErr != nil {
+				return "", takenErr
+			}
+		}
+		return in.ShortURL, err
+	}
+
+	for attempt := 0; attempt < maxGenerateAttempts; attempt++ {
+		id, shortURL, err := h.generate(ctx, in.OriginalURL, attempt)
+		if err != nil {
+			return "", err
+		}
+		link.ID, link.ShortURL = id, shortURL
+		err = h.repo.Save(ctx, link)
+		if !errors.Is(err, storage.ErrCodeTaken) {
+			return shortURL, err
+		}
+	}
+	return "", storage.ErrCodeTaken
+}
+
+// shortenBatch saves the inputs atomically, regenerating every code without
+// an alias when one of them collides with a stored link. A taken alias is
+// reported with an aliasTakenError. Repeats of an original URL inside the
+// batch continue its attempt counter, so expiring repeats, which get links
+// of their own, are not given the same code.
+func (h *Handler) shortenBatch(ctx context.Context, batch []models.LinkInput) ([]models.BatchResult, error) {
+	inputs := make([]models.LinkInput, len(batch))
+	aliases := make(map[int]models.LinkKey)
+	repeats := make([]int, len(batch))
+	seen := make(map[[2]string]int)
+	for i, in := range batch {
+		if in.ShortURL != "" {
+			aliases[i] = models.LinkKey{Domain: in.Domain, ShortURL: in.ShortURL}
+			continue
+		}
+		original := [2]string{in.Domain, in.OriginalURL}
+		repeats[i] = seen[original]
+		seen[original]++
+	}
+	for attempt := 0; attempt < maxGenerateAttempts; attempt++ {
+		for i, in := range batch {
+			if in.ShortURL == "" {
+				id, shortURL, err := h.generate(ctx, in.OriginalURL, repeats[i]*maxGenerateAttempts+attempt)
+				if err != nil {
+					return nil, err
+				}
+				in.ID, in.ShortURL = id, shortURL
+			}
+			inputs[i] = in
+		}
+		results, err := h.repo.SaveBatch(ctx, inputs)
+		if !errors.Is(err, storage.ErrCodeTaken) {
+			return results, err
+		}
+		if takenErr := h.findTakenAlias(ctx, aliases); takenErr != nil {
+			return nil, takenErr
+		}
+	}
+	return nil, storage.ErrCodeTaken
+}
+
+// generate returns a new code and, for the generators encoding a reserved
+// row ID, the ID the link must be saved with.
+func (h *Handler) generate(ctx context.Context, originalURL string, attempt int) (int64, string, error) {
+	if g, ok := h.generator.(generator.IDGenerator); ok {
+		return g.GenerateID(ctx)
+	}
+	shortURL, err := h.generator.Generate(ctx, originalURL, attempt)
+	return 0, shortURL, err
+}
+
+// GenerateShortURL returns the default hash code of the URL.
 func GenerateShortURL(url string) string {
-	hash := sha1.New()
-	hash.Write([]byte(url))
-	return hex.EncodeToString(hash.Sum(nil))[:8]
+	return generator.Hash(url, 0)
 }
 
 func (h *Handler) Fget(ctx context.Context, w http.ResponseWriter, r *http.Request) {
@@ -144,31 +275,28 @@ func (h *Handler) Fget(ctx context.Context, w http.ResponseWriter, r *http.Reque
 	select {
 	case <-ctx.Done():
 		if ctx.Err() == context.DeadlineExceeded {
-			http.Error(w, "Request timed out", http.StatusGatewayTimeout)
+			writeError(ctx, w, "Request timed out", http.StatusGatewayTimeout)
 		} else {
-			http.Error(w, "Request cancelled by the client", http.StatusRequestTimeout)
+			writeError(ctx, w, "Request cancelled by the client", http.StatusRequestTimeout)
 		}
 		return
 	default:
 
 		shortURL := chi.URLParam(r, "shortURL")
-		originalURL, exists := h.repo.Find(shortURL)
-		if originalURL == "deleted" {
-			w.WriteHeader(http.StatusGone)
-			return
-		}
 		if shortURL == "" {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		if !exists {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("The link was not found in the repository."))
+		link, err := h.repo.Find(ctx, models.LinkKey{Domain: h.urls.Domain(r.Host), ShortURL: shortURL})
+		if err != nil {
+			writeStorageError(ctx, w, err)
 			return
 		}
 
-		w.Header().Set("Location", originalURL)
+		h.recordClick(r, link.Key())
+		h.metrics.Redirected()
+		w.Header().Set("Location", link.OriginalURL)
 		w.WriteHeader(http.StatusTemporaryRedirect)
 	}
 }
@@ -177,9 +305,9 @@ func (h *Handler) ModifPost(ctx context.Context, w http.ResponseWriter, r *http.
 	select {
 	case <-ctx.Done():
 		if ctx.Err() == context.DeadlineExceeded {
-			http.Error(w, "Request timed out", http.StatusGatewayTimeout)
+			writeError(ctx, w, "Request timed out", http.StatusGatewayTimeout)
 		} else {
-			http.Error(w, "Request cancelled by the client", http.StatusRequestTimeout)
+			writeError(ctx, w, "Request cancelled by the client", http.StatusRequestTimeout)
 		}
 		return
 	default:
@@ -187,7 +315,7 @@ func (h *Handler) ModifPost(ctx context.Context, w http.ResponseWriter, r *http.
 		var buf bytes.Buffer
 		_, err := buf.ReadFrom(r.Body)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(ctx, w, err.Error(), http.StatusBadRequest)
 			w.Write([]byte("Error reading the request body"))
 			return
 		}
@@ -217,21 +345,49 @@ func (h *Handler) ModifPost(ctx context.Context, w http.ResponseWriter, r *http.
 			w.Write([]byte("Url parsing error or empty schema or empty host"))
 			return
 		}
+		if req.Alias != "" {
+			if err := validateAlias(req.Alias); err != nil {
+				writeError(ctx, w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		expiresAt, err := parseExpiry(req.ExpiresAt, req.TTL, time.Now())
+		if err != nil {
+			writeError(ctx, w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		domain, err := h.linkDomain(req.Domain)
+		if err != nil {
+			writeError(ctx, w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		userID, ok := r.Context().Value(middlewares.UserIDKey).(string)
 		if !ok {
-			http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+			writeError(ctx, w, "User ID not found in context", http.StatusInternalServerError)
 			return
 		}
-		shortURL := GenerateShortURL(req.Body)
-
-		_, exists := h.repo.Find(shortURL)
-		if exists {
+		input := models.LinkInput{
+			Domain:      domain,
+			ShortURL:    req.Alias,
+			OriginalURL: req.Body,
+			Owner:       userID,
+			ExpiresAt:   expiresAt,
+		}
+		if !h.checkQuota(ctx, w, userID, []models.LinkInput{input}) {
+			return
+		}
+		shortURL, err := h.shorten(ctx, input)
+		var taken *aliasTakenError
+		if errors.As(err, &taken) {
 			respStruct := models.ResponseModifyPost{
-				Body: "http://localhost:8080/" + shortURL,
+				Body: h.urls.BuildFor(domain, taken.key.ShortURL),
+			}
+			if taken.link.Owner == userID {
+				respStruct.OriginalURL = taken.link.OriginalURL
 			}
 			resp, err := json.Marshal(respStruct)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+				writeError(ctx, w, err.Error(), http.StatusBadRequest)
 				return
 			}
 			w.Header().Set("Content-Type", "application/json")
@@ -239,34 +395,32 @@ func (h *Handler) ModifPost(ctx context.Context, w http.ResponseWriter, r *http.
 			w.Write(resp)
 			return
 		}
-		err = h.repo.Save(shortURL, req.Body, userID)
-
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("error saving the link to the repository"))
-			return
-		}
-		if config.Conf.File != "" {
-			ToFile := struct {
-				UUID        int    `json:"uuid"`
-				ShortURL    string `json:"short_url"`
-				OriginalURL string `json:"original_url"`
-			}{
-				UUID:        h.repo.GetUUID(),
-				ShortURL:    "http://localhost:8080/" + shortURL,
-				OriginalURL: req.Body,
+		var conflict *storage.ConflictError
+		if errors.As(err, &conflict) {
+			respStruct := models.ResponseModifyPost{
+				Body: h.urls.BuildFor(domain, conflict.ShortURL),
 			}
-
-			if jsonToFile, err := json.Marshal(ToFile); err == nil {
-				saveToFile(config.Conf.File, jsonToFile)
+			resp, err := json.Marshal(respStruct)
+			if err != nil {
+				writeError(ctx, w, err.Error(), http.StatusBadRequest)
+				return
 			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			w.Write(resp)
+			return
 		}
+		if err != nil {
+			writeStorageError(ctx, w, err)
+			return
+		}
+		h.metrics.LinksCreated(1)
 		respStruct := models.ResponseModifyPost{
-			Body: "http://localhost:8080/" + shortURL,
+			Body: h.urls.BuildFor(domain, shortURL),
 		}
 		resp, err := json.Marshal(respStruct)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(ctx, w, err.Error(), http.StatusBadRequest)
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
@@ -279,9 +433,9 @@ func (h *Handler) ModifFget(ctx context.Context, w http.ResponseWriter, r *http.
 	select {
 	case <-ctx.Done():
 		if ctx.Err() == context.DeadlineExceeded {
-			http.Error(w, "Request timed out", http.StatusGatewayTimeout)
+			writeError(ctx, w, "Request timed out", http.StatusGatewayTimeout)
 		} else {
-			http.Error(w, "Request cancelled by the client", http.StatusRequestTimeout)
+			writeError(ctx, w, "Request cancelled by the client", http.StatusRequestTimeout)
 		}
 		return
 	default:
@@ -289,7 +443,7 @@ func (h *Handler) ModifFget(ctx context.Context, w http.ResponseWriter, r *http.
 		var buf bytes.Buffer
 		_, err := buf.ReadFrom(r.Body)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(ctx, w, err.Error(), http.StatusBadRequest)
 			w.Write([]byte("Error reading the request body"))
 			return
 		}
@@ -313,31 +467,29 @@ func (h *Handler) ModifFget(ctx context.Context, w http.ResponseWriter, r *http.
 			return
 		}
 
-		baseURL := "http://localhost:8080/"
-		if strings.HasPrefix(req.Body, "http://127.0.0.1:8080/") {
-			baseURL = "http://127.0.0.1:8080/"
+		domain, code, err := h.urls.Parse(req.Body)
+		if errors.Is(err, shorturl.ErrForeignHost) {
+			writeError(ctx, w, "The link does not belong to this service.", http.StatusBadRequest)
+			return
 		}
-
-		key := strings.Replace(req.Body, baseURL, "", 1)
-		originalURL, exists := h.repo.Find(key)
-		if key == "" {
+		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte("Url parsing error or empty schema or empty host"))
 			return
 		}
 
-		if !exists {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("The link was not found in the repository."))
+		link, err := h.repo.Find(ctx, models.LinkKey{Domain: domain, ShortURL: code})
+		if err != nil {
+			writeStorageError(ctx, w, err)
 			return
 		}
 
 		respStruct := models.ResponseModifyGet{
-			Body: originalURL,
+			Body: link.OriginalURL,
 		}
 		resp, err := json.Marshal(respStruct)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(ctx, w, err.Error(), http.StatusBadRequest)
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
@@ -350,85 +502,112 @@ func (h *Handler) Batch(ctx context.Context, w http.ResponseWriter, r *http.Requ
 	select {
 	case <-ctx.Done():
 		if ctx.Err() == context.DeadlineExceeded {
-			http.Error(w, "Request timed out", http.StatusGatewayTimeout)
+			writeError(ctx, w, "Request timed out", http.StatusGatewayTimeout)
 		} else {
-			http.Error(w, "Request cancelled by the client", http.StatusRequestTimeout)
+			writeError(ctx, w, "Request cancelled by the client", http.StatusRequestTimeout)
 		}
 		return
 	default:
 		userID, ok := r.Context().Value(middlewares.UserIDKey).(string)
 		if !ok {
-			http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+			writeError(ctx, w, "User ID not found in context", http.StatusInternalServerError)
 			return
 		}
 		var reqBatch models.ReqBatch
 		err := json.NewDecoder(r.Body).Decode(&reqBatch)
-		var FlagToFile bool
-
-		if config.Conf.File != "" {
-			FlagToFile = true
-		} else {
-			FlagToFile = false
-		}
-
 		if err != nil {
-			http.Error(w, "Error reading or unmarshaling the request body", http.StatusBadRequest)
+			writeError(ctx, w, "Error reading or unmarshaling the request body", http.StatusBadRequest)
 			return
 		}
 
 		if len(reqBatch) == 0 {
-			http.Error(w, "Error: empty request body", http.StatusBadRequest)
+			writeError(ctx, w, "Error: empty request body", http.StatusBadRequest)
 			return
 		}
 
-		db := h.repo.(*storage.PostgresStorage).GetDB()
-		tx, err := db.Begin()
-		if err != nil {
-			http.Error(w, "Error starting the transaction", http.StatusInternalServerError)
-			return
-		}
-		defer tx.Rollback()
-
-		var resp models.RespBatch
-		for _, req := range reqBatch {
-			shortURL := GenerateShortURL(req.OriginalURL)
-			err = h.repo.Save(shortURL, req.OriginalURL, userID)
+		now := time.Now()
+		inputs := make([]models.LinkInput, len(reqBatch))
+		seenAliases := make(map[models.LinkKey]bool)
+		for i, req := range reqBatch {
+			expiresAt, err := parseExpiry(req.ExpiresAt, req.TTL, now)
 			if err != nil {
-				http.Error(w, "Error saving the link to the repository.", http.StatusInternalServerError)
+				writeError(ctx, w, fmt.Sprintf("%s: %v", req.ID, err), http.StatusBadRequest)
 				return
 			}
-
-			if FlagToFile {
-				ToFile := struct {
-					UUID        int    `json:"uuid"`
-					ShortURL    string `json:"short_url"`
-					OriginalURL string `json:"original_url"`
-				}{
-					UUID:        h.repo.GetUUID(),
-					ShortURL:    "http://localhost:8080/" + shortURL,
-					OriginalURL: req.OriginalURL,
-				}
-
-				if jsonToFile, err := json.Marshal(ToFile); err == nil {
-					saveToFile(config.Conf.File, jsonToFile)
-				}
+			domain, err := h.linkDomain(req.Domain)
+			if err != nil {
+				writeError(ctx, w, fmt.Sprintf("%s: %v", req.ID, err), http.StatusBadRequest)
+				return
 			}
-
-			BatchResp := models.MiniBatchResp{
-				ID:       req.ID,
-				ShortURL: "http://localhost:8080/" + shortURL,
+			inputs[i] = models.LinkInput{
+				Domain:      domain,
+				ShortURL:    req.Alias,
+				OriginalURL: req.OriginalURL,
+				Owner:       userID,
+				ExpiresAt:   expiresAt,
+			}
+			if req.Alias == "" {
+				continue
+			}
+			if err := validateAlias(req.Alias); err != nil {
+				writeError(ctx, w, fmt.Sprintf("%s: %v", req.ID, err), http.StatusBadRequest)
+				return
+			}
+			alias := models.LinkKey{Domain: domain, ShortURL: req.Alias}
+			if seenAliases[alias] {
+				writeError(ctx, w, fmt.Sprintf("%s: alias %q is used twice", req.ID, req.Alias), http.StatusBadRequest)
+				return
 			}
-			resp = append(resp, BatchResp)
+			seenAliases[alias] = true
 		}
 
-		if err := tx.Commit(); err != nil {
-			http.Error(w, "Error committing the transaction", http.StatusInternalServerError)
+		if !h.checkQuota(ctx, w, userID, inputs) {
+			return
+		}
+		results, err := h.shortenBatch(ctx, inputs)
+		var taken *aliasTakenError
+		if errors.As(err, &taken) {
+			conflictResp := models.MiniBatchResp{
+				ID:       reqBatch[taken.index].ID,
+				ShortURL: h.urls.BuildFor(taken.key.Domain, taken.key.ShortURL),
+				Conflict: true,
+			}
+			if taken.link.Owner == userID {
+				conflictResp.OriginalURL = taken.link.OriginalURL
+			}
+			response, err := json.Marshal(models.RespBatch{conflictResp})
+			if err != nil {
+				writeError(ctx, w, "Error marshaling the response", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			w.Write(response)
 			return
 		}
+		if err != nil {
+			writeStorageError(ctx, w, err)
+			return
+		}
+
+		created := 0
+		resp := make(models.RespBatch, 0, len(results))
+		for i, result := range results {
+			if !result.Existed {
+				created++
+			}
+			resp = append(resp, models.MiniBatchResp{
+				ID:       reqBatch[i].ID,
+				ShortURL: h.urls.BuildFor(result.Link.Domain, result.Link.ShortURL),
+				Conflict: result.Existed,
+			})
+		}
+
+		h.metrics.LinksCreated(created)
 
 		response, err := json.Marshal(resp)
 		if err != nil {
-			http.Error(w, "Error marshaling the response", http.StatusInternalServerError)
+			writeError(ctx, w, "Error marshaling the response", http.StatusInternalServerError)
 			return
 		}
 
@@ -442,24 +621,42 @@ func (h *Handler) GetUserURLs(ctx context.Context, w http.ResponseWriter, r *htt
 	select {
 	case <-ctx.Done():
 		if ctx.Err() == context.DeadlineExceeded {
-			http.Error(w, "Request timed out", http.StatusGatewayTimeout)
+			writeError(ctx, w, "Request timed out", http.StatusGatewayTimeout)
 		} else {
-			http.Error(w, "Request cancelled by the client", http.StatusRequestTimeout)
+			writeError(ctx, w, "Request cancelled by the client", http.StatusRequestTimeout)
 		}
 		return
 	default:
 
 		userID, ok := r.Context().Value(middlewares.UserIDKey).(string)
 		if !ok {
-			http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+			writeError(ctx, w, "User ID not found in context", http.StatusInternalServerError)
+			return
+		}
+
+		filter, err := h.queryFilter(r)
+		if err != nil {
+			writeError(ctx, w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		urls, err := h.repo.(*storage.PostgresStorage).FindAllByOwner(userID)
+		links, err := h.repo.FindAllByOwner(ctx, userID, filter)
 		if err != nil {
-			http.Error(w, "Error retrieving URLs from storage", http.StatusInternalServerError)
+			writeStorageError(ctx, w, err)
 			return
 		}
+
+		now := time.Now()
+		var urls []models.ResponseToOwner
+		for _, link := range links {
+			if link.IsDeleted() || link.IsExpired(now) {
+				continue
+			}
+			urls = append(urls, models.ResponseToOwner{
+				ShortURL:    h.urls.BuildFor(link.Domain, link.ShortURL),
+				OriginalURL: link.OriginalURL,
+			})
+		}
 		if len(urls) == 0 {
 			w.WriteHeader(http.StatusNoContent)
 			return
@@ -467,7 +664,8 @@ func (h *Handler) GetUserURLs(ctx context.Context, w http.ResponseWriter, r *htt
 
 		resp, err := json.Marshal(urls)
 		if err != nil {
-			http.Error(w, "Error with marshal", http.StatusBadRequest)
+			writeError(ctx, w, "Error with marshal", http.StatusBadRequest)
+			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -480,34 +678,46 @@ func (h *Handler) DelUserUrls(ctx context.Context, w http.ResponseWriter, r *htt
 	select {
 	case <-ctx.Done():
 		if ctx.Err() == context.DeadlineExceeded {
-			http.Error(w, "Request timed out", http.StatusGatewayTimeout)
+			writeError(ctx, w, "Request timed out", http.StatusGatewayTimeout)
 		} else {
-			http.Error(w, "Request cancelled by the client", http.StatusRequestTimeout)
+			writeError(ctx, w, "Request cancelled by the client", http.StatusRequestTimeout)
 		}
 		return
 	default:
-		var wg sync.WaitGroup
 		var req models.UserDelUrls
 		userID, ok := r.Context().Value(middlewares.UserIDKey).(string)
 		if !ok {
-			http.Error(w, "User ID not found in context", http.StatusInternalServerError)
+			writeError(ctx, w, "User ID not found in context", http.StatusInternalServerError)
 			return
 		}
 		err := json.NewDecoder(r.Body).Decode(&req)
 		if err != nil {
-			http.Error(w, "Error reading or unmarshaling the request body", http.StatusBadRequest)
+			writeError(ctx, w, "Error reading or unmarshaling the request body", http.StatusBadRequest)
 			return
 		}
 
 		if len(req) == 0 {
-			http.Error(w, "Error: empty request body", http.StatusBadRequest)
+			writeError(ctx, w, "Error: empty request body", http.StatusBadRequest)
 			return
 		}
-		for _, url := range req {
-			wg.Add(1)
-			go h.repo.(*storage.PostgresStorage).DeleterURL(url, userID, &wg)
+		domain, err := h.queryDomain(r)
+		if err != nil {
+			writeError(ctx, w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		keys := make([]models.LinkKey, len(req))
+		for i, shortURL := range req {
+			keys[i] = models.LinkKey{Domain: domain, ShortURL: shortURL}
+		}
+		if h.deleter != nil {
+			err = h.deleter.Enqueue(ctx, userID, keys)
+		} else {
+			err = h.repo.DeleteBatch(ctx, userID, keys)
+		}
+		if err != nil {
+			writeStorageError(ctx, w, err)
+			return
 		}
-		wg.Wait()
 		w.WriteHeader(http.StatusAccepted)
 	}
 
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	middlewares "github.com/Dnlbb/link-shortener/internal/Middlewares"
+	"github.com/Dnlbb/link-shortener/internal/models"
+	"github.com/Dnlbb/link-shortener/internal/quota"
+	"github.com/Dnlbb/link-shortener/internal/requestid"
+)
+
+// Quotas limits how many links an owner keeps and creates at once.
+type Quotas interface {
+	Check(ctx context.Context, owner string, links int) error
+	Usage(ctx context.Context, owner string) (models.QuotaUsage, error)
+}
+
+// WithQuotas enforces the quotas on link creation, without them links are
+// unlimited.
+func WithQuotas(q Quotas) Option {
+	return func(h *Handler) {
+		h.quotas = q
+	}
+}
+
+// checkQuota reports whether the owner may create the links of the inputs.
+// When not, the response is written: 403 for a batch larger than the tier
+// allows, 429 once the active links are used up. Inputs whose original URL is
+// already shortened create no link and are not charged, they are only looked
+// up when the whole request does not fit.
+func (h *Handler) checkQuota(ctx context.Context, w http.ResponseWriter, owner string, inputs []models.LinkInput) bool {
+	if h.quotas == nil {
+		return true
+	}
+	err := h.quotas.Check(ctx, owner, len(inputs))
+	var exceeded *quota.ExceededError
+	if errors.As(err, &exceeded) {
+		var links int
+		if links, err = h.repo.CountNewLinks(ctx, inputs); err == nil {
+			err = h.quotas.Check(ctx, owner, links)
+		}
+	}
+	if errors.As(err, &exceeded) {
+		status := http.StatusTooManyRequests
+		if exceeded.Reason == quota.ReasonBatch {
+			status = http.StatusForbidden
+		}
+		writeJSON(ctx, w, status, models.ResponseQuotaExceeded{
+			Error:     exceeded.Reason,
+			Message:   exceeded.Error(),
+			Requested: exceeded.Requested,
+			Quota:     exceeded.Usage,
+			RequestID: requestid.FromContext(ctx),
+		})
+		return false
+	}
+	if err != nil {
+		writeStorageError(ctx, w, err)
+		return false
+	}
+	return true
+}
+
+// UserQuota reports the quota of the user and how much of it is used.
+func (h *Handler) UserQuota(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			writeError(ctx, w, "Request timed out", http.StatusGatewayTimeout)
+		} else {
+			writeError(ctx, w, "Request cancelled by the client", http.StatusRequestTimeout)
+		}
+		return
+	default:
+		if h.quotas == nil {
+			writeError(ctx, w, "Quotas are disabled", http.StatusNotImplemented)
+			return
+		}
+		userID, ok := r.Context().Value(middlewares.UserIDKey).(string)
+		if !ok {
+			writeError(ctx, w, "User ID not found in context", http.StatusInternalServerError)
+			return
+		}
+		usage, err := h.quotas.Usage(ctx, userID)
+		if err != nil {
+			writeStorageError(ctx, w, err)
+			return
+		}
+		writeJSON(ctx, w, http.StatusOK, usage)
+	}
+}
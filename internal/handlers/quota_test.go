@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	middleware "github.com/Dnlbb/link-shortener/internal/Middlewares"
+	"github.com/Dnlbb/link-shortener/internal/models"
+	"github.com/Dnlbb/link-shortener/internal/quota"
+	"github.com/Dnlbb/link-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotas(t *testing.T) {
+	repo := storage.NewInMemoryStorage()
+	h := NewHandler(repo, WithQuotas(quota.NewService(repo, quota.Config{
+		Default: quota.Limits{MaxLinks: 3, MaxBatch: 2},
+	})))
+
+	do := func(handle func(context.Context, http.ResponseWriter, *http.Request), body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+		w := httptest.NewRecorder()
+		handle(req.Context(), w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusCreated, do(h.Fpost, "https://a.example.com/").Code)
+	require.Equal(t, http.StatusCreated, do(h.ModifPost, `{"url": "https://b.example.com/"}`).Code)
+
+	w := do(h.Batch, `[{"correlation_id": "1", "original_url": "https://c.example.com/"}, {"correlation_id": "2", "original_url": "https://d.example.com/"}, {"correlation_id": "3", "original_url": "https://e.example.com/"}]`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	var refused models.ResponseQuotaExceeded
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &refused))
+	assert.Equal(t, quota.ReasonBatch, refused.Error)
+	assert.Equal(t, 3, refused.Requested)
+	assert.Equal(t, 2, refused.Quota.MaxBatch)
+
+	w = do(h.Batch, `[{"correlation_id": "1", "original_url": "https://c.example.com/"}, {"correlation_id": "2", "original_url": "https://d.example.com/"}]`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &refused))
+	assert.Equal(t, quota.ReasonLinks, refused.Error)
+	require.NotNil(t, refused.Quota.Remaining)
+	assert.Equal(t, 1, *refused.Quota.Remaining)
+	assert.Equal(t, 2, refused.Quota.ActiveLinks)
+
+	require.Equal(t, http.StatusCreated, do(h.Fpost, "https://c.example.com/").Code)
+	assert.Equal(t, http.StatusTooManyRequests, do(h.Fpost, "https://d.example.com/").Code)
+
+	w = do(h.UserQuota, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"tier": "default", "active_links": 3, "max_links": 3, "remaining": 0, "max_batch": 2}`, w.Body.String())
+
+	// Links that already exist are not created again and cost nothing.
+	assert.Equal(t, http.StatusConflict, do(h.Fpost, "https://a.example.com/").Code)
+	assert.Equal(t, http.StatusConflict, do(h.ModifPost, `{"url": "https://b.example.com/"}`).Code)
+	w = do(h.Batch, `[{"correlation_id": "1", "original_url": "https://a.example.com/"}, {"correlation_id": "2", "original_url": "https://b.example.com/"}, {"correlation_id": "3", "original_url": "https://c.example.com/"}]`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = do(h.Batch, `[{"correlation_id": "1", "original_url": "https://a.example.com/"}, {"correlation_id": "2", "original_url": "https://d.example.com/"}]`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &refused))
+	assert.Equal(t, 1, refused.Requested, "only the new link is charged")
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	middlewares "github.com/Dnlbb/link-shortener/internal/Middlewares"
+	"github.com/Dnlbb/link-shortener/internal/analytics"
+	"github.com/Dnlbb/link-shortener/internal/models"
+	"github.com/Dnlbb/link-shortener/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// ClickRecorder collects redirects for the link statistics.
+type ClickRecorder interface {
+	Record(event models.ClickEvent)
+}
+
+// WithClickRecorder makes Fget record a click for every redirect, client
+// addresses are hashed with ipSalt to count unique visitors.
+func WithClickRecorder(c ClickRecorder, ipSalt string) Option {
+	return func(h *Handler) {
+		h.clicks = c
+		h.ipSalt = ipSalt
+	}
+}
+
+func (h *Handler) recordClick(r *http.Request, key models.LinkKey) {
+	if h.clicks == nil {
+		return
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	h.clicks.Record(models.ClickEvent{
+		Domain:    key.Domain,
+		ShortURL:  key.ShortURL,
+		At:        time.Now(),
+		Referer:   r.Referer(),
+		UserAgent: r.UserAgent(),
+		IPHash:    analytics.HashIP(ip, h.ipSalt),
+	})
+}
+
+// LinkStats returns the click totals of one of the user's links with a
+// per-day histogram. Links of other users are reported as missing, links of
+// an extra domain are selected with the domain query parameter.
+func (h *Handler) LinkStats(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			writeError(ctx, w, "Request timed out", http.StatusGatewayTimeout)
+		} else {
+			writeError(ctx, w, "Request cancelled by the client", http.StatusRequestTimeout)
+		}
+		return
+	default:
+		userID, ok := r.Context().Value(middlewares.UserIDKey).(string)
+		if !ok {
+			writeError(ctx, w, "User ID not found in context", http.StatusInternalServerError)
+			return
+		}
+		shortURL := chi.URLParam(r, "short")
+		if shortURL == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		domain, err := h.queryDomain(r)
+		if err != nil {
+			writeError(ctx, w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		key := models.LinkKey{Domain: domain, ShortURL: shortURL}
+
+		link, err := h.repo.Find(ctx, key)
+		if err != nil && !errors.Is(err, storage.ErrDeleted) && !errors.Is(err, storage.ErrExpired) {
+			if errors.Is(err, storage.ErrNotFound) {
+				writeError(ctx, w, "The link was not found.", http.StatusNotFound)
+				return
+			}
+			writeStorageError(ctx, w, err)
+			return
+		}
+		if link.Owner != userID {
+			writeError(ctx, w, "The link was not found.", http.StatusNotFound)
+			return
+		}
+
+		counts, err := h.repo.ClickStats(ctx, key)
+		if err != nil {
+			writeStorageError(ctx, w, err)
+			return
+		}
+
+		respStruct := models.ResponseLinkStats{
+			ShortURL: h.urls.BuildFor(domain, shortURL),
+			Days:     make([]models.DailyClicks, 0, len(counts)),
+		}
+		var referers, userAgents map[string]int64
+		for _, c := range counts {
+			respStruct.Total += c.Clicks
+			day := c.Day.UTC().Format(time.DateOnly)
+			if n := len(respStruct.Days); n == 0 || respStruct.Days[n-1].Date != day {
+				respStruct.Days = append(respStruct.Days, models.DailyClicks{Date: day})
+				referers, userAgents = make(map[string]int64), make(map[string]int64)
+			}
+			d := &respStruct.Days[len(respStruct.Days)-1]
+			d.Clicks += c.Clicks
+			d.Visitors += c.Visitors
+			for name, clicks := range c.Referers {
+				referers[name] += clicks
+			}
+			for name, clicks := range c.UserAgents {
+				userAgents[name] += clicks
+			}
+			d.TopReferers, d.TopUserAgents = topSources(referers), topSources(userAgents)
+		}
+
+		resp, err := json.Marshal(respStruct)
+		if err != nil {
+			writeError(ctx, w, "Error marshaling the response", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(resp)
+	}
+}
+
+// topSourcesLimit is the number of referers and user agents reported a day.
+const topSourcesLimit = 5
+
+// topSources returns the sources with the most clicks.
+func topSources(counts map[string]int64) []models.SourceClicks {
+	sources := make([]models.SourceClicks, 0, len(counts))
+	for name, clicks := range counts {
+		sources = append(sources, models.SourceClicks{Name: name, Clicks: clicks})
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		if sources[i].Clicks != sources[j].Clicks {
+			return sources[i].Clicks > sources[j].Clicks
+		}
+		return sources[i].Name < sources[j].Name
+	})
+	if len(sources) > topSourcesLimit {
+		sources = sources[:topSourcesLimit]
+	}
+	return sources
+}
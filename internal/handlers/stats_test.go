@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Dnlbb/link-shortener/internal/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordedClicks struct {
+	mu     sync.Mutex
+	events []models.ClickEvent
+}
+
+func (c *recordedClicks) Record(event models.ClickEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, event)
+}
+
+func TestFgetRecordsClick(t *testing.T) {
+	mockRepo := NewMockRepository()
+	clicks := &recordedClicks{}
+	h := NewHandler(mockRepo, WithClickRecorder(clicks, "salt"))
+	shortURL := GenerateShortURL("https://practicum.yandex.ru/")
+	require.NoError(t, mockRepo.Save(context.Background(), models.Link{ShortURL: shortURL, OriginalURL: "https://practicum.yandex.ru/", Owner: "user1"}))
+
+	r := chi.NewRouter()
+	r.Get("/{shortURL}", h.FgetAdapter())
+
+	req := httptest.NewRequest(http.MethodGet, "/"+shortURL, nil)
+	req.RemoteAddr = "192.0.2.1:51234"
+	req.Header.Set("Referer", "https://news.example.com/")
+	req.Header.Set("User-Agent", "test-agent")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/missing0", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	require.Len(t, clicks.events, 1)
+	event := clicks.events[0]
+	assert.Equal(t, shortURL, event.ShortURL)
+	assert.Equal(t, "https://news.example.com/", event.Referer)
+	assert.Equal(t, "test-agent", event.UserAgent)
+	assert.NotEmpty(t, event.IPHash)
+	assert.NotContains(t, event.IPHash, "192.0.2.1")
+}
+
+func TestLinkStats(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := NewMockRepository()
+	h := NewHandler(mockRepo)
+	require.NoError(t, mockRepo.Save(ctx, models.Link{ShortURL: "abc12345", OriginalURL: "https://practicum.yandex.ru/", Owner: "user1"}))
+	require.NoError(t, mockRepo.RecordClicks(ctx, []models.ClickCount{
+		{
+			ShortURL:   "abc12345",
+			Day:        time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			Clicks:     3,
+			IPHashes:   []string{"a", "b"},
+			Referers:   map[string]int64{"news.example.com": 1, "a.example": 1, "b.example": 1, "c.example": 1, "d.example": 1, "e.example": 2},
+			UserAgents: map[string]int64{"test-agent": 3},
+		},
+		{ShortURL: "abc12345", Day: time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), Clicks: 2},
+	}))
+
+	r := chi.NewRouter()
+	r.Get("/api/user/urls/{short}/stats", func(w http.ResponseWriter, r *http.Request) {
+		h.LinkStats(r.Context(), w, r)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, userRequest(http.MethodGet, "/api/user/urls/abc12345/stats", nil, "user1"))
+	require.Equal(t, http.StatusOK, w.Code)
+	var stats models.ResponseLinkStats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, "http://localhost:8080/abc12345", stats.ShortURL)
+	assert.Equal(t, int64(5), stats.Total)
+	assert.Equal(t, []models.DailyClicks{
+		{
+			Date:     "2024-03-01",
+			Clicks:   3,
+			Visitors: 2,
+			TopReferers: []models.SourceClicks{
+				{Name: "e.example", Clicks: 2}, {Name: "a.example", Clicks: 1}, {Name: "b.example", Clicks: 1},
+				{Name: "c.example", Clicks: 1}, {Name: "d.example", Clicks: 1},
+			},
+			TopUserAgents: []models.SourceClicks{{Name: "test-agent", Clicks: 3}},
+		},
+		{Date: "2024-03-02", Clicks: 2},
+	}, stats.Days)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, userRequest(http.MethodGet, "/api/user/urls/abc12345/stats", nil, "user2"))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, userRequest(http.MethodGet, "/api/user/urls/missing0/stats", nil, "user1"))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
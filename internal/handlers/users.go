@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	middlewares "github.com/Dnlbb/link-shortener/internal/Middlewares"
+	"github.com/Dnlbb/link-shortener/internal/models"
+	"github.com/Dnlbb/link-shortener/internal/storage"
+	"github.com/Dnlbb/link-shortener/internal/users"
+	"github.com/go-chi/chi/v5"
+)
+
+// Users manages accounts and their API tokens.
+type Users interface {
+	Register(ctx context.Context, name string) (models.User, models.ResponseIssuedToken, error)
+	User(ctx context.Context, id string) (models.User, error)
+	IssueToken(ctx context.Context, userID, name string) (models.ResponseIssuedToken, error)
+	ListTokens(ctx context.Context, userID string) ([]models.APIToken, error)
+	RevokeToken(ctx context.Context, userID, tokenID string) error
+	Claim(ctx context.Context, userID, sessionID string) (int64, error)
+}
+
+// WithUsers enables accounts, without them the account routes answer 501.
+func WithUsers(u Users) Option {
+	return func(h *Handler) {
+		h.users = u
+	}
+}
+
+// accountID returns the user of a request authenticated with an API token.
+// Anonymous sessions get a 401, the response is written when ok is false.
+func (h *Handler) accountID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if h.users == nil {
+		writeError(r.Context(), w, "Accounts are disabled", http.StatusNotImplemented)
+		return "", false
+	}
+	userID, _ := r.Context().Value(middlewares.UserIDKey).(string)
+	if account, _ := r.Context().Value(middlewares.AccountKey).(bool); !account || userID == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(r.Context(), w, "An API token is required", http.StatusUnauthorized)
+		return "", false
+	}
+	return userID, true
+}
+
+// writeUsersError maps an error of the account routes to the HTTP response.
+func writeUsersError(ctx context.Context, w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, users.ErrInvalidName):
+		writeError(ctx, w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, storage.ErrTokenNotFound):
+		writeError(ctx, w, "The token was not found.", http.StatusNotFound)
+	case errors.Is(err, storage.ErrUserNotFound):
+		writeError(ctx, w, "The user was not found.", http.StatusNotFound)
+	default:
+		writeStorageError(ctx, w, err)
+	}
+}
+
+func writeJSON(ctx context.Context, w http.ResponseWriter, status int, v interface{}) {
+	resp, err := json.Marshal(v)
+	if err != nil {
+		writeError(ctx, w, "Error marshaling the response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(resp)
+}
+
+// CreateUser registers an account and returns it with its first API token.
+func (h *Handler) CreateUser(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			writeError(ctx, w, "Request timed out", http.StatusGatewayTimeout)
+		} else {
+			writeError(ctx, w, "Request cancelled by the client", http.StatusRequestTimeout)
+		}
+		return
+	default:
+		if h.users == nil {
+			writeError(ctx, w, "Accounts are disabled", http.StatusNotImplemented)
+			return
+		}
+		var req models.RequestCreateUser
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(ctx, w, "Error reading or unmarshaling the request body", http.StatusBadRequest)
+			return
+		}
+		user, token, err := h.users.Register(ctx, req.Name)
+		if err != nil {
+			writeUsersError(ctx, w, err)
+			return
+		}
+		writeJSON(ctx, w, http.StatusCreated, models.ResponseCreateUser{User: user, Token: token})
+	}
+}
+
+// CurrentUser returns the account of the API token.
+func (h *Handler) CurrentUser(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			writeError(ctx, w, "Request timed out", http.StatusGatewayTimeout)
+		} else {
+			writeError(ctx, w, "Request cancelled by the client", http.StatusRequestTimeout)
+		}
+		return
+	default:
+		userID, ok := h.accountID(w, r)
+		if !ok {
+			return
+		}
+		user, err := h.users.User(ctx, userID)
+		if err != nil {
+			writeUsersError(ctx, w, err)
+			return
+		}
+		writeJSON(ctx, w, http.StatusOK, user)
+	}
+}
+
+func (h *Handler) IssueToken(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			writeError(ctx, w, "Request timed out", http.StatusGatewayTimeout)
+		} else {
+			writeError(ctx, w, "Request cancelled by the client", http.StatusRequestTimeout)
+		}
+		return
+	default:
+		userID, ok := h.accountID(w, r)
+		if !ok {
+			return
+		}
+		var req models.RequestIssueToken
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(ctx, w, "Error reading or unmarshaling the request body", http.StatusBadRequest)
+			return
+		}
+		token, err := h.users.IssueToken(ctx, userID, req.Name)
+		if err != nil {
+			writeUsersError(ctx, w, err)
+			return
+		}
+		writeJSON(ctx, w, http.StatusCreated, token)
+	}
+}
+
+// ListTokens returns the tokens of the account without their secrets.
+func (h *Handler) ListTokens(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			writeError(ctx, w, "Request timed out", http.StatusGatewayTimeout)
+		} else {
+			writeError(ctx, w, "Request cancelled by the client", http.StatusRequestTimeout)
+		}
+		return
+	default:
+		userID, ok := h.accountID(w, r)
+		if !ok {
+			return
+		}
+		tokens, err := h.users.ListTokens(ctx, userID)
+		if err != nil {
+			writeUsersError(ctx, w, err)
+			return
+		}
+		if tokens == nil {
+			tokens = []models.APIToken{}
+		}
+		writeJSON(ctx, w, http.StatusOK, tokens)
+	}
+}
+
+func (h *Handler) RevokeToken(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			writeError(ctx, w, "Request timed out", http.StatusGatewayTimeout)
+		} else {
+			writeError(ctx, w, "Request cancelled by the client", http.StatusRequestTimeout)
+		}
+		return
+	default:
+		userID, ok := h.accountID(w, r)
+		if !ok {
+			return
+		}
+		if err := h.users.RevokeToken(ctx, userID, chi.URLParam(r, "id")); err != nil {
+			writeUsersError(ctx, w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ClaimLinks moves the links of the session cookie sent along with the API
+// token to the account.
+func (h *Handler) ClaimLinks(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			writeError(ctx, w, "Request timed out", http.StatusGatewayTimeout)
+		} else {
+			writeError(ctx, w, "Request cancelled by the client", http.StatusRequestTimeout)
+		}
+		return
+	default:
+		userID, ok := h.accountID(w, r)
+		if !ok {
+			return
+		}
+		sessionID, _ := r.Context().Value(middlewares.SessionIDKey).(string)
+		if sessionID == "" {
+			writeError(ctx, w, "A valid session cookie is required", http.StatusBadRequest)
+			return
+		}
+		claimed, err := h.users.Claim(ctx, userID, sessionID)
+		if err != nil {
+			writeUsersError(ctx, w, err)
+			return
+		}
+		writeJSON(ctx, w, http.StatusOK, models.ResponseClaim{Claimed: claimed})
+	}
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	middleware "github.com/Dnlbb/link-shortener/internal/Middlewares"
+	"github.com/Dnlbb/link-shortener/internal/config"
+	"github.com/Dnlbb/link-shortener/internal/models"
+	"github.com/Dnlbb/link-shortener/internal/storage"
+	"github.com/Dnlbb/link-shortener/internal/users"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccounts(t *testing.T) {
+	repo := storage.NewInMemoryStorage()
+	accounts := users.NewService(repo)
+	h := NewHandler(repo, WithUsers(accounts))
+	auth := middleware.NewSessionAuth(config.AuthConfig{Key: "test-secret-key", CookieTTL: time.Hour}, 30*time.Second).WithTokens(accounts)
+
+	r := chi.NewRouter()
+	r.Use(auth.Middleware)
+	r.Post("/api/shorten", func(w http.ResponseWriter, r *http.Request) { h.ModifPost(r.Context(), w, r) })
+	r.Get("/api/user/urls", func(w http.ResponseWriter, r *http.Request) { h.GetUserURLs(r.Context(), w, r) })
+	r.Post("/api/users", func(w http.ResponseWriter, r *http.Request) { h.CreateUser(r.Context(), w, r) })
+	r.Get("/api/user", func(w http.ResponseWriter, r *http.Request) { h.CurrentUser(r.Context(), w, r) })
+	r.Get("/api/user/tokens", func(w http.ResponseWriter, r *http.Request) { h.ListTokens(r.Context(), w, r) })
+	r.Post("/api/user/tokens", func(w http.ResponseWriter, r *http.Request) { h.IssueToken(r.Context(), w, r) })
+	r.Delete("/api/user/tokens/{id}", func(w http.ResponseWriter, r *http.Request) { h.RevokeToken(r.Context(), w, r) })
+	r.Post("/api/user/claim", func(w http.ResponseWriter, r *http.Request) { h.ClaimLinks(r.Context(), w, r) })
+
+	do := func(method, path, body, token string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/api/shorten", `{"url": "https://practicum.yandex.ru/"}`, "")
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Len(t, w.Result().Cookies(), 1)
+	session := w.Result().Cookies()[0]
+
+	w = do(http.MethodPost, "/api/users", `{"name": "ci"}`, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "signing up needs a session")
+	w = do(http.MethodPost, "/api/users", `{"name": "ci"}`, "", session)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created models.ResponseCreateUser
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	token := created.Token.Token
+	require.NotEmpty(t, token)
+
+	w = do(http.MethodGet, "/api/user", "", token)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), created.User.ID)
+	assert.Empty(t, w.Result().Cookies(), "token requests get no session cookie")
+
+	w = do(http.MethodGet, "/api/user/urls", "", token)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = do(http.MethodPost, "/api/user/claim", "", token)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "claiming needs the session cookie")
+	w = do(http.MethodPost, "/api/user/claim", "", token, session)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"claimed": 1}`, w.Body.String())
+
+	w = do(http.MethodGet, "/api/user/urls", "", token)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "https://practicum.yandex.ru/")
+
+	w = do(http.MethodPost, "/api/user/tokens", `{"name": "deploy"}`, token)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var deploy models.ResponseIssuedToken
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &deploy))
+
+	w = do(http.MethodGet, "/api/user/tokens", "", deploy.Token)
+	require.Equal(t, http.StatusOK, w.Code)
+	var tokens []map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tokens))
+	require.Len(t, tokens, 2)
+	assert.NotContains(t, w.Body.String(), token, "listed tokens carry no secret")
+	assert.NotContains(t, tokens[0], "token")
+
+	w = do(http.MethodDelete, "/api/user/tokens/"+created.Token.ID, "", deploy.Token)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	w = do(http.MethodDelete, "/api/user/tokens/missing", "", deploy.Token)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	tests := []struct {
+		name    string
+		path    string
+		token   string
+		cookies []*http.Cookie
+	}{
+		{name: "revoked token", path: "/api/user/urls", token: token},
+		{name: "unknown token", path: "/api/user/urls", token: "lsk_unknown"},
+		{name: "bad token with a valid cookie", path: "/api/user/urls", token: "lsk_unknown", cookies: []*http.Cookie{session}},
+		{name: "session cookie on an account route", path: "/api/user/tokens", cookies: []*http.Cookie{session}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(http.MethodGet, tt.path, "", tt.token, tt.cookies...)
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+		})
+	}
+}
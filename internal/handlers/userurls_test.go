@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	middleware "github.com/Dnlbb/link-shortener/internal/Middlewares"
+	"github.com/Dnlbb/link-shortener/internal/config"
+	"github.com/Dnlbb/link-shortener/internal/models"
+	"github.com/Dnlbb/link-shortener/internal/storage"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testAuth = middleware.NewSessionAuth(config.AuthConfig{Key: "test-secret-key", CookieTTL: time.Hour}, 30*time.Second)
+
+func userRequest(method, path string, body []byte, userID string) *http.Request {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, userID)
+	return req.WithContext(ctx)
+}
+
+// testBackends returns every storage backend, Postgres only when
+// TEST_DATABASE_DSN points to a database the test may migrate.
+func testBackends(t *testing.T) map[string]storage.Repository {
+	t.Helper()
+	file, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "short-url-db.json"))
+	require.NoError(t, err)
+	t.Cleanup(func() { file.Close() })
+	backends := map[string]storage.Repository{
+		"memory": storage.NewInMemoryStorage(),
+		"file":   file,
+	}
+	if dsn := os.Getenv("TEST_DATABASE_DSN"); dsn != "" {
+		db, err := sql.Open("pgx", dsn)
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		postgres := storage.NewPostgresStorage(db)
+		require.NoError(t, postgres.CreateTable(context.Background()))
+		backends["postgres"] = postgres
+	}
+	return backends
+}
+
+func TestBatchRepeatedExpiringURL(t *testing.T) {
+	for name, repo := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			h := NewHandler(repo)
+			original := fmt.Sprintf("https://example.com/campaign/%d", time.Now().UnixNano())
+			batch, err := json.Marshal(models.ReqBatch{
+				{ID: "1", OriginalURL: original, TTL: "1h"},
+				{ID: "2", OriginalURL: original, TTL: "2h"},
+				{ID: "3", OriginalURL: original},
+				{ID: "4", OriginalURL: original},
+			})
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			h.Batch(context.Background(), w, userRequest(http.MethodPost, "/api/shorten/batch", batch, "user1"))
+			require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+			var resp models.RespBatch
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			require.Len(t, resp, 4)
+			assert.NotEqual(t, resp[0].ShortURL, resp[1].ShortURL, "expiring repeats get links of their own")
+			assert.NotContains(t, []string{resp[0].ShortURL, resp[1].ShortURL}, resp[2].ShortURL)
+			assert.Equal(t, resp[2].ShortURL, resp[3].ShortURL, "permanent repeats share one link")
+		})
+	}
+}
+
+func TestBatchAndUserURLs(t *testing.T) {
+	mockRepo := NewMockRepository()
+	h := NewHandler(mockRepo)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	batch, err := json.Marshal(models.ReqBatch{
+		{ID: "1", OriginalURL: "https://practicum.yandex.ru/"},
+		{ID: "2", OriginalURL: "https://google.com/"},
+	})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	h.Batch(ctx, w, userRequest(http.MethodPost, "/api/shorten/batch", batch, "user1"))
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var resp models.RespBatch
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp, 2)
+	assert.Equal(t, "1", resp[0].ID)
+	assert.Equal(t, "http://localhost:8080/"+GenerateShortURL("https://practicum.yandex.ru/"), resp[0].ShortURL)
+	assert.False(t, resp[0].Conflict)
+
+	batch, err = json.Marshal(models.ReqBatch{
+		{ID: "3", OriginalURL: "https://practicum.yandex.ru/"},
+		{ID: "4", OriginalURL: "https://example.com/"},
+	})
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	h.Batch(ctx, w, userRequest(http.MethodPost, "/api/shorten/batch", batch, "user3"))
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp, 2)
+	assert.Equal(t, "3", resp[0].ID)
+	assert.True(t, resp[0].Conflict)
+	assert.False(t, resp[1].Conflict)
+
+	w = httptest.NewRecorder()
+	h.GetUserURLs(ctx, w, userRequest(http.MethodGet, "/api/user/urls", nil, "user1"))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var urls []models.ResponseToOwner
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &urls))
+	assert.Len(t, urls, 2)
+
+	w = httptest.NewRecorder()
+	h.GetUserURLs(ctx, w, userRequest(http.MethodGet, "/api/user/urls", nil, "user2"))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	del, err := json.Marshal(models.UserDelUrls{GenerateShortURL("https://google.com/")})
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	h.DelUserUrls(ctx, w, userRequest(http.MethodDelete, "/api/user/urls", del, "user2"))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	_, err = mockRepo.Find(ctx, models.LinkKey{ShortURL: GenerateShortURL("https://google.com/")})
+	assert.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	h.DelUserUrls(ctx, w, userRequest(http.MethodDelete, "/api/user/urls", del, "user1"))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	w = httptest.NewRecorder()
+	h.GetUserURLs(ctx, w, userRequest(http.MethodGet, "/api/user/urls", nil, "user1"))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &urls))
+	require.Len(t, urls, 1)
+	assert.Equal(t, "https://practicum.yandex.ru/", urls[0].OriginalURL)
+}
+
+func TestBatchAlias(t *testing.T) {
+	mockRepo := NewMockRepository()
+	h := NewHandler(mockRepo)
+	ctx := context.Background()
+
+	batch, err := json.Marshal(models.ReqBatch{
+		{ID: "1", OriginalURL: "https://shop.example.com/sale", Alias: "spring-sale"},
+		{ID: "2", OriginalURL: "https://shop.example.com/"},
+	})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	h.Batch(ctx, w, userRequest(http.MethodPost, "/api/shorten/batch", batch, "marketing"))
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var resp models.RespBatch
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "http://localhost:8080/spring-sale", resp[0].ShortURL)
+
+	batch, err = json.Marshal(models.ReqBatch{
+		{ID: "3", OriginalURL: "https://shop.example.com/autumn"},
+		{ID: "4", OriginalURL: "https://shop.example.com/other", Alias: "spring-sale"},
+	})
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	h.Batch(ctx, w, userRequest(http.MethodPost, "/api/shorten/batch", batch, "someone"))
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp, 1)
+	assert.Equal(t, "4", resp[0].ID)
+	assert.Empty(t, resp[0].OriginalURL)
+
+	_, err = mockRepo.Find(ctx, models.LinkKey{ShortURL: GenerateShortURL("https://shop.example.com/autumn")})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	batch, err = json.Marshal(models.ReqBatch{
+		{ID: "5", OriginalURL: "https://shop.example.com/a", Alias: "dup"},
+		{ID: "6", OriginalURL: "https://shop.example.com/b", Alias: "dup"},
+	})
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	h.Batch(ctx, w, userRequest(http.MethodPost, "/api/shorten/batch", batch, "someone"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,62 @@
+package logger
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/Dnlbb/link-shortener/internal/requestid"
+)
+
+type loggerKey struct{}
+
+// FieldRequestID holds the ID of the request a line was written for.
+const FieldRequestID = "request_id"
+
+type holder struct{ logger Logger }
+
+var defaultLogger atomic.Pointer[holder]
+
+func init() {
+	SetDefault(NewLogrusLogger(logrus.StandardLogger(), Redaction{URLs: true, UserIDs: true}))
+}
+
+// SetDefault sets the logger of the code running outside of a request.
+func SetDefault(l Logger) {
+	defaultLogger.Store(&holder{logger: l})
+}
+
+// Default returns the logger of the code running outside of a request.
+func Default() Logger {
+	return defaultLogger.Load().logger
+}
+
+// WithLogger stores the logger in the context.
+func WithLogger(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the logger of the request, the default one outside of
+// a request.
+func FromContext(ctx context.Context) Logger {
+	if l, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		return l
+	}
+	return Default()
+}
+
+// Middleware gives every request a logger writing the request ID on every
+// line. It must run after requestid.Middleware.
+func Middleware(base Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			l := base
+			if id := requestid.FromContext(r.Context()); id != "" {
+				l = base.WithFields(map[string]interface{}{FieldRequestID: id})
+			}
+			next.ServeHTTP(w, r.WithContext(WithLogger(r.Context(), l)))
+		})
+	}
+}
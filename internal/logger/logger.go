@@ -6,25 +6,49 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// Field names shared by the log lines. The values of FieldURL and FieldUserID
+// are redacted as configured.
+const (
+	FieldURL    = "url"
+	FieldUserID = "user_id"
+	FieldError  = "error"
+)
+
 type Logger interface {
+	Debug(args ...interface{})
 	Info(args ...interface{})
+	Warn(args ...interface{})
+	Error(args ...interface{})
 	WithFields(fields map[string]interface{}) Logger
 }
 
 type LogrusLogger struct {
-	entry *logrus.Entry
+	entry     *logrus.Entry
+	redaction Redaction
+}
+
+func NewLogrusLogger(logger *logrus.Logger, redaction Redaction) Logger {
+	return &LogrusLogger{entry: logrus.NewEntry(logger), redaction: redaction}
 }
 
-func NewLogrusLogger(logger *logrus.Logger) Logger {
-	return &LogrusLogger{entry: logrus.NewEntry(logger)}
+func (l *LogrusLogger) Debug(args ...interface{}) {
+	l.entry.Debug(args...)
 }
 
 func (l *LogrusLogger) Info(args ...interface{}) {
 	l.entry.Info(args...)
 }
 
+func (l *LogrusLogger) Warn(args ...interface{}) {
+	l.entry.Warn(args...)
+}
+
+func (l *LogrusLogger) Error(args ...interface{}) {
+	l.entry.Error(args...)
+}
+
 func (l *LogrusLogger) WithFields(fields map[string]interface{}) Logger {
-	return &LogrusLogger{entry: l.entry.WithFields(logrus.Fields(fields))}
+	return &LogrusLogger{entry: l.entry.WithFields(l.redaction.fields(fields)), redaction: l.redaction}
 }
 
 type (
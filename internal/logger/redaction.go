@@ -0,0 +1,58 @@
+package logger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+)
+
+const redacted = "[redacted]"
+
+// Redaction hides the values of the FieldURL and FieldUserID fields. A URL
+// keeps its scheme and host, a user ID is replaced with a hash of it so the
+// lines of one user can still be told apart.
+type Redaction struct {
+	URLs    bool
+	UserIDs bool
+}
+
+func (r Redaction) fields(fields map[string]interface{}) map[string]interface{} {
+	_, hasURL := fields[FieldURL]
+	_, hasUser := fields[FieldUserID]
+	if !(hasURL && r.URLs) && !(hasUser && r.UserIDs) {
+		return fields
+	}
+	out := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		out[k] = v
+	}
+	if hasURL && r.URLs {
+		out[FieldURL] = RedactURL(fmt.Sprint(fields[FieldURL]))
+	}
+	if hasUser && r.UserIDs {
+		out[FieldUserID] = RedactUserID(fmt.Sprint(fields[FieldUserID]))
+	}
+	return out
+}
+
+// RedactURL drops everything of the URL after the host.
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return redacted
+	}
+	if u.User == nil && u.Path == "" && u.RawQuery == "" && u.Fragment == "" {
+		return raw
+	}
+	return u.Scheme + "://" + u.Host + "/" + redacted
+}
+
+// RedactUserID replaces the ID with a short hash of it.
+func RedactUserID(id string) string {
+	if id == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(id))
+	return "user-" + hex.EncodeToString(sum[:6])
+}
@@ -0,0 +1,32 @@
+// Package metrics collects the metrics of the service with the Prometheus
+// client and exposes them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultBuckets are the latency buckets in seconds.
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// NewRegistry returns a registry with the Go runtime and process metrics. The
+// app keeps a registry of its own rather than the global one, so several apps
+// can live in one process.
+func NewRegistry() *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return r
+}
+
+// Handler serves the metrics of r to Prometheus, the way promhttp.Handler
+// serves the global registry.
+func Handler(r *prometheus.Registry) http.Handler {
+	return promhttp.InstrumentMetricHandler(r, promhttp.HandlerFor(r, promhttp.HandlerOpts{}))
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Dnlbb/link-shortener/internal/storage"
+)
+
+func scrape(t *testing.T, r *prometheus.Registry) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Handler(r).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	return rec.Body.String()
+}
+
+func TestHandlerServesRuntimeMetrics(t *testing.T) {
+	r := NewRegistry()
+	NewLinks(r).LinksCreated(3)
+
+	out := scrape(t, r)
+	assert.Contains(t, out, "# TYPE shortener_links_created_total counter\nshortener_links_created_total 3\n")
+	assert.Contains(t, out, "go_goroutines ")
+	assert.Contains(t, out, "promhttp_metric_handler_requests_total")
+}
+
+func TestRegisterDB(t *testing.T) {
+	r := NewRegistry()
+	RegisterDB(r, sql.OpenDB(nopConnector{}))
+	assert.Contains(t, scrape(t, r), `go_sql_open_connections{db_name="shortener"} 0`)
+}
+
+type nopConnector struct{ driver.Connector }
+
+func TestHTTPMiddlewareLabelsRoutePatterns(t *testing.T) {
+	r := NewRegistry()
+	router := chi.NewRouter()
+	router.Use(NewHTTP(r).Middleware)
+	router.Get("/{short}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	})
+	router.Post("/api/shorten", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/abc", nil),
+		httptest.NewRequest(http.MethodGet, "/xyz", nil),
+		httptest.NewRequest(http.MethodPost, "/api/shorten", nil),
+		httptest.NewRequest(http.MethodGet, "/a/b/c", nil),
+	} {
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	out := scrape(t, r)
+	assert.Contains(t, out, `shortener_http_requests_total{method="GET",route="/{short}",status="307"} 2`)
+	assert.Contains(t, out, `shortener_http_requests_total{method="POST",route="/api/shorten",status="200"} 1`)
+	assert.Contains(t, out, `shortener_http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	assert.Contains(t, out, `shortener_http_request_duration_seconds_count{method="GET",route="/{short}",status="307"} 2`)
+}
+
+func TestStorageObserver(t *testing.T) {
+	r := NewRegistry()
+	s := NewStorage(r)
+	for _, err := range []error{nil, storage.ErrNotFound, errors.New("connection refused")} {
+		_, done := s.Observe(context.Background(), "find")
+		done(err)
+	}
+
+	out := scrape(t, r)
+	assert.Contains(t, out, `shortener_storage_operation_duration_seconds_count{operation="find"} 3`)
+	assert.Contains(t, out, `shortener_storage_operation_errors_total{operation="find"} 1`, "missing links are no failures")
+}
+
+func TestRegisterQueues(t *testing.T) {
+	r := NewRegistry()
+	RegisterQueues(r, map[string]func() int{
+		"deletions": func() int { return 2 },
+		"clicks":    func() int { return 5 },
+	})
+	assert.Contains(t, scrape(t, r), "shortener_queue_depth{queue=\"clicks\"} 5\nshortener_queue_depth{queue=\"deletions\"} 2\n")
+}
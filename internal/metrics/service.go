@@ -0,0 +1,196 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+
+	"github.com/Dnlbb/link-shortener/internal/storage"
+)
+
+// unmatchedRoute labels requests no route matched, so unknown paths do not
+// create series.
+const unmatchedRoute = "unmatched"
+
+// HTTP counts the requests and their latencies by method, chi route pattern
+// and status.
+type HTTP struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func NewHTTP(r prometheus.Registerer) *HTTP {
+	labels := []string{"method", "route", "status"}
+	m := &HTTP{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "shortener_http_requests_total",
+			Help: "HTTP requests by route and status.",
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "shortener_http_request_duration_seconds",
+			Help:    "Latency of HTTP requests by route and status.",
+			Buckets: DefaultBuckets,
+		}, labels),
+	}
+	r.MustRegister(m.requests, m.duration)
+	return m
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Middleware must run on the chi router, the route is known once the request
+// has been routed.
+func (m *HTTP) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		status := strconv.Itoa(sw.status)
+		m.requests.WithLabelValues(r.Method, route, status).Inc()
+		m.duration.WithLabelValues(r.Method, route, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Links counts the links created and the redirects served.
+type Links struct {
+	created   prometheus.Counter
+	redirects prometheus.Counter
+}
+
+func NewLinks(r prometheus.Registerer) *Links {
+	l := &Links{
+		created: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "shortener_links_created_total",
+			Help: "Short links created.",
+		}),
+		redirects: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "shortener_redirects_total",
+			Help: "Redirects served.",
+		}),
+	}
+	r.MustRegister(l.created, l.redirects)
+	return l
+}
+
+func (l *Links) LinksCreated(n int) {
+	l.created.Add(float64(n))
+}
+
+func (l *Links) Redirected() {
+	l.redirects.Inc()
+}
+
+// Storage times the calls of the repository.
+type Storage struct {
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+}
+
+func NewStorage(r prometheus.Registerer) *Storage {
+	s := &Storage{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "shortener_storage_operation_duration_seconds",
+			Help:    "Latency of storage operations.",
+			Buckets: DefaultBuckets,
+		}, []string{"operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "shortener_storage_operation_errors_total",
+			Help: "Storage operations that failed, missing or taken links are no failures.",
+		}, []string{"operation"}),
+	}
+	r.MustRegister(s.duration, s.errors)
+	return s
+}
+
+// Observe is the storage.Observer of the repository.
+func (s *Storage) Observe(ctx context.Context, op string) (context.Context, func(err error)) {
+	start := time.Now()
+	return ctx, func(err error) {
+		s.duration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+		if isFailure(err) {
+			s.errors.WithLabelValues(op).Inc()
+		}
+	}
+}
+
+func isFailure(err error) bool {
+	switch {
+	case err == nil,
+		errors.Is(err, storage.ErrNotFound),
+		errors.Is(err, storage.ErrDeleted),
+		errors.Is(err, storage.ErrExpired),
+		errors.Is(err, storage.ErrConflict),
+		errors.Is(err, storage.ErrCodeTaken):
+		return false
+	}
+	return true
+}
+
+// RegisterDB exposes the connection pool statistics of db.
+func RegisterDB(r prometheus.Registerer, db *sql.DB) {
+	r.MustRegister(collectors.NewDBStatsCollector(db, "shortener"))
+}
+
+// RegisterQueues exposes the depths of the background queues by name.
+func RegisterQueues(r prometheus.Registerer, queues map[string]func() int) {
+	for name, depth := range queues {
+		r.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "shortener_queue_depth",
+			Help:        "Items waiting in a background queue.",
+			ConstLabels: prometheus.Labels{"queue": name},
+		}, func() float64 { return float64(depth()) }))
+	}
+}
+
+// RegisterCache exposes the counters of the link cache.
+func RegisterCache(r prometheus.Registerer, cache interface{ Stats() storage.CacheStats }) {
+	counter := func(name, help string, value func(storage.CacheStats) int64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help}, func() float64 {
+			return float64(value(cache.Stats()))
+		})
+	}
+	r.MustRegister(
+		counter("shortener_cache_hits_total", "Link lookups served by the cache.",
+			func(s storage.CacheStats) int64 { return s.Hits }),
+		counter("shortener_cache_misses_total", "Link lookups that went to the storage.",
+			func(s storage.CacheStats) int64 { return s.Misses }),
+		counter("shortener_cache_evictions_total", "Cached links dropped for room.",
+			func(s storage.CacheStats) int64 { return s.Evictions }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "shortener_cache_entries",
+			Help: "Links in the cache.",
+		}, func() float64 { return float64(cache.Stats().Size) }),
+	)
+}
@@ -0,0 +1,250 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockID serializes migrations of several instances started at once.
+const lockID = 7261736
+
+// Migration is a pair of NNNN_name.up.sql and NNNN_name.down.sql files.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Load returns the embedded migrations ordered by version.
+func Load() ([]Migration, error) {
+	return parse(files, "sql")
+}
+
+func parse(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		version, name, direction, err := parseName(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		body, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// parseName splits NNNN_name.up.sql into its version, name and direction.
+func parseName(file string) (int64, string, string, error) {
+	base, ok := strings.CutSuffix(file, ".sql")
+	if !ok {
+		return 0, "", "", fmt.Errorf("migration %s: not an .sql file", file)
+	}
+	direction := path.Ext(base)
+	if direction != ".up" && direction != ".down" {
+		return 0, "", "", fmt.Errorf("migration %s: expected .up.sql or .down.sql", file)
+	}
+	base = strings.TrimSuffix(base, direction)
+	number, name, ok := strings.Cut(base, "_")
+	if !ok || name == "" {
+		return 0, "", "", fmt.Errorf("migration %s: expected NNNN_name", file)
+	}
+	version, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, "", "", fmt.Errorf("migration %s: invalid version %q", file, number)
+	}
+	return version, name, direction[1:], nil
+}
+
+// Migrator applies migrations to a Postgres database and records them in the
+// schema_migrations table. Every migration runs in its own transaction.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func New(db *sql.DB) (*Migrator, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);`
+	_, err := m.db.ExecContext(ctx, query)
+	return err
+}
+
+// Up applies all pending migrations and returns how many were applied.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return 0, err
+	}
+	applied := 0
+	for _, migration := range m.migrations {
+		ok, err := m.apply(ctx, migration)
+		if err != nil {
+			return applied, fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+		if ok {
+			applied++
+		}
+	}
+	return applied, nil
+}
+
+func (m *Migrator) apply(ctx context.Context, migration Migration) (bool, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, lockID); err != nil {
+		return false, err
+	}
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, migration.Version).Scan(&exists)
+	if err != nil || exists {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// Down reverts the last steps applied migrations and returns how many were
+// reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return 0, err
+	}
+	reverted := 0
+	for reverted < steps {
+		ok, err := m.revertLast(ctx)
+		if err != nil {
+			return reverted, err
+		}
+		if !ok {
+			break
+		}
+		reverted++
+	}
+	return reverted, nil
+}
+
+func (m *Migrator) revertLast(ctx context.Context) (bool, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, lockID); err != nil {
+		return false, err
+	}
+	var version int64
+	err = tx.QueryRowContext(ctx, `SELECT version FROM schema_migrations ORDER BY version DESC LIMIT 1`).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	migration, ok := m.find(version)
+	if !ok {
+		return false, fmt.Errorf("migration %d is applied but unknown to this build", version)
+	}
+	if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+		return false, fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, version); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (m *Migrator) find(version int64) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+// Version returns the latest applied version, 0 for an empty database.
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return 0, err
+	}
+	var version int64
+	err := m.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// Latest returns the version of the newest embedded migration.
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
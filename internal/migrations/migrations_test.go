@@ -0,0 +1,66 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadEmbedded(t *testing.T) {
+	migrations, err := Load()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for i, m := range migrations {
+		assert.Equal(t, int64(i+1), m.Version, m.Name)
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+	}
+	assert.Equal(t, "create_urls", migrations[0].Name)
+}
+
+func TestParse(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/0010_second.up.sql":   {Data: []byte("CREATE TABLE b ();")},
+		"m/0010_second.down.sql": {Data: []byte("DROP TABLE b;")},
+		"m/0002_first.up.sql":    {Data: []byte("CREATE TABLE a ();")},
+		"m/0002_first.down.sql":  {Data: []byte("DROP TABLE a;")},
+	}
+	migrations, err := parse(fsys, "m")
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	assert.Equal(t, Migration{Version: 2, Name: "first", Up: "CREATE TABLE a ();", Down: "DROP TABLE a;"}, migrations[0])
+	assert.Equal(t, int64(10), migrations[1].Version)
+
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{
+			name: "missing down",
+			fsys: fstest.MapFS{"m/0001_a.up.sql": {Data: []byte("SELECT 1;")}},
+		},
+		{
+			name: "bad direction",
+			fsys: fstest.MapFS{"m/0001_a.sideways.sql": {Data: []byte("SELECT 1;")}},
+		},
+		{
+			name: "bad version",
+			fsys: fstest.MapFS{"m/first_a.up.sql": {Data: []byte("SELECT 1;")}},
+		},
+		{
+			name: "two names for one version",
+			fsys: fstest.MapFS{
+				"m/0001_a.up.sql":   {Data: []byte("SELECT 1;")},
+				"m/0001_b.down.sql": {Data: []byte("SELECT 1;")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parse(tt.fsys, "m")
+			assert.Error(t, err)
+		})
+	}
+}
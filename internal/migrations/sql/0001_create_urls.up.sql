@@ -0,0 +1,10 @@
+-- IF NOT EXISTS keeps databases created by the old CreateTable working.
+CREATE TABLE IF NOT EXISTS urls (
+	id SERIAL PRIMARY KEY,
+	short_url VARCHAR(8) NOT NULL UNIQUE,
+	original_url TEXT NOT NULL,
+	owner VARCHAR(50) NOT NULL,
+	DeletedFlag BOOL NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_original_url ON urls (original_url);
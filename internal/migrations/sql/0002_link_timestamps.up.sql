@@ -0,0 +1,3 @@
+ALTER TABLE urls
+	ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
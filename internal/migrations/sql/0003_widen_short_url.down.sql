@@ -0,0 +1,2 @@
+-- Fails while aliases longer than 8 characters are stored.
+ALTER TABLE urls ALTER COLUMN short_url TYPE VARCHAR(8);
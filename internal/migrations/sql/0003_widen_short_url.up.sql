@@ -0,0 +1,2 @@
+-- Custom aliases are up to 64 characters long.
+ALTER TABLE urls ALTER COLUMN short_url TYPE VARCHAR(64);
@@ -0,0 +1,7 @@
+-- Fails while an original URL has more than one link.
+DROP INDEX IF EXISTS idx_original_url;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_original_url ON urls (original_url);
+
+DROP INDEX IF EXISTS idx_expires_at;
+
+ALTER TABLE urls DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,8 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_expires_at ON urls (expires_at) WHERE expires_at IS NOT NULL;
+
+-- Only links without an expiry hold their original URL, an expired link must
+-- not keep it and an expiring one must not be answered with a permanent link.
+DROP INDEX IF EXISTS idx_original_url;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_original_url ON urls (original_url) WHERE expires_at IS NULL;
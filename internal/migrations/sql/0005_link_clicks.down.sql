@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS link_sources;
+DROP TABLE IF EXISTS link_visitors;
+DROP TABLE IF EXISTS link_clicks;
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS link_clicks (
+	short_url VARCHAR(64) NOT NULL,
+	day DATE NOT NULL,
+	clicks BIGINT NOT NULL,
+	PRIMARY KEY (short_url, day)
+);
+
+-- The visitors of a link by day, ip_hash is a salted hash of the address.
+CREATE TABLE IF NOT EXISTS link_visitors (
+	short_url VARCHAR(64) NOT NULL,
+	day DATE NOT NULL,
+	ip_hash VARCHAR(64) NOT NULL,
+	PRIMARY KEY (short_url, day, ip_hash)
+);
+
+-- The clicks of a link by day and referring host or user agent.
+CREATE TABLE IF NOT EXISTS link_sources (
+	short_url VARCHAR(64) NOT NULL,
+	day DATE NOT NULL,
+	kind VARCHAR(16) NOT NULL,
+	name VARCHAR(512) NOT NULL,
+	clicks BIGINT NOT NULL,
+	PRIMARY KEY (short_url, day, kind, name)
+);
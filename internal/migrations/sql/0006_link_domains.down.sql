@@ -0,0 +1,18 @@
+-- Fails while a code or an original URL is used on more than one domain.
+ALTER TABLE link_sources DROP CONSTRAINT IF EXISTS link_sources_pkey;
+ALTER TABLE link_sources ADD PRIMARY KEY (short_url, day, kind, name);
+ALTER TABLE link_sources DROP COLUMN IF EXISTS domain;
+
+ALTER TABLE link_visitors DROP CONSTRAINT IF EXISTS link_visitors_pkey;
+ALTER TABLE link_visitors ADD PRIMARY KEY (short_url, day, ip_hash);
+ALTER TABLE link_visitors DROP COLUMN IF EXISTS domain;
+
+ALTER TABLE link_clicks DROP CONSTRAINT IF EXISTS link_clicks_pkey;
+ALTER TABLE link_clicks ADD PRIMARY KEY (short_url, day);
+ALTER TABLE link_clicks DROP COLUMN IF EXISTS domain;
+
+DROP INDEX IF EXISTS idx_domain_original_url;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_original_url ON urls (original_url) WHERE expires_at IS NULL;
+DROP INDEX IF EXISTS idx_domain_short_url;
+ALTER TABLE urls ADD CONSTRAINT urls_short_url_key UNIQUE (short_url);
+ALTER TABLE urls DROP COLUMN IF EXISTS domain;
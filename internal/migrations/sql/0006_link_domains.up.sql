@@ -0,0 +1,18 @@
+-- Codes and original URLs are unique per domain, '' is the base URL domain.
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS domain VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_short_url_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_domain_short_url ON urls (domain, short_url);
+DROP INDEX IF EXISTS idx_original_url;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_domain_original_url ON urls (domain, original_url) WHERE expires_at IS NULL;
+
+ALTER TABLE link_clicks ADD COLUMN IF NOT EXISTS domain VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE link_clicks DROP CONSTRAINT IF EXISTS link_clicks_pkey;
+ALTER TABLE link_clicks ADD PRIMARY KEY (domain, short_url, day);
+
+ALTER TABLE link_visitors ADD COLUMN IF NOT EXISTS domain VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE link_visitors DROP CONSTRAINT IF EXISTS link_visitors_pkey;
+ALTER TABLE link_visitors ADD PRIMARY KEY (domain, short_url, day, ip_hash);
+
+ALTER TABLE link_sources ADD COLUMN IF NOT EXISTS domain VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE link_sources DROP CONSTRAINT IF EXISTS link_sources_pkey;
+ALTER TABLE link_sources ADD PRIMARY KEY (domain, short_url, day, kind, name);
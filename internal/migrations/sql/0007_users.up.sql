@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS users (
+	id VARCHAR(64) PRIMARY KEY,
+	name TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Only the SHA-256 hash of a token is stored.
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id VARCHAR(64) PRIMARY KEY,
+	user_id VARCHAR(64) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	token_hash CHAR(64) NOT NULL UNIQUE,
+	prefix VARCHAR(16) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens (user_id);
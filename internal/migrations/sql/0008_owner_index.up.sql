@@ -0,0 +1,2 @@
+-- Serves the per-owner link listings and the quota counts.
+CREATE INDEX IF NOT EXISTS idx_urls_owner ON urls (owner);
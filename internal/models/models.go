@@ -1,11 +1,93 @@
 package models
 
+import "time"
+
+type LinkStatus string
+
+const (
+	LinkStatusActive  LinkStatus = "active"
+	LinkStatusDeleted LinkStatus = "deleted"
+)
+
+// LinkKey identifies a link. Codes are unique per domain, the empty domain is
+// the one of the base URL.
+type LinkKey struct {
+	Domain   string
+	ShortURL string
+}
+
+// LinkFilter narrows FindAllByOwner, no domains means all of them.
+type LinkFilter struct {
+	Domains []string
+}
+
+func (f LinkFilter) Match(link Link) bool {
+	if len(f.Domains) == 0 {
+		return true
+	}
+	for _, domain := range f.Domains {
+		if link.Domain == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// Link is a short link as stored in the repository.
+type Link struct {
+	// ID is the row ID of the link, a link saved with 0 gets the next free one.
+	ID          int64      `json:"-"`
+	Domain      string     `json:"domain,omitempty"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	Owner       string     `json:"owner"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Status      LinkStatus `json:"status"`
+}
+
+func (l Link) Key() LinkKey {
+	return LinkKey{Domain: l.Domain, ShortURL: l.ShortURL}
+}
+
+func (l Link) IsDeleted() bool {
+	return l.Status == LinkStatusDeleted
+}
+
+func (l Link) IsExpired(now time.Time) bool {
+	return l.ExpiresAt != nil && !now.Before(*l.ExpiresAt)
+}
+
+// LinkInput is a link to be created by the repository.
+type LinkInput struct {
+	// ID is the row ID reserved for the code, 0 lets the repository pick one.
+	ID          int64
+	Domain      string
+	ShortURL    string
+	OriginalURL string
+	Owner       string
+	ExpiresAt   *time.Time
+}
+
+// BatchResult is the outcome of saving one LinkInput. Existed is set when the
+// original URL had already been shortened before the batch.
+type BatchResult struct {
+	Link    Link
+	Existed bool
+}
+
 type RequestModifyPost struct {
-	Body string `json:"url"`
+	Body      string     `json:"url"`
+	Alias     string     `json:"alias,omitempty"`
+	Domain    string     `json:"domain,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	TTL       string     `json:"ttl,omitempty"`
 }
 
 type ResponseModifyPost struct {
-	Body string `json:"result"`
+	Body        string `json:"result"`
+	OriginalURL string `json:"original_url,omitempty"`
 }
 type ResponseModifyConflictPost string
 
@@ -20,15 +102,21 @@ type ResponseModifyGet struct {
 type ReqBatch []MiniBatchReq
 
 type MiniBatchReq struct {
-	ID          string `json:"correlation_id"`
-	OriginalURL string `json:"original_url"`
+	ID          string     `json:"correlation_id"`
+	OriginalURL string     `json:"original_url"`
+	Alias       string     `json:"alias,omitempty"`
+	Domain      string     `json:"domain,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	TTL         string     `json:"ttl,omitempty"`
 }
 
 type RespBatch []MiniBatchResp
 
 type MiniBatchResp struct {
-	ID       string `json:"correlation_id"`
-	ShortURL string `json:"short_url"`
+	ID          string `json:"correlation_id"`
+	ShortURL    string `json:"short_url"`
+	Conflict    bool   `json:"conflict,omitempty"`
+	OriginalURL string `json:"original_url,omitempty"`
 }
 
 type ResponseToOwner struct {
@@ -37,3 +125,116 @@ type ResponseToOwner struct {
 }
 
 type UserDelUrls []string
+
+// ClickEvent is a single redirect through a short link. IPHash is a salted
+// hash, the client address itself is never stored.
+type ClickEvent struct {
+	Domain    string
+	ShortURL  string
+	At        time.Time
+	Referer   string
+	UserAgent string
+	IPHash    string
+}
+
+// ClickCount is the number of clicks of a short link during one UTC day.
+type ClickCount struct {
+	Domain   string
+	ShortURL string
+	Day      time.Time
+	Clicks   int64
+	// IPHashes are the visitors of the clicks to record, ClickStats reports
+	// the number of distinct visitors of the day in Visitors.
+	IPHashes []string
+	Visitors int64
+	// Referers counts the clicks by referring host and UserAgents by user
+	// agent, clicks without either are left out.
+	Referers   map[string]int64
+	UserAgents map[string]int64
+}
+
+// SourceClicks is the number of clicks from one referring host or user agent.
+type SourceClicks struct {
+	Name   string `json:"name"`
+	Clicks int64  `json:"clicks"`
+}
+
+type DailyClicks struct {
+	Date          string         `json:"date"`
+	Clicks        int64          `json:"clicks"`
+	Visitors      int64          `json:"visitors"`
+	TopReferers   []SourceClicks `json:"top_referers,omitempty"`
+	TopUserAgents []SourceClicks `json:"top_user_agents,omitempty"`
+}
+
+type ResponseLinkStats struct {
+	ShortURL string        `json:"short_url"`
+	Total    int64         `json:"total"`
+	Days     []DailyClicks `json:"days"`
+}
+
+// User is an account. Links of an account are owned by its ID, the same way
+// anonymous links are owned by the ID in the session cookie.
+type User struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIToken is a bearer token of an account. Only the SHA-256 hash of the
+// token is stored, Prefix keeps its first characters to tell tokens apart.
+type APIToken struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"-"`
+	Name      string     `json:"name"`
+	Hash      string     `json:"-"`
+	Prefix    string     `json:"prefix"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+func (t APIToken) IsRevoked() bool {
+	return t.RevokedAt != nil
+}
+
+type RequestCreateUser struct {
+	Name string `json:"name"`
+}
+
+type RequestIssueToken struct {
+	Name string `json:"name"`
+}
+
+// ResponseIssuedToken carries the plain token, it is shown only once.
+type ResponseIssuedToken struct {
+	APIToken
+	Token string `json:"token"`
+}
+
+type ResponseCreateUser struct {
+	User  User                `json:"user"`
+	Token ResponseIssuedToken `json:"token"`
+}
+
+type ResponseClaim struct {
+	Claimed int64 `json:"claimed"`
+}
+
+// QuotaUsage reports the quota of an owner, zero limits are unlimited and
+// Remaining is left out for them.
+type QuotaUsage struct {
+	Tier        string `json:"tier"`
+	ActiveLinks int    `json:"active_links"`
+	MaxLinks    int    `json:"max_links"`
+	Remaining   *int   `json:"remaining,omitempty"`
+	MaxBatch    int    `json:"max_batch"`
+}
+
+// ResponseQuotaExceeded describes a request refused by the quota.
+type ResponseQuotaExceeded struct {
+	Error     string     `json:"error"`
+	Message   string     `json:"message"`
+	Requested int        `json:"requested"`
+	Quota     QuotaUsage `json:"quota"`
+	RequestID string     `json:"request_id,omitempty"`
+}
@@ -0,0 +1,114 @@
+package quota
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Dnlbb/link-shortener/internal/models"
+)
+
+// Reasons of an ExceededError.
+const (
+	ReasonLinks = "link_quota_exceeded"
+	ReasonBatch = "batch_too_large"
+)
+
+// DefaultTier names the quota of owners without a tier.
+const DefaultTier = "default"
+
+// Limits caps the links of one owner, zero means unlimited.
+type Limits struct {
+	MaxLinks int
+	MaxBatch int
+}
+
+// Config assigns the limits: Users maps owners to a tier of Tiers, everyone
+// else gets Default.
+type Config struct {
+	Default Limits
+	Tiers   map[string]Limits
+	Users   map[string]string
+}
+
+// Repository is the part of storage.Repository the quotas need.
+type Repository interface {
+	CountActiveByOwner(ctx context.Context, owner string, now time.Time) (int, error)
+}
+
+// ExceededError is returned by Check when the request would go over the
+// quota.
+type ExceededError struct {
+	Reason    string
+	Requested int
+	Usage     models.QuotaUsage
+}
+
+func (e *ExceededError) Error() string {
+	if e.Reason == ReasonBatch {
+		return fmt.Sprintf("a batch holds at most %d links, got %d", e.Usage.MaxBatch, e.Requested)
+	}
+	return fmt.Sprintf("%d of %d links are in use, %d more do not fit", e.Usage.ActiveLinks, e.Usage.MaxLinks, e.Requested)
+}
+
+// Service checks new links against the quota of their owner. Concurrent
+// requests of one owner are checked independently and may overshoot the
+// quota by the links they create together.
+type Service struct {
+	repo Repository
+	cfg  Config
+	now  func() time.Time
+}
+
+func NewService(repo Repository, cfg Config) *Service {
+	return &Service{repo: repo, cfg: cfg, now: time.Now}
+}
+
+// limits returns the tier of the owner and its limits.
+func (s *Service) limits(owner string) (string, Limits) {
+	if tier, ok := s.cfg.Users[owner]; ok {
+		if limits, ok := s.cfg.Tiers[tier]; ok {
+			return tier, limits
+		}
+	}
+	return DefaultTier, s.cfg.Default
+}
+
+// Usage returns the quota of the owner and how much of it is used.
+func (s *Service) Usage(ctx context.Context, owner string) (models.QuotaUsage, error) {
+	tier, limits := s.limits(owner)
+	active, err := s.repo.CountActiveByOwner(ctx, owner, s.now())
+	if err != nil {
+		return models.QuotaUsage{}, err
+	}
+	usage := models.QuotaUsage{
+		Tier:        tier,
+		ActiveLinks: active,
+		MaxLinks:    limits.MaxLinks,
+		MaxBatch:    limits.MaxBatch,
+	}
+	if limits.MaxLinks > 0 {
+		remaining := max(limits.MaxLinks-active, 0)
+		usage.Remaining = &remaining
+	}
+	return usage, nil
+}
+
+// Check returns an ExceededError when the owner may not create that many
+// links in one request. Owners without a link limit are not counted.
+func (s *Service) Check(ctx context.Context, owner string, links int) error {
+	if _, limits := s.limits(owner); limits.MaxLinks == 0 && (limits.MaxBatch == 0 || links <= limits.MaxBatch) {
+		return nil
+	}
+	usage, err := s.Usage(ctx, owner)
+	if err != nil {
+		return err
+	}
+	if usage.MaxBatch > 0 && links > usage.MaxBatch {
+		return &ExceededError{Reason: ReasonBatch, Requested: links, Usage: usage}
+	}
+	if usage.Remaining != nil && links > *usage.Remaining {
+		return &ExceededError{Reason: ReasonLinks, Requested: links, Usage: usage}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package quota
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Dnlbb/link-shortener/internal/models"
+	"github.com/Dnlbb/link-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewInMemoryStorage()
+	past := time.Now().Add(-time.Hour)
+	_, err := repo.SaveBatch(ctx, []models.LinkInput{
+		{ShortURL: "a", OriginalURL: "https://a.example.com/", Owner: "user1"},
+		{ShortURL: "b", OriginalURL: "https://b.example.com/", Owner: "user1"},
+		{ShortURL: "c", OriginalURL: "https://c.example.com/", Owner: "user1", ExpiresAt: &past},
+		{ShortURL: "d", OriginalURL: "https://d.example.com/", Owner: "pro1"},
+	})
+	require.NoError(t, err)
+
+	s := NewService(repo, Config{
+		Default: Limits{MaxLinks: 3, MaxBatch: 2},
+		Tiers:   map[string]Limits{"pro": {MaxBatch: 100}},
+		Users:   map[string]string{"pro1": "pro"},
+	})
+
+	usage, err := s.Usage(ctx, "user1")
+	require.NoError(t, err)
+	require.NotNil(t, usage.Remaining)
+	assert.Equal(t, models.QuotaUsage{Tier: DefaultTier, ActiveLinks: 2, MaxLinks: 3, Remaining: usage.Remaining, MaxBatch: 2}, usage)
+	assert.Equal(t, 1, *usage.Remaining, "expired links do not count")
+
+	usage, err = s.Usage(ctx, "pro1")
+	require.NoError(t, err)
+	assert.Equal(t, "pro", usage.Tier)
+	assert.Nil(t, usage.Remaining, "unlimited tiers have no remaining count")
+
+	require.NoError(t, s.Check(ctx, "user1", 1))
+	var exceeded *ExceededError
+	require.ErrorAs(t, s.Check(ctx, "user1", 2), &exceeded)
+	assert.Equal(t, ReasonLinks, exceeded.Reason)
+	assert.Equal(t, 2, exceeded.Requested)
+	require.ErrorAs(t, s.Check(ctx, "new-user", 3), &exceeded)
+	assert.Equal(t, ReasonBatch, exceeded.Reason)
+
+	assert.NoError(t, s.Check(ctx, "pro1", 100))
+	assert.ErrorAs(t, s.Check(ctx, "pro1", 101), &exceeded)
+}
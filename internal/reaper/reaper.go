@@ -0,0 +1,106 @@
+package reaper
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Dnlbb/link-shortener/internal/logger"
+)
+
+// Repository is the part of storage.Repository the reaper needs.
+type Repository interface {
+	PurgeExpired(ctx context.Context, before time.Time) (int64, error)
+}
+
+type Config struct {
+	Interval time.Duration
+	// Retention keeps expired links around for a while, so they keep
+	// answering 410 instead of being reported as missing right away.
+	Retention time.Duration
+	// Timeout bounds a single PurgeExpired call.
+	Timeout time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Interval:  time.Minute,
+		Retention: 24 * time.Hour,
+		Timeout:   30 * time.Second,
+	}
+}
+
+// Reaper periodically purges links that expired more than Retention ago.
+type Reaper struct {
+	repo Repository
+	cfg  Config
+
+	stop chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+func New(repo Repository, cfg Config) *Reaper {
+	def := DefaultConfig()
+	if cfg.Interval <= 0 {
+		cfg.Interval = def.Interval
+	}
+	if cfg.Retention < 0 {
+		cfg.Retention = 0
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = def.Timeout
+	}
+
+	r := &Reaper{
+		repo: repo,
+		cfg:  cfg,
+		stop: make(chan struct{}),
+	}
+	r.wg.Add(1)
+	go r.run()
+	return r
+}
+
+func (r *Reaper) run() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.Purge()
+		}
+	}
+}
+
+// Purge removes the links that expired before the retention window and
+// returns how many of them were removed.
+func (r *Reaper) Purge() int64 {
+	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.Timeout)
+	defer cancel()
+	purged, err := r.repo.PurgeExpired(ctx, time.Now().Add(-r.cfg.Retention))
+	if err != nil {
+		logger.FromContext(ctx).WithFields(map[string]interface{}{logger.FieldError: err}).Error("Error purging expired links")
+		return 0
+	}
+	return purged
+}
+
+// Close stops the reaper and waits for a running purge to finish.
+func (r *Reaper) Close(ctx context.Context) error {
+	r.once.Do(func() { close(r.stop) })
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,44 @@
+package reaper
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRepo struct {
+	mu      sync.Mutex
+	cutoffs []time.Time
+}
+
+func (f *fakeRepo) PurgeExpired(ctx context.Context, before time.Time) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cutoffs = append(f.cutoffs, before)
+	return 1, nil
+}
+
+func (f *fakeRepo) calls() []time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]time.Time(nil), f.cutoffs...)
+}
+
+func TestReaperPurgesPeriodically(t *testing.T) {
+	repo := &fakeRepo{}
+	r := New(repo, Config{Interval: 10 * time.Millisecond, Retention: time.Hour})
+
+	require.Eventually(t, func() bool { return len(repo.calls()) >= 2 }, time.Second, 5*time.Millisecond)
+	require.NoError(t, r.Close(context.Background()))
+
+	cutoff := repo.calls()[0]
+	assert.WithinDuration(t, time.Now().Add(-time.Hour), cutoff, time.Second)
+
+	stopped := len(repo.calls())
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, stopped, len(repo.calls()))
+}
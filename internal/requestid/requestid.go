@@ -0,0 +1,70 @@
+// Package requestid gives every request an ID that ties together its log
+// lines and its responses.
+package requestid
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Header carries the ID in requests and responses.
+const Header = "X-Request-ID"
+
+// maxLength bounds the IDs accepted from clients.
+const maxLength = 128
+
+type contextKey struct{}
+
+// WithID stores the ID in the context.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the ID of the request, empty outside of a request.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// valid accepts the IDs safe to echo and log: letters, digits and a few
+// separators.
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("-_.:", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware keeps the ID sent by the client or generates one, stores it in
+// the context and echoes it on the response.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !valid(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(WithID(r.Context(), id)))
+	})
+}
+
+// Error replies like http.Error with the ID of the request in ctx as the last
+// line of the body. Bodies that are data the client reads are not written
+// with it.
+func Error(ctx context.Context, w http.ResponseWriter, msg string, code int) {
+	if id := FromContext(ctx); id != "" {
+		msg += "\nRequest ID: " + id
+	}
+	http.Error(w, msg, code)
+}
@@ -0,0 +1,64 @@
+package requestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	var seen string
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = FromContext(r.Context())
+		switch r.URL.Path {
+		case "/fail":
+			Error(r.Context(), w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		case "/conflict":
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("http://localhost:8080/abc"))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("ok"))
+	}))
+
+	tests := []struct {
+		name     string
+		path     string
+		incoming string
+		keep     bool
+		body     string
+	}{
+		{"keeps the client ID", "/", "abc-123", true, "ok"},
+		{"generates a missing ID", "/", "", false, "ok"},
+		{"replaces an unsafe ID", "/", "abc\r\nSet-Cookie: x", false, "ok"},
+		{"replaces a long ID", "/", strings.Repeat("a", 129), false, "ok"},
+		{"annotates errors", "/fail", "abc-123", true, "Internal Server Error\nRequest ID: abc-123\n"},
+		{"leaves error bodies of data alone", "/conflict", "abc-123", true, "http://localhost:8080/abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.incoming != "" {
+				req.Header.Set(Header, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			id := rec.Header().Get(Header)
+			assert.Equal(t, seen, id, "the echoed ID is the one in the context")
+			if tt.keep {
+				assert.Equal(t, tt.incoming, id)
+			} else {
+				assert.NotEqual(t, tt.incoming, id)
+				assert.True(t, valid(id))
+			}
+			assert.Equal(t, tt.body, rec.Body.String())
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package shorturl
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrInvalid = errors.New("invalid short url")
+	// ErrForeignHost means the URL does not point to this service.
+	ErrForeignHost = errors.New("short url of another host")
+)
+
+// Builder renders short codes as links under the base URL and parses such
+// links back. Links on the alias hosts are accepted by Parse as well. Codes of
+// the extra domains are rendered at the root of the domain with the scheme of
+// the base URL, the empty domain stands for the base URL.
+type Builder struct {
+	base    string
+	scheme  string
+	path    string
+	hosts   map[string]bool
+	domains map[string]bool
+}
+
+// New returns a builder for baseURL, for example https://sho.rt or
+// https://example.com/s. aliasHosts are host[:port] values.
+func New(baseURL string, aliasHosts ...string) (*Builder, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("base url %q: expected http(s)://host[/path]", baseURL)
+	}
+	b := &Builder{
+		base:    strings.TrimSuffix(baseURL, "/"),
+		scheme:  u.Scheme,
+		path:    strings.TrimSuffix(u.Path, "/"),
+		hosts:   map[string]bool{strings.ToLower(u.Host): true},
+		domains: map[string]bool{},
+	}
+	for _, host := range aliasHosts {
+		if host = strings.TrimSpace(host); host != "" {
+			b.hosts[strings.ToLower(host)] = true
+		}
+	}
+	return b, nil
+}
+
+// MustNew is New for constant base URLs.
+func MustNew(baseURL string, aliasHosts ...string) *Builder {
+	b, err := New(baseURL, aliasHosts...)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// WithDomains returns a copy of the builder that also serves the domains,
+// given as host[:port].
+func (b *Builder) WithDomains(domains ...string) *Builder {
+	c := *b
+	c.domains = make(map[string]bool, len(b.domains)+len(domains))
+	for domain := range b.domains {
+		c.domains[domain] = true
+	}
+	for _, domain := range domains {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			c.domains[domain] = true
+		}
+	}
+	return &c
+}
+
+func (b *Builder) Base() string {
+	return b.base
+}
+
+// HasDomain reports whether links can be created on the domain.
+func (b *Builder) HasDomain(domain string) bool {
+	return domain == "" || b.domains[domain]
+}
+
+// Domain returns the domain served on the request host, hosts that are not
+// an extra domain map to the base URL.
+func (b *Builder) Domain(host string) string {
+	host = strings.ToLower(host)
+	if b.domains[host] {
+		return host
+	}
+	return ""
+}
+
+// Build returns the link of the short code under the base URL.
+func (b *Builder) Build(code string) string {
+	return b.base + "/" + url.PathEscape(code)
+}
+
+// BuildFor returns the link of the short code on the domain.
+func (b *Builder) BuildFor(domain, code string) string {
+	if domain == "" {
+		return b.Build(code)
+	}
+	return b.scheme + "://" + domain + "/" + url.PathEscape(code)
+}
+
+// Parse returns the domain and the short code of a link rendered by BuildFor.
+// Links on the alias hosts belong to the base URL. The scheme must be lower
+// case http or https.
+func (b *Builder) Parse(shortURL string) (domain, code string, err error) {
+	u, err := url.Parse(shortURL)
+	if err != nil || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", "", ErrInvalid
+	}
+	if !strings.HasPrefix(shortURL, "http://") && !strings.HasPrefix(shortURL, "https://") {
+		return "", "", ErrInvalid
+	}
+	host := strings.ToLower(u.Host)
+	prefix := b.path + "/"
+	switch {
+	case b.hosts[host]:
+	case b.domains[host]:
+		domain, prefix = host, "/"
+	default:
+		return "", "", ErrForeignHost
+	}
+	code, ok := strings.CutPrefix(u.Path, prefix)
+	if !ok || code == "" || strings.Contains(code, "/") {
+		return "", "", ErrInvalid
+	}
+	return domain, code, nil
+}
@@ -0,0 +1,74 @@
+package shorturl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuild(t *testing.T) {
+	assert.Equal(t, "https://sho.rt/abc12345", MustNew("https://sho.rt").Build("abc12345"))
+	assert.Equal(t, "https://example.com/s/abc12345", MustNew("https://example.com/s/").Build("abc12345"))
+
+	b := MustNew("https://sho.rt/s").WithDomains("Brand.example")
+	assert.Equal(t, "https://sho.rt/s/abc12345", b.BuildFor("", "abc12345"))
+	assert.Equal(t, "https://brand.example/abc12345", b.BuildFor("brand.example", "abc12345"))
+
+	_, err := New("sho.rt")
+	assert.Error(t, err)
+	_, err = New("ftp://sho.rt")
+	assert.Error(t, err)
+}
+
+func TestParse(t *testing.T) {
+	b := MustNew("https://sho.rt/s", "go.example.com", "127.0.0.1:8080").WithDomains("brand.example")
+
+	tests := []struct {
+		name     string
+		shortURL string
+		domain   string
+		code     string
+		err      error
+	}{
+		{"base url", "https://sho.rt/s/abc12345", "", "abc12345", nil},
+		{"plain http", "http://sho.rt/s/abc12345", "", "abc12345", nil},
+		{"host case", "https://SHO.RT/s/spring-sale", "", "spring-sale", nil},
+		{"alias host", "https://go.example.com/s/abc12345", "", "abc12345", nil},
+		{"alias host with port", "http://127.0.0.1:8080/s/abc12345", "", "abc12345", nil},
+		{"domain", "https://Brand.example/abc12345", "brand.example", "abc12345", nil},
+		{"domain with base path", "https://brand.example/s/abc12345", "", "", ErrInvalid},
+		{"foreign host", "https://evil.example.com/s/abc12345", "", "", ErrForeignHost},
+		{"missing prefix", "https://sho.rt/abc12345", "", "", ErrInvalid},
+		{"empty code", "https://sho.rt/s/", "", "", ErrInvalid},
+		{"nested path", "https://sho.rt/s/a/b", "", "", ErrInvalid},
+		{"query", "https://sho.rt/s/abc12345?x=1", "", "", ErrInvalid},
+		{"upper case scheme", "HTTPS://sho.rt/s/abc12345", "", "", ErrInvalid},
+		{"no scheme", "sho.rt/s/abc12345", "", "", ErrInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domain, code, err := b.Parse(tt.shortURL)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.domain, domain)
+			assert.Equal(t, tt.code, code)
+		})
+	}
+}
+
+func TestDomain(t *testing.T) {
+	b := MustNew("https://sho.rt").WithDomains("brand.example", "go.brand.example:8443")
+
+	assert.Equal(t, "brand.example", b.Domain("BRAND.example"))
+	assert.Equal(t, "go.brand.example:8443", b.Domain("go.brand.example:8443"))
+	assert.Equal(t, "", b.Domain("sho.rt"))
+	assert.Equal(t, "", b.Domain("unknown.example"))
+	assert.True(t, b.HasDomain(""))
+	assert.True(t, b.HasDomain("brand.example"))
+	assert.False(t, b.HasDomain("unknown.example"))
+	assert.False(t, MustNew("https://sho.rt").HasDomain("brand.example"), "WithDomains returns a copy")
+}
@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/Dnlbb/link-shortener/internal/models"
+)
+
+// CacheConfig sizes the link cache. NegativeTTL is how long unknown codes
+// are remembered, zero disables negative caching. LookupTimeout bounds a
+// lookup shared by concurrent requests for one code, zero leaves it to the
+// deadlines of the backend.
+type CacheConfig struct {
+	Size          int
+	TTL           time.Duration
+	NegativeTTL   time.Duration
+	LookupTimeout time.Duration
+}
+
+// CacheStats are the counters of the link cache. Evictions counts the
+// entries dropped for room, not the ones that outlived their TTL.
+type CacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Size      int
+}
+
+type cacheEntry struct {
+	key       models.LinkKey
+	link      models.Link
+	found     bool
+	expiresAt time.Time
+}
+
+// result returns what Find returned for the entry. The state of the link is
+// checked again since it may have expired since it was cached.
+func (e *cacheEntry) result(now time.Time) (models.Link, error) {
+	if !e.found {
+		return models.Link{}, ErrNotFound
+	}
+	return e.link, linkState(e.link, now)
+}
+
+// CachingRepository serves Find from an LRU cache in front of any Repository,
+// every other method goes to the backend. Writes through the decorator
+// invalidate the links they touch. Writes of other instances are seen once
+// the entries expire.
+type CachingRepository struct {
+	Repository
+
+	cfg   CacheConfig
+	group singleflight.Group
+	now   func() time.Time
+
+	mu      sync.Mutex
+	entries map[models.LinkKey]*list.Element
+	order   *list.List
+	// generation changes on every invalidation, lookups started before it
+	// do not fill the cache.
+	generation uint64
+
+	hits, misses, evictions atomic.Int64
+}
+
+func NewCachingRepository(repo Repository, cfg CacheConfig) *CachingRepository {
+	return &CachingRepository{
+		Repository: repo,
+		cfg:        cfg,
+		now:        time.Now,
+		entries:    make(map[models.LinkKey]*list.Element),
+		order:      list.New(),
+	}
+}
+
+type findResult struct {
+	link models.Link
+	err  error
+}
+
+func (c *CachingRepository) Find(ctx context.Context, key models.LinkKey) (models.Link, error) {
+	if entry, ok := c.get(key); ok {
+		c.hits.Add(1)
+		return entry.result(c.now())
+	}
+	c.misses.Add(1)
+
+	// The shared lookup must not fail for everyone when the request that
+	// started it goes away.
+	lookupCtx := context.WithoutCancel(ctx)
+	ch := c.group.DoChan(key.Domain+"\x00"+key.ShortURL, func() (interface{}, error) {
+		generation := c.currentGeneration()
+		findCtx := lookupCtx
+		if c.cfg.LookupTimeout > 0 {
+			var cancel context.CancelFunc
+			findCtx, cancel = context.WithTimeout(lookupCtx, c.cfg.LookupTimeout)
+			defer cancel()
+		}
+		link, err := c.Repository.Find(findCtx, key)
+		c.store(key, link, err, generation)
+		return findResult{link: link, err: err}, nil
+	})
+	select {
+	case <-ctx.Done():
+		return models.Link{}, ctx.Err()
+	case res := <-ch:
+		found := res.Val.(findResult)
+		return found.link, found.err
+	}
+}
+
+func (c *CachingRepository) get(key models.LinkKey) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry, true
+}
+
+// store caches the outcome of a lookup unless the cache was invalidated
+// while it ran. Errors other than the states of a link are not cached.
+func (c *CachingRepository) store(key models.LinkKey, link models.Link, err error, generation uint64) {
+	entry := &cacheEntry{key: key, link: link, found: true, expiresAt: c.now().Add(c.cfg.TTL)}
+	switch {
+	case err == nil, errors.Is(err, ErrDeleted), errors.Is(err, ErrExpired):
+	case errors.Is(err, ErrNotFound) && c.cfg.NegativeTTL > 0:
+		entry = &cacheEntry{key: key, expiresAt: c.now().Add(c.cfg.NegativeTTL)}
+	default:
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.cfg.Size {
+		c.remove(c.order.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// remove drops the entry, the caller must hold c.mu.
+func (c *CachingRepository) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
+
+func (c *CachingRepository) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// Invalidate drops the links from the cache.
+func (c *CachingRepository) Invalidate(keys ...models.LinkKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for _, key := range keys {
+		if elem, ok := c.entries[key]; ok {
+			c.remove(elem)
+		}
+		c.group.Forget(key.Domain + "\x00" + key.ShortURL)
+	}
+}
+
+// Purge empties the cache.
+func (c *CachingRepository) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for key, elem := range c.entries {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		c.group.Forget(key.Domain + "\x00" + key.ShortURL)
+	}
+}
+
+func (c *CachingRepository) Stats() CacheStats {
+	c.mu.Lock()
+	size := c.order.Len()
+	c.mu.Unlock()
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Size:      size,
+	}
+}
+
+func (c *CachingRepository) Save(ctx context.Context, link models.Link) error {
+	err := c.Repository.Save(ctx, link)
+	c.Invalidate(link.Key())
+	return err
+}
+
+func (c *CachingRepository) SaveBatch(ctx context.Context, inputs []models.LinkInput) ([]models.BatchResult, error) {
+	results, err := c.Repository.SaveBatch(ctx, inputs)
+	keys := make([]models.LinkKey, len(inputs))
+	for i, in := range inputs {
+		keys[i] = models.LinkKey{Domain: in.Domain, ShortURL: in.ShortURL}
+	}
+	c.Invalidate(keys...)
+	return results, err
+}
+
+func (c *CachingRepository) DeleteBatch(ctx context.Context, owner string, keys []models.LinkKey) error {
+	err := c.Repository.DeleteBatch(ctx, owner, keys)
+	c.Invalidate(keys...)
+	return err
+}
+
+// PurgeExpired empties the cache, which links were purged is not known.
+func (c *CachingRepository) PurgeExpired(ctx context.Context, before time.Time) (int64, error) {
+	n, err := c.Repository.PurgeExpired(ctx, before)
+	if n > 0 || err != nil {
+		c.Purge()
+	}
+	return n, err
+}
+
+// Users wraps the user repository of the same backend, claiming links moves
+// them to another owner and empties the cache.
+func (c *CachingRepository) Users(users UserRepository) UserRepository {
+	return cachingUsers{UserRepository: users, cache: c}
+}
+
+type cachingUsers struct {
+	UserRepository
+	cache *CachingRepository
+}
+
+func (u cachingUsers) ClaimLinks(ctx context.Context, from, to string) (int64, error) {
+	n, err := u.UserRepository.ClaimLinks(ctx, from, to)
+	if n > 0 || err != nil {
+		u.cache.Purge()
+	}
+	return n, err
+}
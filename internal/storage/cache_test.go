@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Dnlbb/link-shortener/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingRepository counts the lookups reaching the backend, release holds
+// them back when set.
+type countingRepository struct {
+	Repository
+	finds   atomic.Int64
+	release chan struct{}
+}
+
+func (r *countingRepository) Find(ctx context.Context, key models.LinkKey) (models.Link, error) {
+	r.finds.Add(1)
+	if r.release != nil {
+		<-r.release
+	}
+	return r.Repository.Find(ctx, key)
+}
+
+func newTestCache(size int) (*CachingRepository, *countingRepository) {
+	backend := &countingRepository{Repository: NewInMemoryStorage()}
+	return NewCachingRepository(backend, CacheConfig{
+		Size:          size,
+		TTL:           time.Minute,
+		NegativeTTL:   10 * time.Second,
+		LookupTimeout: time.Second,
+	}), backend
+}
+
+func TestCachingRepository(t *testing.T) {
+	ctx := context.Background()
+	cache, backend := newTestCache(2)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	key := models.LinkKey{ShortURL: "abc"}
+
+	_, err := cache.Find(ctx, key)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = cache.Find(ctx, key)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, int64(1), backend.finds.Load(), "unknown codes are cached")
+
+	require.NoError(t, cache.Save(ctx, models.Link{ShortURL: "abc", OriginalURL: "https://a.example.com/", Owner: "user1"}))
+	link, err := cache.Find(ctx, key)
+	require.NoError(t, err, "saving replaces the negative entry")
+	assert.Equal(t, "https://a.example.com/", link.OriginalURL)
+	_, err = cache.Find(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), backend.finds.Load())
+
+	require.NoError(t, cache.DeleteBatch(ctx, "user1", []models.LinkKey{key}))
+	_, err = cache.Find(ctx, key)
+	assert.ErrorIs(t, err, ErrDeleted, "deleting invalidates the link")
+	_, err = cache.Find(ctx, key)
+	assert.ErrorIs(t, err, ErrDeleted)
+	assert.Equal(t, int64(3), backend.finds.Load())
+
+	now = now.Add(time.Minute)
+	_, err = cache.Find(ctx, key)
+	assert.ErrorIs(t, err, ErrDeleted)
+	assert.Equal(t, int64(4), backend.finds.Load(), "entries expire")
+
+	assert.Equal(t, CacheStats{Hits: 3, Misses: 4, Size: 1}, cache.Stats())
+}
+
+func TestCachingRepositoryWithoutLookupTimeout(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	require.NoError(t, mem.Save(ctx, models.Link{ShortURL: "abc", OriginalURL: "https://a.example.com/", Owner: "user1"}))
+	cache := NewCachingRepository(mem, CacheConfig{Size: 10, TTL: time.Minute})
+
+	link, err := cache.Find(ctx, models.LinkKey{ShortURL: "abc"})
+	require.NoError(t, err, "a zero timeout adds no deadline")
+	assert.Equal(t, "https://a.example.com/", link.OriginalURL)
+}
+
+func TestCachingRepositoryEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	cache, backend := newTestCache(2)
+	_, err := cache.SaveBatch(ctx, []models.LinkInput{
+		{ShortURL: "a", OriginalURL: "https://a.example.com/"},
+		{ShortURL: "b", OriginalURL: "https://b.example.com/"},
+		{ShortURL: "c", OriginalURL: "https://c.example.com/"},
+	})
+	require.NoError(t, err)
+
+	for _, code := range []string{"a", "b", "a", "c", "a", "b"} {
+		_, err := cache.Find(ctx, models.LinkKey{ShortURL: code})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int64(4), backend.finds.Load(), "a stays cached, b is evicted by c")
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 4, Evictions: 2, Size: 2}, cache.Stats())
+}
+
+func TestCachingRepositoryExpiresCachedLinks(t *testing.T) {
+	ctx := context.Background()
+	cache, _ := newTestCache(10)
+	now := time.Now()
+	expiresAt := now.Add(time.Second)
+	require.NoError(t, cache.Save(ctx, models.Link{ShortURL: "soon", OriginalURL: "https://soon.example.com/", ExpiresAt: &expiresAt}))
+
+	_, err := cache.Find(ctx, models.LinkKey{ShortURL: "soon"})
+	require.NoError(t, err)
+	cache.now = func() time.Time { return now.Add(2 * time.Second) }
+	_, err = cache.Find(ctx, models.LinkKey{ShortURL: "soon"})
+	assert.ErrorIs(t, err, ErrExpired, "the expiry of a cached link is checked on every hit")
+}
+
+func TestCachingRepositorySharesLookups(t *testing.T) {
+	cache, backend := newTestCache(10)
+	require.NoError(t, cache.Save(context.Background(), models.Link{ShortURL: "hot", OriginalURL: "https://hot.example.com/"}))
+	backend.release = make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			link, err := cache.Find(context.Background(), models.LinkKey{ShortURL: "hot"})
+			assert.NoError(t, err)
+			assert.Equal(t, "https://hot.example.com/", link.OriginalURL)
+		}()
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := cache.Find(canceled, models.LinkKey{ShortURL: "hot"})
+	assert.ErrorIs(t, err, context.Canceled, "waiting callers give up with their own context")
+
+	require.Eventually(t, func() bool { return backend.finds.Load() == 1 }, time.Second, time.Millisecond)
+	close(backend.release)
+	wg.Wait()
+	assert.Equal(t, int64(1), backend.finds.Load(), "concurrent misses share one lookup")
+}
+
+func TestCachingRepositoryClaimPurges(t *testing.T) {
+	ctx := context.Background()
+	mem := NewInMemoryStorage()
+	cache := NewCachingRepository(mem, CacheConfig{Size: 10, TTL: time.Minute, LookupTimeout: time.Second})
+	users := cache.Users(mem)
+	require.NoError(t, cache.Save(ctx, models.Link{ShortURL: "abc", OriginalURL: "https://a.example.com/", Owner: "session"}))
+	_, err := cache.Find(ctx, models.LinkKey{ShortURL: "abc"})
+	require.NoError(t, err)
+
+	claimed, err := users.ClaimLinks(ctx, "session", "account")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), claimed)
+	link, err := cache.Find(ctx, models.LinkKey{ShortURL: "abc"})
+	require.NoError(t, err)
+	assert.Equal(t, "account", link.Owner)
+}
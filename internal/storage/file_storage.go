@@ -0,0 +1,478 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Dnlbb/link-shortener/internal/models"
+)
+
+const (
+	opSave   = ""
+	opDelete = "delete"
+	opPurge  = "purge"
+	opClicks = "clicks"
+	opUser   = "user"
+	opToken  = "token"
+	opRevoke = "revoke"
+	opClaim  = "claim"
+)
+
+// fileRecord is a single JSON line of the storage file. Older files written by
+// the handlers contain the full short link in short_url and no user_id, both
+// forms are accepted on replay.
+type fileRecord struct {
+	UUID        int              `json:"uuid,omitempty"`
+	Op          string           `json:"op,omitempty"`
+	Domain      string           `json:"domain,omitempty"`
+	ShortURL    string           `json:"short_url"`
+	OriginalURL string           `json:"original_url,omitempty"`
+	UserID      string           `json:"user_id,omitempty"`
+	CreatedAt   *time.Time       `json:"created_at,omitempty"`
+	DeletedAt   *time.Time       `json:"deleted_at,omitempty"`
+	ExpiresAt   *time.Time       `json:"expires_at,omitempty"`
+	Day         *time.Time       `json:"day,omitempty"`
+	Clicks      int64            `json:"clicks,omitempty"`
+	IPHashes    []string         `json:"ip_hashes,omitempty"`
+	Referers    map[string]int64 `json:"referers,omitempty"`
+	UserAgents  map[string]int64 `json:"user_agents,omitempty"`
+	Name        string           `json:"name,omitempty"`
+	TokenID     string           `json:"token_id,omitempty"`
+	TokenHash   string           `json:"token_hash,omitempty"`
+	Prefix      string           `json:"prefix,omitempty"`
+	RevokedAt   *time.Time       `json:"revoked_at,omitempty"`
+	// From is the previous owner of the links of a claim record.
+	From string `json:"from,omitempty"`
+}
+
+// FileStorage keeps links in memory and appends every change to a JSON-lines
+// file, which is replayed on startup.
+type FileStorage struct {
+	*InMemoryStorage
+	file *os.File
+	mu   sync.Mutex
+}
+
+func NewFileStorage(path string) (*FileStorage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileStorage{
+		InMemoryStorage: NewInMemoryStorage(),
+		file:            file,
+	}
+	if err := s.restore(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileStorage) restore() error {
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	s.InMemoryStorage.mu.Lock()
+	defer s.InMemoryStorage.mu.Unlock()
+
+	scanner := bufio.NewScanner(s.file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := scanner.Bytes()
+		if len(strings.TrimSpace(string(raw))) == 0 {
+			continue
+		}
+		var rec fileRecord
+		if err := json.Unmarshal(raw, &rec); err != nil {
+			return fmt.Errorf("file storage: line %d: %w", line, err)
+		}
+		s.apply(rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	stat, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() == 0 {
+		return nil
+	}
+	last := make([]byte, 1)
+	if _, err := s.file.ReadAt(last, stat.Size()-1); err != nil {
+		return err
+	}
+	if last[0] != '\n' {
+		_, err = s.file.WriteString("\n")
+	}
+	return err
+}
+
+// apply replays a record on the in-memory state, the caller must hold
+// s.InMemoryStorage.mu.
+func (s *FileStorage) apply(rec fileRecord) {
+	key := models.LinkKey{Domain: rec.Domain, ShortURL: shortCode(rec.ShortURL)}
+	switch rec.Op {
+	case opDelete:
+		at := time.Now()
+		if rec.DeletedAt != nil {
+			at = *rec.DeletedAt
+		}
+		s.InMemoryStorage.markDeleted(key, rec.UserID, at)
+	case opClicks:
+		if rec.Day != nil {
+			s.InMemoryStorage.addClicks([]models.ClickCount{{Domain: key.Domain, ShortURL: key.ShortURL, Day: *rec.Day, Clicks: rec.Clicks, IPHashes: rec.IPHashes, Referers: rec.Referers, UserAgents: rec.UserAgents}})
+		}
+	case opPurge:
+		if rec.ExpiresAt != nil {
+			s.InMemoryStorage.purge(*rec.ExpiresAt)
+		}
+	case opUser:
+		user := models.User{ID: rec.UserID, Name: rec.Name}
+		if rec.CreatedAt != nil {
+			user.CreatedAt = *rec.CreatedAt
+		}
+		s.InMemoryStorage.users[user.ID] = user
+	case opToken:
+		token := models.APIToken{
+			ID:     rec.TokenID,
+			UserID: rec.UserID,
+			Name:   rec.Name,
+			Hash:   rec.TokenHash,
+			Prefix: rec.Prefix,
+		}
+		if rec.CreatedAt != nil {
+			token.CreatedAt = *rec.CreatedAt
+		}
+		s.InMemoryStorage.putToken(token)
+	case opRevoke:
+		if rec.RevokedAt != nil {
+			s.InMemoryStorage.revoke(rec.UserID, rec.TokenID, *rec.RevokedAt)
+		}
+	case opClaim:
+		s.InMemoryStorage.claim(rec.From, rec.UserID)
+	default:
+		link := models.Link{
+			ID:          int64(rec.UUID),
+			Domain:      key.Domain,
+			ShortURL:    key.ShortURL,
+			OriginalURL: rec.OriginalURL,
+			Owner:       rec.UserID,
+			ExpiresAt:   rec.ExpiresAt,
+			Status:      models.LinkStatusActive,
+		}
+		if rec.CreatedAt != nil {
+			link.CreatedAt = *rec.CreatedAt
+		}
+		s.InMemoryStorage.put(link)
+	}
+}
+
+// shortCode strips the base URL that legacy records stored with the code.
+func shortCode(shortURL string) string {
+	if i := strings.LastIndex(shortURL, "/"); i >= 0 {
+		return shortURL[i+1:]
+	}
+	return shortURL
+}
+
+func (s *FileStorage) Save(ctx context.Context, link models.Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.InMemoryStorage.mu.RLock()
+	err := s.InMemoryStorage.checkConflict(link)
+	s.InMemoryStorage.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if link.ID == 0 {
+		if link.ID, err = s.InMemoryStorage.NextID(ctx); err != nil {
+			return err
+		}
+	}
+
+	link = newLink(link, time.Now())
+	rec := fileRecord{
+		UUID:        int(link.ID),
+		Op:          opSave,
+		Domain:      link.Domain,
+		ShortURL:    link.ShortURL,
+		OriginalURL: link.OriginalURL,
+		UserID:      link.Owner,
+		CreatedAt:   &link.CreatedAt,
+		ExpiresAt:   link.ExpiresAt,
+	}
+	if err := s.append(rec); err != nil {
+		return err
+	}
+	return s.InMemoryStorage.Save(ctx, link)
+}
+
+func (s *FileStorage) SaveBatch(ctx context.Context, inputs []models.LinkInput) ([]models.BatchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.InMemoryStorage.mu.RLock()
+	results, created, err := s.InMemoryStorage.planBatch(inputs, time.Now())
+	s.InMemoryStorage.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	recs := make([]fileRecord, 0, len(created))
+	for i := range created {
+		if created[i].ID == 0 {
+			if created[i].ID, err = s.InMemoryStorage.NextID(ctx); err != nil {
+				return nil, err
+			}
+		}
+		recs = append(recs, fileRecord{
+			UUID:        int(created[i].ID),
+			Op:          opSave,
+			Domain:      created[i].Domain,
+			ShortURL:    created[i].ShortURL,
+			OriginalURL: created[i].OriginalURL,
+			UserID:      created[i].Owner,
+			CreatedAt:   &created[i].CreatedAt,
+			ExpiresAt:   created[i].ExpiresAt,
+		})
+	}
+	if err := s.append(recs...); err != nil {
+		return nil, err
+	}
+
+	s.InMemoryStorage.mu.Lock()
+	defer s.InMemoryStorage.mu.Unlock()
+	for _, link := range created {
+		s.InMemoryStorage.put(link)
+	}
+	return results, nil
+}
+
+func (s *FileStorage) DeleteBatch(ctx context.Context, owner string, keys []models.LinkKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var recs []fileRecord
+	var deleted []models.LinkKey
+	for _, key := range keys {
+		link, err := s.InMemoryStorage.Find(ctx, key)
+		if err != nil || link.Owner != owner {
+			continue
+		}
+		deleted = append(deleted, key)
+		recs = append(recs, fileRecord{
+			Op:        opDelete,
+			Domain:    key.Domain,
+			ShortURL:  key.ShortURL,
+			UserID:    owner,
+			DeletedAt: &now,
+		})
+	}
+	if err := s.append(recs...); err != nil {
+		return err
+	}
+
+	s.InMemoryStorage.mu.Lock()
+	defer s.InMemoryStorage.mu.Unlock()
+	for _, key := range deleted {
+		s.InMemoryStorage.markDeleted(key, owner, now)
+	}
+	return nil
+}
+
+// PurgeExpired logs a single purge record with the cutoff in expires_at, so a
+// replay drops the same links.
+func (s *FileStorage) PurgeExpired(ctx context.Context, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.InMemoryStorage.mu.RLock()
+	expired := false
+	for _, link := range s.InMemoryStorage.data {
+		if link.ExpiresAt != nil && link.ExpiresAt.Before(before) {
+			expired = true
+			break
+		}
+	}
+	s.InMemoryStorage.mu.RUnlock()
+	if !expired {
+		return 0, nil
+	}
+
+	if err := s.append(fileRecord{Op: opPurge, ExpiresAt: &before}); err != nil {
+		return 0, err
+	}
+	s.InMemoryStorage.mu.Lock()
+	defer s.InMemoryStorage.mu.Unlock()
+	return s.InMemoryStorage.purge(before), nil
+}
+
+func (s *FileStorage) RecordClicks(ctx context.Context, counts []models.ClickCount) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recs := make([]fileRecord, 0, len(counts))
+	s.InMemoryStorage.mu.RLock()
+	for i := range counts {
+		if _, exists := s.InMemoryStorage.data[models.LinkKey{Domain: counts[i].Domain, ShortURL: counts[i].ShortURL}]; !exists {
+			continue
+		}
+		day := clickDay(counts[i].Day)
+		recs = append(recs, fileRecord{
+			Op:         opClicks,
+			Domain:     counts[i].Domain,
+			ShortURL:   counts[i].ShortURL,
+			Day:        &day,
+			Clicks:     counts[i].Clicks,
+			IPHashes:   counts[i].IPHashes,
+			Referers:   counts[i].Referers,
+			UserAgents: counts[i].UserAgents,
+		})
+	}
+	s.InMemoryStorage.mu.RUnlock()
+	if err := s.append(recs...); err != nil {
+		return err
+	}
+
+	s.InMemoryStorage.mu.Lock()
+	defer s.InMemoryStorage.mu.Unlock()
+	s.InMemoryStorage.addClicks(counts)
+	return nil
+}
+
+func (s *FileStorage) CreateUser(ctx context.Context, user models.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec := fileRecord{Op: opUser, UserID: user.ID, Name: user.Name, CreatedAt: &user.CreatedAt}
+	if err := s.append(rec); err != nil {
+		return err
+	}
+	return s.InMemoryStorage.CreateUser(ctx, user)
+}
+
+func (s *FileStorage) SaveToken(ctx context.Context, token models.APIToken) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.InMemoryStorage.FindUser(ctx, token.UserID); err != nil {
+		return err
+	}
+	rec := fileRecord{
+		Op:        opToken,
+		UserID:    token.UserID,
+		TokenID:   token.ID,
+		Name:      token.Name,
+		TokenHash: token.Hash,
+		Prefix:    token.Prefix,
+		CreatedAt: &token.CreatedAt,
+	}
+	if err := s.append(rec); err != nil {
+		return err
+	}
+	return s.InMemoryStorage.SaveToken(ctx, token)
+}
+
+func (s *FileStorage) RevokeToken(ctx context.Context, userID, tokenID string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.InMemoryStorage.mu.RLock()
+	token, exists := s.InMemoryStorage.tokens[tokenID]
+	s.InMemoryStorage.mu.RUnlock()
+	if !exists || token.UserID != userID {
+		return ErrTokenNotFound
+	}
+	if token.IsRevoked() {
+		return nil
+	}
+	if err := s.append(fileRecord{Op: opRevoke, UserID: userID, TokenID: tokenID, RevokedAt: &at}); err != nil {
+		return err
+	}
+	return s.InMemoryStorage.RevokeToken(ctx, userID, tokenID, at)
+}
+
+func (s *FileStorage) ClaimLinks(ctx context.Context, from, to string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	links, err := s.InMemoryStorage.FindAllByOwner(ctx, from, models.LinkFilter{})
+	if err != nil || len(links) == 0 {
+		return 0, err
+	}
+	if err := s.append(fileRecord{Op: opClaim, UserID: to, From: from}); err != nil {
+		return 0, err
+	}
+	return s.InMemoryStorage.ClaimLinks(ctx, from, to)
+}
+
+// append writes the records with a single write and fsync.
+func (s *FileStorage) append(recs ...fileRecord) error {
+	if len(recs) == 0 {
+		return nil
+	}
+	var data []byte
+	for _, rec := range recs {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		data = append(data, line...)
+		data = append(data, '\n')
+	}
+	if _, err := s.file.Write(data); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
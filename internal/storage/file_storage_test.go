@@ -0,0 +1,367 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Dnlbb/link-shortener/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStorageRestore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "db", "short-url-db.json")
+
+	s, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, s.Save(ctx, models.Link{ShortURL: "abc12345", OriginalURL: "https://practicum.yandex.ru/", Owner: "user1"}))
+	require.NoError(t, s.Save(ctx, models.Link{ShortURL: "def67890", OriginalURL: "https://google.com/", Owner: "user2"}))
+	assert.ErrorIs(t, s.Save(ctx, models.Link{ShortURL: "abc12345", OriginalURL: "https://practicum.yandex.ru/", Owner: "user1"}), ErrConflict)
+	require.NoError(t, s.Close())
+
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	link, err := restored.Find(ctx, models.LinkKey{ShortURL: "abc12345"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://practicum.yandex.ru/", link.OriginalURL)
+	assert.Equal(t, "user1", link.Owner)
+
+	link, err = restored.Find(ctx, models.LinkKey{ShortURL: "def67890"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://google.com/", link.OriginalURL)
+
+	uuid, err := restored.GetUUID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, uuid)
+
+	_, err = restored.Find(ctx, models.LinkKey{ShortURL: "missing0"})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestFileStorageRowIDs(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "short-url-db.json")
+
+	s, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, s.Save(ctx, models.Link{ShortURL: "abc12345", OriginalURL: "https://practicum.yandex.ru/"}))
+	reserved, err := s.NextID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), reserved)
+	require.NoError(t, s.Save(ctx, models.Link{ShortURL: "def67890", OriginalURL: "https://google.com/"}))
+	require.NoError(t, s.Save(ctx, models.Link{ID: reserved, ShortURL: "2", OriginalURL: "https://go.dev/"}))
+	require.NoError(t, s.Close())
+
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer restored.Close()
+	for code, id := range map[string]int64{"abc12345": 1, "2": 2, "def67890": 3} {
+		link, err := restored.Find(ctx, models.LinkKey{ShortURL: code})
+		require.NoError(t, err)
+		assert.Equal(t, id, link.ID, code)
+	}
+	next, err := restored.NextID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), next)
+}
+
+func TestFileStorageLegacyFormat(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "short-url-db.json")
+	legacy := `{"uuid":1,"short_url":"http://localhost:8080/abc12345","original_url":"https://practicum.yandex.ru/"}` + "\n" +
+		`{"uuid":2,"short_url":"http://localhost:8080/def67890","original_url":"https://google.com/"}`
+	require.NoError(t, os.WriteFile(path, []byte(legacy), 0644))
+
+	s, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, s.Save(ctx, models.Link{ShortURL: "0a1b2c3d", OriginalURL: "https://example.com/", Owner: "user1"}))
+	require.NoError(t, s.Close())
+
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	for shortURL, want := range map[string]string{
+		"abc12345": "https://practicum.yandex.ru/",
+		"def67890": "https://google.com/",
+		"0a1b2c3d": "https://example.com/",
+	} {
+		link, err := restored.Find(ctx, models.LinkKey{ShortURL: shortURL})
+		require.NoError(t, err, shortURL)
+		assert.Equal(t, want, link.OriginalURL)
+	}
+}
+
+func TestFileStorageDeleteBatch(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "short-url-db.json")
+
+	s, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, s.Save(ctx, models.Link{ShortURL: "abc12345", OriginalURL: "https://practicum.yandex.ru/", Owner: "user1"}))
+	require.NoError(t, s.DeleteBatch(ctx, "user2", []models.LinkKey{{ShortURL: "abc12345"}}))
+	_, err = s.Find(ctx, models.LinkKey{ShortURL: "abc12345"})
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteBatch(ctx, "user1", []models.LinkKey{{ShortURL: "abc12345"}, {ShortURL: "missing0"}}))
+	require.NoError(t, s.Close())
+
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	link, err := restored.Find(ctx, models.LinkKey{ShortURL: "abc12345"})
+	assert.ErrorIs(t, err, ErrDeleted)
+	assert.Equal(t, models.LinkStatusDeleted, link.Status)
+	assert.NotNil(t, link.DeletedAt)
+	assert.Equal(t, "https://practicum.yandex.ru/", link.OriginalURL)
+}
+
+func TestFileStorageSaveBatch(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "short-url-db.json")
+
+	s, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, s.Save(ctx, models.Link{ShortURL: "abc12345", OriginalURL: "https://practicum.yandex.ru/", Owner: "user1"}))
+	results, err := s.SaveBatch(ctx, []models.LinkInput{
+		{ShortURL: "abc12345", OriginalURL: "https://practicum.yandex.ru/", Owner: "user1"},
+		{ShortURL: "def67890", OriginalURL: "https://google.com/", Owner: "user1"},
+		{ShortURL: "0a1b2c3d", OriginalURL: "https://example.com/", Owner: "user2"},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.True(t, results[0].Existed)
+	assert.False(t, results[1].Existed)
+	assert.Equal(t, "0a1b2c3d", results[2].Link.ShortURL)
+
+	_, err = s.SaveBatch(ctx, []models.LinkInput{
+		{ShortURL: "11111111", OriginalURL: "https://go.dev/", Owner: "user1"},
+		{ShortURL: "def67890", OriginalURL: "https://pkg.go.dev/", Owner: "user1"},
+	})
+	assert.ErrorIs(t, err, ErrCodeTaken)
+	_, err = s.Find(ctx, models.LinkKey{ShortURL: "11111111"})
+	assert.ErrorIs(t, err, ErrNotFound)
+	require.NoError(t, s.Close())
+
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	links, err := restored.FindAllByOwner(ctx, "user1", models.LinkFilter{})
+	require.NoError(t, err)
+	require.Len(t, links, 2)
+	assert.ElementsMatch(t, []string{"abc12345", "def67890"}, []string{links[0].ShortURL, links[1].ShortURL})
+
+	uuid, err := restored.GetUUID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, uuid)
+}
+
+func TestFileStorageExpiry(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "short-url-db.json")
+	past := time.Now().Add(-2 * time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	s, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, s.Save(ctx, models.Link{ShortURL: "expired1", OriginalURL: "https://expired.example.com/", Owner: "user1", ExpiresAt: &past}))
+	_, err = s.SaveBatch(ctx, []models.LinkInput{{ShortURL: "fresh123", OriginalURL: "https://fresh.example.com/", Owner: "user1", ExpiresAt: &future}})
+	require.NoError(t, err)
+
+	link, err := s.Find(ctx, models.LinkKey{ShortURL: "expired1"})
+	assert.ErrorIs(t, err, ErrExpired)
+	assert.Equal(t, "https://expired.example.com/", link.OriginalURL)
+	_, err = s.Find(ctx, models.LinkKey{ShortURL: "fresh123"})
+	require.NoError(t, err)
+
+	purged, err := s.PurgeExpired(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	require.NoError(t, s.Close())
+
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	_, err = restored.Find(ctx, models.LinkKey{ShortURL: "expired1"})
+	assert.ErrorIs(t, err, ErrNotFound)
+	link, err = restored.Find(ctx, models.LinkKey{ShortURL: "fresh123"})
+	require.NoError(t, err)
+	require.NotNil(t, link.ExpiresAt)
+	assert.True(t, link.ExpiresAt.Equal(future))
+
+	require.NoError(t, restored.Save(ctx, models.Link{ShortURL: "expired2", OriginalURL: "https://expired.example.com/", Owner: "user1"}))
+}
+
+func TestFileStorageCountActiveByOwner(t *testing.T) {
+	ctx := context.Background()
+	past := time.Now().Add(-time.Hour)
+	s, err := NewFileStorage(filepath.Join(t.TempDir(), "short-url-db.json"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = s.SaveBatch(ctx, []models.LinkInput{
+		{ShortURL: "active01", OriginalURL: "https://a.example.com/", Owner: "user1"},
+		{ShortURL: "active02", OriginalURL: "https://b.example.com/", Owner: "user1", Domain: "brand.example"},
+		{ShortURL: "deleted1", OriginalURL: "https://c.example.com/", Owner: "user1"},
+		{ShortURL: "expired1", OriginalURL: "https://d.example.com/", Owner: "user1", ExpiresAt: &past},
+		{ShortURL: "other001", OriginalURL: "https://e.example.com/", Owner: "user2"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteBatch(ctx, "user1", []models.LinkKey{{ShortURL: "deleted1"}}))
+
+	count, err := s.CountActiveByOwner(ctx, "user1", time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	count, err = s.CountActiveByOwner(ctx, "nobody", time.Now())
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
+
+func TestFileStorageCountNewLinks(t *testing.T) {
+	ctx := context.Background()
+	future := time.Now().Add(time.Hour)
+	s, err := NewFileStorage(filepath.Join(t.TempDir(), "short-url-db.json"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = s.SaveBatch(ctx, []models.LinkInput{
+		{ShortURL: "stored01", OriginalURL: "https://a.example.com/", Owner: "user1"},
+		{ShortURL: "expiring", OriginalURL: "https://b.example.com/", Owner: "user1", ExpiresAt: &future},
+	})
+	require.NoError(t, err)
+
+	count, err := s.CountNewLinks(ctx, []models.LinkInput{
+		{OriginalURL: "https://a.example.com/"},
+		{OriginalURL: "https://a.example.com/", Domain: "brand.example"},
+		{OriginalURL: "https://a.example.com/", ExpiresAt: &future},
+		{OriginalURL: "https://b.example.com/"},
+		{OriginalURL: "https://c.example.com/"},
+		{OriginalURL: "https://c.example.com/"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 4, count, "stored permanent URLs and repeats create no link")
+}
+
+func TestFileStorageClicks(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "short-url-db.json")
+	day1 := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	day2 := time.Date(2024, 3, 2, 18, 30, 0, 0, time.UTC)
+
+	s, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, s.Save(ctx, models.Link{ShortURL: "abc12345", OriginalURL: "https://practicum.yandex.ru/", Owner: "user1"}))
+	require.NoError(t, s.RecordClicks(ctx, []models.ClickCount{
+		{ShortURL: "abc12345", Day: day2, Clicks: 2, IPHashes: []string{"a"}},
+		{ShortURL: "abc12345", Day: day1, Clicks: 3, IPHashes: []string{"a", "b"}, Referers: map[string]int64{"news.example.com": 2}, UserAgents: map[string]int64{"test-agent": 3}},
+		{ShortURL: "missing0", Day: day1, Clicks: 7},
+	}))
+	require.NoError(t, s.RecordClicks(ctx, []models.ClickCount{{ShortURL: "abc12345", Day: day1.Add(time.Hour), Clicks: 1, IPHashes: []string{"b", "c"}, Referers: map[string]int64{"news.example.com": 1}}}))
+	require.NoError(t, s.Close())
+
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	counts, err := restored.ClickStats(ctx, models.LinkKey{ShortURL: "abc12345"})
+	require.NoError(t, err)
+	require.Len(t, counts, 2)
+	assert.True(t, counts[0].Day.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, int64(4), counts[0].Clicks)
+	assert.Equal(t, int64(3), counts[0].Visitors, "visitors are counted once a day")
+	assert.Equal(t, map[string]int64{"news.example.com": 3}, counts[0].Referers)
+	assert.Equal(t, map[string]int64{"test-agent": 3}, counts[0].UserAgents)
+	assert.Equal(t, int64(2), counts[1].Clicks)
+	assert.Equal(t, int64(1), counts[1].Visitors)
+
+	counts, err = restored.ClickStats(ctx, models.LinkKey{ShortURL: "missing0"})
+	require.NoError(t, err)
+	assert.Empty(t, counts)
+}
+
+func TestFileStorageDomains(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "short-url-db.json")
+	brand := models.LinkKey{Domain: "brand.example", ShortURL: "abc12345"}
+
+	s, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, s.Save(ctx, models.Link{ShortURL: "abc12345", OriginalURL: "https://practicum.yandex.ru/", Owner: "user1"}))
+	require.NoError(t, s.Save(ctx, models.Link{Domain: brand.Domain, ShortURL: brand.ShortURL, OriginalURL: "https://practicum.yandex.ru/", Owner: "user1"}),
+		"codes and original URLs are unique per domain")
+	assert.ErrorIs(t, s.Save(ctx, models.Link{Domain: brand.Domain, ShortURL: "abc12345", OriginalURL: "https://google.com/", Owner: "user1"}), ErrCodeTaken)
+	require.NoError(t, s.DeleteBatch(ctx, "user1", []models.LinkKey{brand}))
+	require.NoError(t, s.RecordClicks(ctx, []models.ClickCount{{ShortURL: "abc12345", Day: time.Now(), Clicks: 3}}))
+	require.NoError(t, s.Close())
+
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	link, err := restored.Find(ctx, models.LinkKey{ShortURL: "abc12345"})
+	require.NoError(t, err)
+	assert.Equal(t, "", link.Domain)
+	link, err = restored.Find(ctx, brand)
+	assert.ErrorIs(t, err, ErrDeleted)
+	assert.Equal(t, "brand.example", link.Domain)
+
+	counts, err := restored.ClickStats(ctx, brand)
+	require.NoError(t, err)
+	assert.Empty(t, counts)
+
+	links, err := restored.FindAllByOwner(ctx, "user1", models.LinkFilter{Domains: []string{"brand.example"}})
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	assert.Equal(t, brand, links[0].Key())
+	links, err = restored.FindAllByOwner(ctx, "user1", models.LinkFilter{})
+	require.NoError(t, err)
+	assert.Len(t, links, 2)
+}
+
+func TestFileStorageUsers(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "short-url-db.json")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	s, err := NewFileStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, s.Save(ctx, models.Link{ShortURL: "abc12345", OriginalURL: "https://practicum.yandex.ru/", Owner: "session1"}))
+	require.NoError(t, s.CreateUser(ctx, models.User{ID: "user1", Name: "ci", CreatedAt: now}))
+	require.NoError(t, s.SaveToken(ctx, models.APIToken{ID: "token1", UserID: "user1", Name: "default", Hash: "hash1", Prefix: "lsk_aaaaaaaa", CreatedAt: now}))
+	require.NoError(t, s.SaveToken(ctx, models.APIToken{ID: "token2", UserID: "user1", Name: "deploy", Hash: "hash2", Prefix: "lsk_bbbbbbbb", CreatedAt: now.Add(time.Second)}))
+	assert.ErrorIs(t, s.SaveToken(ctx, models.APIToken{ID: "token3", UserID: "missing", Hash: "hash3"}), ErrUserNotFound)
+	require.NoError(t, s.RevokeToken(ctx, "user1", "token1", now))
+	assert.ErrorIs(t, s.RevokeToken(ctx, "user2", "token2", now), ErrTokenNotFound)
+	claimed, err := s.ClaimLinks(ctx, "session1", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), claimed)
+	require.NoError(t, s.Close())
+
+	restored, err := NewFileStorage(path)
+	require.NoError(t, err)
+	defer restored.Close()
+
+	user, err := restored.FindUser(ctx, "user1")
+	require.NoError(t, err)
+	assert.Equal(t, "ci", user.Name)
+	token, err := restored.FindToken(ctx, "hash1")
+	require.NoError(t, err)
+	assert.True(t, token.IsRevoked())
+	tokens, err := restored.ListTokens(ctx, "user1")
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+	assert.Equal(t, "token2", tokens[1].ID)
+	assert.False(t, tokens[1].IsRevoked())
+
+	link, err := restored.Find(ctx, models.LinkKey{ShortURL: "abc12345"})
+	require.NoError(t, err)
+	assert.Equal(t, "user1", link.Owner)
+}
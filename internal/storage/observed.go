@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/Dnlbb/link-shortener/internal/models"
+)
+
+// Observer is told about every call of an ObservedRepository. It is called
+// before the call with the name of the method and returns the context the
+// call runs with and the function called with its outcome.
+type Observer func(ctx context.Context, op string) (context.Context, func(err error))
+
+// ObservedRepository reports every call of a Repository to its observers,
+// which time and trace the storage without the backends knowing.
+type ObservedRepository struct {
+	repo      Repository
+	observers []Observer
+}
+
+func NewObservedRepository(repo Repository, observers ...Observer) *ObservedRepository {
+	return &ObservedRepository{repo: repo, observers: observers}
+}
+
+// observe starts the call in every observer, the returned function ends it.
+func (o *ObservedRepository) observe(ctx context.Context, op string) (context.Context, func(err error)) {
+	done := make([]func(error), len(o.observers))
+	for i, observer := range o.observers {
+		ctx, done[i] = observer(ctx, op)
+	}
+	return ctx, func(err error) {
+		for i := len(done) - 1; i >= 0; i-- {
+			done[i](err)
+		}
+	}
+}
+
+func (o *ObservedRepository) Save(ctx context.Context, link models.Link) error {
+	ctx, done := o.observe(ctx, "save")
+	err := o.repo.Save(ctx, link)
+	done(err)
+	return err
+}
+
+func (o *ObservedRepository) SaveBatch(ctx context.Context, inputs []models.LinkInput) ([]models.BatchResult, error) {
+	ctx, done := o.observe(ctx, "save_batch")
+	results, err := o.repo.SaveBatch(ctx, inputs)
+	done(err)
+	return results, err
+}
+
+func (o *ObservedRepository) Find(ctx context.Context, key models.LinkKey) (models.Link, error) {
+	ctx, done := o.observe(ctx, "find")
+	link, err := o.repo.Find(ctx, key)
+	done(err)
+	return link, err
+}
+
+func (o *ObservedRepository) FindAllByOwner(ctx context.Context, owner string, filter models.LinkFilter) ([]models.Link, error) {
+	ctx, done := o.observe(ctx, "find_all_by_owner")
+	links, err := o.repo.FindAllByOwner(ctx, owner, filter)
+	done(err)
+	return links, err
+}
+
+func (o *ObservedRepository) DeleteBatch(ctx context.Context, owner string, keys []models.LinkKey) error {
+	ctx, done := o.observe(ctx, "delete_batch")
+	err := o.repo.DeleteBatch(ctx, owner, keys)
+	done(err)
+	return err
+}
+
+func (o *ObservedRepository) CountActiveByOwner(ctx context.Context, owner string, now time.Time) (int, error) {
+	ctx, done := o.observe(ctx, "count_active_by_owner")
+	count, err := o.repo.CountActiveByOwner(ctx, owner, now)
+	done(err)
+	return count, err
+}
+
+func (o *ObservedRepository) CountNewLinks(ctx context.Context, inputs []models.LinkInput) (int, error) {
+	ctx, done := o.observe(ctx, "count_new_links")
+	n, err := o.repo.CountNewLinks(ctx, inputs)
+	done(err)
+	return n, err
+}
+
+func (o *ObservedRepository) PurgeExpired(ctx context.Context, before time.Time) (int64, error) {
+	ctx, done := o.observe(ctx, "purge_expired")
+	n, err := o.repo.PurgeExpired(ctx, before)
+	done(err)
+	return n, err
+}
+
+func (o *ObservedRepository) RecordClicks(ctx context.Context, counts []models.ClickCount) error {
+	ctx, done := o.observe(ctx, "record_clicks")
+	err := o.repo.RecordClicks(ctx, counts)
+	done(err)
+	return err
+}
+
+func (o *ObservedRepository) ClickStats(ctx context.Context, key models.LinkKey) ([]models.ClickCount, error) {
+	ctx, done := o.observe(ctx, "click_stats")
+	counts, err := o.repo.ClickStats(ctx, key)
+	done(err)
+	return counts, err
+}
+
+func (o *ObservedRepository) NextID(ctx context.Context) (int64, error) {
+	ctx, done := o.observe(ctx, "next_id")
+	id, err := o.repo.NextID(ctx)
+	done(err)
+	return id, err
+}
+
+func (o *ObservedRepository) CreateTable(ctx context.Context) error {
+	ctx, done := o.observe(ctx, "create_table")
+	err := o.repo.CreateTable(ctx)
+	done(err)
+	return err
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Dnlbb/link-shortener/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type observerKey struct{}
+
+func TestObservedRepository(t *testing.T) {
+	var calls []string
+	observer := func(name string) Observer {
+		return func(ctx context.Context, op string) (context.Context, func(err error)) {
+			calls = append(calls, name+" start "+op)
+			ctx = context.WithValue(ctx, observerKey{}, name)
+			return ctx, func(err error) {
+				result := "ok"
+				if err != nil {
+					result = err.Error()
+				}
+				calls = append(calls, name+" end "+op+" "+result)
+			}
+		}
+	}
+	repo := NewObservedRepository(NewInMemoryStorage(), observer("outer"), observer("inner"))
+	ctx := context.Background()
+
+	require.NoError(t, repo.Save(ctx, models.Link{ShortURL: "abc", OriginalURL: "https://a.example.com/"}))
+	_, err := repo.Find(ctx, models.LinkKey{ShortURL: "missing"})
+	require.ErrorIs(t, err, ErrNotFound)
+
+	assert.Equal(t, []string{
+		"outer start save", "inner start save", "inner end save ok", "outer end save ok",
+		"outer start find", "inner start find", "inner end find " + ErrNotFound.Error(), "outer end find " + ErrNotFound.Error(),
+	}, calls)
+}
@@ -1,13 +1,61 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
-	"log"
-	"sync"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/Dnlbb/link-shortener/internal/logger"
+	"github.com/Dnlbb/link-shortener/internal/migrations"
 	"github.com/Dnlbb/link-shortener/internal/models"
 )
 
+// linkColumns is the column list read by scanLink.
+const linkColumns = `id, domain, short_url, original_url, owner, DeletedFlag, created_at, deleted_at, expires_at`
+
+// batchChunkSize keeps a multi-row insert below the bind parameter limit.
+const batchChunkSize = 1000
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanLink(row rowScanner) (models.Link, error) {
+	var link models.Link
+	var deleted bool
+	var deletedAt, expiresAt sql.NullTime
+	err := row.Scan(&link.ID, &link.Domain, &link.ShortURL, &link.OriginalURL, &link.Owner, &deleted, &link.CreatedAt, &deletedAt, &expiresAt)
+	if err != nil {
+		return models.Link{}, err
+	}
+	if expiresAt.Valid {
+		link.ExpiresAt = &expiresAt.Time
+	}
+	link.Status = models.LinkStatusActive
+	if deleted {
+		link.Status = models.LinkStatusDeleted
+		if deletedAt.Valid {
+			link.DeletedAt = &deletedAt.Time
+		}
+	}
+	return link, nil
+}
+
+// nextRowID is the id of an inserted link: the ID reserved for its code, or
+// the next value of the sequence when the link carries none.
+const nextRowID = `COALESCE($%d::bigint, nextval(pg_get_serial_sequence('urls', 'id')))`
+
+// rowID binds the ID of the link for nextRowID.
+func rowID(id int64) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
 type PostgresStorage struct {
 	db *sql.DB
 }
@@ -20,95 +68,563 @@ func (s *PostgresStorage) GetDB() *sql.DB {
 	return s.db
 }
 
-func (s *PostgresStorage) CreateTable() error {
+// CreateTable brings the schema up to date with the embedded migrations.
+func (s *PostgresStorage) CreateTable(ctx context.Context) error {
+	migrator, err := migrations.New(s.db)
+	if err != nil {
+		return err
+	}
+	_, err = migrator.Up(ctx)
+	return err
+}
+
+func (s *PostgresStorage) Save(ctx context.Context, link models.Link) error {
+	logger.FromContext(ctx).WithFields(map[string]interface{}{
+		"short_url":        link.ShortURL,
+		logger.FieldURL:    link.OriginalURL,
+		logger.FieldUserID: link.Owner,
+	}).Debug("Saving URL")
+	link = newLink(link, time.Now())
 	query := `
-	CREATE TABLE IF NOT EXISTS urls (
-		id SERIAL PRIMARY KEY,
-		short_url VARCHAR(8) NOT NULL UNIQUE,
-		original_url TEXT NOT NULL,
-		owner VARCHAR(50) NOT NULL,
-		DeletedFlag BOOL NOT NULL
-	);`
+	INSERT INTO urls (id, domain, short_url, original_url, owner, DeletedFlag, created_at, expires_at)
+	VALUES (` + fmt.Sprintf(nextRowID, 7) + `, $1, $2, $3, $4, false, $5, $6)
+	ON CONFLICT DO NOTHING`
+	res, err := s.db.ExecContext(ctx, query, link.Domain, link.ShortURL, link.OriginalURL, link.Owner, link.CreatedAt, link.ExpiresAt, rowID(link.ID))
+	if err != nil {
+		return err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted > 0 {
+		return nil
+	}
+	if link.ExpiresAt != nil {
+		return ErrCodeTaken
+	}
 
-	_, err := s.db.Exec(query)
+	var existing string
+	query = `SELECT short_url FROM urls WHERE domain = $1 AND original_url = $2 AND expires_at IS NULL`
+	err = s.db.QueryRowContext(ctx, query, link.Domain, link.OriginalURL).Scan(&existing)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrCodeTaken
+	}
 	if err != nil {
 		return err
 	}
+	return &ConflictError{ShortURL: existing}
+}
 
-	indexQuery := `CREATE UNIQUE INDEX IF NOT EXISTS idx_original_url ON urls (original_url);`
-	_, err = s.db.Exec(indexQuery)
-	return err
+func (s *PostgresStorage) Find(ctx context.Context, key models.LinkKey) (models.Link, error) {
+	query := `SELECT ` + linkColumns + ` FROM urls WHERE domain = $1 AND short_url = $2`
+	link, err := scanLink(s.db.QueryRowContext(ctx, query, key.Domain, key.ShortURL))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Link{}, ErrNotFound
+	}
+	if err != nil {
+		return models.Link{}, err
+	}
+	return link, linkState(link, time.Now())
+}
+
+func (s *PostgresStorage) NextID(ctx context.Context) (int64, error) {
+	var id int64
+	query := `SELECT nextval(pg_get_serial_sequence('urls', 'id'))`
+	err := s.db.QueryRowContext(ctx, query).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *PostgresStorage) SaveBatch(ctx context.Context, inputs []models.LinkInput) ([]models.BatchResult, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	created := make(map[models.LinkKey]models.Link, len(inputs))
+	permanent := make(map[originalKey]models.Link, len(inputs))
+	now := time.Now()
+	for start := 0; start < len(inputs); start += batchChunkSize {
+		end := min(start+batchChunkSize, len(inputs))
+		if err := insertLinks(ctx, tx, inputs[start:end], now, created, permanent); err != nil {
+			return nil, err
+		}
+	}
+
+	var missing []originalKey
+	for _, in := range inputs {
+		if in.ExpiresAt != nil {
+			continue
+		}
+		if _, ok := permanent[originalKey{in.Domain, in.OriginalURL}]; !ok {
+			missing = append(missing, originalKey{in.Domain, in.OriginalURL})
+		}
+	}
+	existing, err := findByOriginal(ctx, tx, missing)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]models.BatchResult, len(inputs))
+	for i, in := range inputs {
+		if link, ok := created[models.LinkKey{Domain: in.Domain, ShortURL: in.ShortURL}]; ok {
+			results[i] = models.BatchResult{Link: link}
+			continue
+		}
+		original := originalKey{in.Domain, in.OriginalURL}
+		if link, ok := permanent[original]; ok && in.ExpiresAt == nil {
+			results[i] = models.BatchResult{Link: link}
+			continue
+		}
+		link, ok := existing[original]
+		if !ok || in.ExpiresAt != nil {
+			return nil, fmt.Errorf("%w: %s", ErrCodeTaken, in.ShortURL)
+		}
+		results[i] = models.BatchResult{Link: link, Existed: true}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// insertLinks inserts the inputs with one multi-row statement and records the
+// rows that were actually created by key, and the permanent ones also by
+// domain and original URL.
+func insertLinks(ctx context.Context, tx *sql.Tx, inputs []models.LinkInput, now time.Time, created map[models.LinkKey]models.Link, permanent map[originalKey]models.Link) error {
+	var query strings.Builder
+	query.WriteString(`INSERT INTO urls (id, domain, short_url, original_url, owner, DeletedFlag, created_at, expires_at) VALUES `)
+	args := make([]interface{}, 0, len(inputs)*7)
+	for i, in := range inputs {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		n := len(args)
+		fmt.Fprintf(&query, "("+nextRowID+", $%d, $%d, $%d, $%d, false, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7)
+		args = append(args, rowID(in.ID), in.Domain, in.ShortURL, in.OriginalURL, in.Owner, now, in.ExpiresAt)
+	}
+	query.WriteString(` ON CONFLICT DO NOTHING RETURNING ` + linkColumns)
+
+	rows, err := tx.QueryContext(ctx, query.String(), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return err
+		}
+		created[link.Key()] = link
+		if link.ExpiresAt == nil {
+			permanent[originalKey{link.Domain, link.OriginalURL}] = link
+		}
+	}
+	return rows.Err()
+}
+
+// querier runs a query on the database or inside a transaction.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func findByOriginal(ctx context.Context, tx querier, originals []originalKey) (map[originalKey]models.Link, error) {
+	links := make(map[originalKey]models.Link, len(originals))
+	if len(originals) == 0 {
+		return links, nil
+	}
+	domains := make([]string, len(originals))
+	originalURLs := make([]string, len(originals))
+	for i, o := range originals {
+		domains[i], originalURLs[i] = o.domain, o.originalURL
+	}
+	query := `
+	SELECT ` + linkColumns + ` FROM urls
+	WHERE expires_at IS NULL AND (domain, original_url) IN (SELECT * FROM unnest($1::text[], $2::text[]))`
+	rows, err := tx.QueryContext(ctx, query, domains, originalURLs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links[originalKey{link.Domain, link.OriginalURL}] = link
+	}
+	return links, rows.Err()
+}
+
+func (s *PostgresStorage) FindAllByOwner(ctx context.Context, owner string, filter models.LinkFilter) ([]models.Link, error) {
+	query := `SELECT ` + linkColumns + ` FROM urls WHERE owner = $1 ORDER BY id`
+	args := []interface{}{owner}
+	if len(filter.Domains) > 0 {
+		query = `SELECT ` + linkColumns + ` FROM urls WHERE owner = $1 AND domain = ANY($2) ORDER BY id`
+		args = append(args, filter.Domains)
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []models.Link
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+func (s *PostgresStorage) CountActiveByOwner(ctx context.Context, owner string, now time.Time) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, `
+	SELECT COUNT(*) FROM urls
+	WHERE owner = $1 AND NOT DeletedFlag AND (expires_at IS NULL OR expires_at > $2)`, owner, now).Scan(&count)
+	return count, err
+}
+
+func (s *PostgresStorage) CountNewLinks(ctx context.Context, inputs []models.LinkInput) (int, error) {
+	var originals []originalKey
+	for _, in := range inputs {
+		if in.ExpiresAt == nil {
+			originals = append(originals, originalKey{in.Domain, in.OriginalURL})
+		}
+	}
+	existing, err := findByOriginal(ctx, s.db, originals)
+	if err != nil {
+		return 0, err
+	}
+	return countNew(inputs, func(original originalKey) bool {
+		_, exists := existing[original]
+		return exists
+	}), nil
 }
-func (s *PostgresStorage) Save(shortURL, originalURL, owner string) error {
-	log.Printf("Saving URL: shortURL=%s, originalURL=%s, owner=%s", shortURL, originalURL, owner)
+
+func (s *PostgresStorage) DeleteBatch(ctx context.Context, owner string, keys []models.LinkKey) error {
+	domains := make([]string, len(keys))
+	shortURLs := make([]string, len(keys))
+	for i, key := range keys {
+		domains[i], shortURLs[i] = key.Domain, key.ShortURL
+	}
 	query := `
-	INSERT INTO urls (short_url, original_url, owner, DeletedFlag)
-	VALUES ($1, $2, $3, false)
-	ON CONFLICT (short_url) DO NOTHING`
-	_, err := s.db.Exec(query, shortURL, originalURL, owner)
+	UPDATE urls SET DeletedFlag = true, deleted_at = COALESCE(deleted_at, now())
+	WHERE owner = $1 AND (domain, short_url) IN (SELECT * FROM unnest($2::text[], $3::text[]))`
+	_, err := s.db.ExecContext(ctx, query, owner, domains, shortURLs)
 	return err
 }
 
-func (s *PostgresStorage) Find(shortURL string) (string, bool) {
-	var originalURL string
-	var deleted bool
-	query := `SELECT original_url, DeletedFlag FROM urls WHERE short_url = $1`
-	err := s.db.QueryRow(query, shortURL).Scan(&originalURL, &deleted)
+func (s *PostgresStorage) PurgeExpired(ctx context.Context, before time.Time) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return "", false
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"link_clicks", "link_visitors", "link_sources"} {
+		clicksQuery := `
+	DELETE FROM ` + table + `
+	WHERE (domain, short_url) IN (SELECT domain, short_url FROM urls WHERE expires_at < $1)`
+		if _, err := tx.ExecContext(ctx, clicksQuery, before); err != nil {
+			return 0, err
 		}
-		return "", false
 	}
-	if deleted {
-		return "deleted", true
+	res, err := tx.ExecContext(ctx, `DELETE FROM urls WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	purged, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
 	}
-	return originalURL, true
+	return purged, tx.Commit()
 }
 
-func (s *PostgresStorage) GetUUID() int {
-	var uuid int
-	query := `SELECT COUNT(*) FROM urls`
-	err := s.db.QueryRow(query).Scan(&uuid)
+// RecordClicks upserts the counters and adds the visitors with one statement
+// per chunk. Counts of the same link and day are merged first, an upsert
+// cannot touch a row twice.
+func (s *PostgresStorage) RecordClicks(ctx context.Context, counts []models.ClickCount) error {
+	type key struct {
+		domain   string
+		shortURL string
+		day      time.Time
+	}
+	type visitor struct {
+		key
+		ipHash string
+	}
+	type source struct {
+		key
+		kind, name string
+	}
+	merged := make(map[key]int64, len(counts))
+	var keys []key
+	seen := make(map[visitor]bool)
+	var visitors []visitor
+	sourceClicks := make(map[source]int64)
+	var sources []source
+	addSources := func(k key, kind string, counts map[string]int64) {
+		for name, clicks := range counts {
+			src := source{key: k, kind: kind, name: name}
+			if _, ok := sourceClicks[src]; !ok {
+				sources = append(sources, src)
+			}
+			sourceClicks[src] += clicks
+		}
+	}
+	for _, c := range counts {
+		k := key{domain: c.Domain, shortURL: c.ShortURL, day: clickDay(c.Day)}
+		if _, ok := merged[k]; !ok {
+			keys = append(keys, k)
+		}
+		merged[k] += c.Clicks
+		for _, ipHash := range c.IPHashes {
+			if v := (visitor{key: k, ipHash: ipHash}); !seen[v] {
+				seen[v] = true
+				visitors = append(visitors, v)
+			}
+		}
+		addSources(k, sourceReferer, c.Referers)
+		addSources(k, sourceUserAgent, c.UserAgents)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return 0
+		return err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(keys); start += batchChunkSize {
+		end := min(start+batchChunkSize, len(keys))
+		var query strings.Builder
+		query.WriteString(`INSERT INTO link_clicks (domain, short_url, day, clicks) VALUES `)
+		args := make([]interface{}, 0, (end-start)*4)
+		for i, k := range keys[start:end] {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			n := len(args)
+			fmt.Fprintf(&query, "($%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4)
+			args = append(args, k.domain, k.shortURL, k.day, merged[k])
+		}
+		query.WriteString(` ON CONFLICT (domain, short_url, day) DO UPDATE SET clicks = link_clicks.clicks + EXCLUDED.clicks`)
+		if _, err := tx.ExecContext(ctx, query.String(), args...); err != nil {
+			return err
+		}
+	}
+	for start := 0; start < len(visitors); start += batchChunkSize {
+		end := min(start+batchChunkSize, len(visitors))
+		var query strings.Builder
+		query.WriteString(`INSERT INTO link_visitors (domain, short_url, day, ip_hash) VALUES `)
+		args := make([]interface{}, 0, (end-start)*4)
+		for i, v := range visitors[start:end] {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			n := len(args)
+			fmt.Fprintf(&query, "($%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4)
+			args = append(args, v.domain, v.shortURL, v.day, v.ipHash)
+		}
+		query.WriteString(` ON CONFLICT DO NOTHING`)
+		if _, err := tx.ExecContext(ctx, query.String(), args...); err != nil {
+			return err
+		}
+	}
+	for start := 0; start < len(sources); start += batchChunkSize {
+		end := min(start+batchChunkSize, len(sources))
+		var query strings.Builder
+		query.WriteString(`INSERT INTO link_sources (domain, short_url, day, kind, name, clicks) VALUES `)
+		args := make([]interface{}, 0, (end-start)*6)
+		for i, src := range sources[start:end] {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			n := len(args)
+			fmt.Fprintf(&query, "($%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6)
+			args = append(args, src.domain, src.shortURL, src.day, src.kind, src.name, sourceClicks[src])
+		}
+		query.WriteString(` ON CONFLICT (domain, short_url, day, kind, name) DO UPDATE SET clicks = link_sources.clicks + EXCLUDED.clicks`)
+		if _, err := tx.ExecContext(ctx, query.String(), args...); err != nil {
+			return err
+		}
 	}
-	return uuid
+	return tx.Commit()
 }
-func (s *PostgresStorage) FindAllByOwner(owner string) ([]models.ResponseToOwner, error) {
-	query := `SELECT short_url, original_url FROM urls WHERE owner = $1` // Исправьте запрос для выбора всех необходимых столбцов
-	rows, err := s.db.Query(query, owner)
+
+// Kinds of the link_sources rows.
+const (
+	sourceReferer   = "referer"
+	sourceUserAgent = "user_agent"
+)
+
+func (s *PostgresStorage) ClickStats(ctx context.Context, key models.LinkKey) ([]models.ClickCount, error) {
+	query := `
+	SELECT c.day, c.clicks, COUNT(v.ip_hash) FROM link_clicks c
+	LEFT JOIN link_visitors v ON v.domain = c.domain AND v.short_url = c.short_url AND v.day = c.day
+	WHERE c.domain = $1 AND c.short_url = $2
+	GROUP BY c.day, c.clicks ORDER BY c.day`
+	rows, err := s.db.QueryContext(ctx, query, key.Domain, key.ShortURL)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var resp []models.ResponseToOwner
+	var counts []models.ClickCount
+	byDay := make(map[time.Time]int)
 	for rows.Next() {
-		var shortURL string
-		var originalURL string
-		if err := rows.Scan(&shortURL, &originalURL); err != nil {
+		c := models.ClickCount{Domain: key.Domain, ShortURL: key.ShortURL, Referers: map[string]int64{}, UserAgents: map[string]int64{}}
+		if err := rows.Scan(&c.Day, &c.Clicks, &c.Visitors); err != nil {
 			return nil, err
 		}
-		resp = append(resp, models.ResponseToOwner{
-			ShortURL:    "http://localhost:8080/" + shortURL,
-			OriginalURL: originalURL,
-		})
+		byDay[clickDay(c.Day)] = len(counts)
+		counts = append(counts, c)
 	}
-
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
+	rows.Close()
+
+	query = `SELECT day, kind, name, clicks FROM link_sources WHERE domain = $1 AND short_url = $2`
+	rows, err = s.db.QueryContext(ctx, query, key.Domain, key.ShortURL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day time.Time
+		var kind, name string
+		var clicks int64
+		if err := rows.Scan(&day, &kind, &name, &clicks); err != nil {
+			return nil, err
+		}
+		i, ok := byDay[clickDay(day)]
+		if !ok {
+			continue
+		}
+		switch kind {
+		case sourceReferer:
+			counts[i].Referers[name] = clicks
+		case sourceUserAgent:
+			counts[i].UserAgents[name] = clicks
+		}
+	}
+	return counts, rows.Err()
+}
+
+// tokenColumns is the column list read by scanToken.
+const tokenColumns = `id, user_id, name, token_hash, prefix, created_at, revoked_at`
+
+func scanToken(row rowScanner) (models.APIToken, error) {
+	var token models.APIToken
+	var revokedAt sql.NullTime
+	err := row.Scan(&token.ID, &token.UserID, &token.Name, &token.Hash, &token.Prefix, &token.CreatedAt, &revokedAt)
+	if err != nil {
+		return models.APIToken{}, err
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+	return token, nil
+}
+
+func (s *PostgresStorage) CreateUser(ctx context.Context, user models.User) error {
+	query := `INSERT INTO users (id, name, created_at) VALUES ($1, $2, $3)`
+	_, err := s.db.ExecContext(ctx, query, user.ID, user.Name, user.CreatedAt)
+	return err
+}
+
+func (s *PostgresStorage) FindUser(ctx context.Context, id string) (models.User, error) {
+	var user models.User
+	query := `SELECT id, name, created_at FROM users WHERE id = $1`
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.Name, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.User{}, ErrUserNotFound
+	}
+	return user, err
+}
+
+func (s *PostgresStorage) SaveToken(ctx context.Context, token models.APIToken) error {
+	query := `
+	INSERT INTO api_tokens (id, user_id, name, token_hash, prefix, created_at)
+	SELECT $1, $2, $3, $4, $5, $6 WHERE EXISTS (SELECT 1 FROM users WHERE id = $2)`
+	res, err := s.db.ExecContext(ctx, query, token.ID, token.UserID, token.Name, token.Hash, token.Prefix, token.CreatedAt)
+	if err != nil {
+		return err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStorage) FindToken(ctx context.Context, hash string) (models.APIToken, error) {
+	query := `SELECT ` + tokenColumns + ` FROM api_tokens WHERE token_hash = $1`
+	token, err := scanToken(s.db.QueryRowContext(ctx, query, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.APIToken{}, ErrTokenNotFound
+	}
+	return token, err
+}
+
+func (s *PostgresStorage) ListTokens(ctx context.Context, userID string) ([]models.APIToken, error) {
+	query := `SELECT ` + tokenColumns + ` FROM api_tokens WHERE user_id = $1 ORDER BY created_at`
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	return resp, nil
+	var tokens []models.APIToken
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
 }
 
-func (s *PostgresStorage) DeleterURL(url string, user string, wg *sync.WaitGroup) error {
-	defer wg.Done()
-	query := `UPDATE urls SET DeletedFlag = true WHERE short_url = $1 AND owner = $2`
-	_, err := s.db.Exec(query, url, user)
+func (s *PostgresStorage) RevokeToken(ctx context.Context, userID, tokenID string, at time.Time) error {
+	query := `
+	UPDATE api_tokens SET revoked_at = COALESCE(revoked_at, $3)
+	WHERE user_id = $1 AND id = $2`
+	res, err := s.db.ExecContext(ctx, query, userID, tokenID, at)
 	if err != nil {
 		return err
 	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrTokenNotFound
+	}
 	return nil
 }
+
+func (s *PostgresStorage) ClaimLinks(ctx context.Context, from, to string) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE urls SET owner = $2 WHERE owner = $1`, from, to)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -1,8 +1,136 @@
 package storage
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Dnlbb/link-shortener/internal/models"
+)
+
+var (
+	ErrNotFound = errors.New("link not found")
+	ErrDeleted  = errors.New("link deleted")
+	ErrExpired  = errors.New("link expired")
+	ErrConflict = errors.New("link already exists")
+	// ErrCodeTaken means the short URL is used by a different original URL.
+	ErrCodeTaken = errors.New("short url is taken")
+
+	ErrUserNotFound  = errors.New("user not found")
+	ErrTokenNotFound = errors.New("token not found")
+)
+
+// ConflictError is returned by Save when the link is already stored,
+// ShortURL holds the code of the existing link.
+type ConflictError struct {
+	ShortURL string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrConflict, e.ShortURL)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
+
+// Repository stores short links keyed by domain and code. An original URL is
+// shortened once per domain. Save and SaveBatch return ErrCodeTaken when a
+// short URL of the domain is already used for another original URL. Find
+// returns the link together with ErrDeleted for soft-deleted links. SaveBatch
+// is atomic and returns one result per input in the same order, DeleteBatch
+// ignores codes the owner does not have. Expired links are reported by Find
+// with ErrExpired until PurgeExpired removes them together with their clicks.
+// RecordClicks adds the counts to the per-day counters, ClickStats returns
+// the counters of a link ordered by day. CountActiveByOwner counts the links
+// of the owner that are neither deleted nor expired at now. CountNewLinks
+// returns how many links SaveBatch would create for the inputs, leaving out
+// the permanent ones whose original URL is already shortened.
 type Repository interface {
-	Save(shortURL, originalURL, owner string) error
-	Find(shortURL string) (string, bool)
-	GetUUID() int
-	CreateTable() error
+	Save(ctx context.Context, link models.Link) error
+	SaveBatch(ctx context.Context, inputs []models.LinkInput) ([]models.BatchResult, error)
+	Find(ctx context.Context, key models.LinkKey) (models.Link, error)
+	FindAllByOwner(ctx context.Context, owner string, filter models.LinkFilter) ([]models.Link, error)
+	DeleteBatch(ctx context.Context, owner string, keys []models.LinkKey) error
+	CountActiveByOwner(ctx context.Context, owner string, now time.Time) (int, error)
+	CountNewLinks(ctx context.Context, inputs []models.LinkInput) (int, error)
+	PurgeExpired(ctx context.Context, before time.Time) (int64, error)
+	RecordClicks(ctx context.Context, counts []models.ClickCount) error
+	ClickStats(ctx context.Context, key models.LinkKey) ([]models.ClickCount, error)
+	NextID(ctx context.Context) (int64, error)
+	CreateTable(ctx context.Context) error
+}
+
+// UserRepository stores accounts and their API tokens. FindToken looks a
+// token up by its hash and returns revoked tokens as well, RevokeToken
+// returns ErrTokenNotFound for tokens of other users. ClaimLinks hands every
+// link of one owner over to another and returns how many links moved.
+type UserRepository interface {
+	CreateUser(ctx context.Context, user models.User) error
+	FindUser(ctx context.Context, id string) (models.User, error)
+	SaveToken(ctx context.Context, token models.APIToken) error
+	FindToken(ctx context.Context, hash string) (models.APIToken, error)
+	ListTokens(ctx context.Context, userID string) ([]models.APIToken, error)
+	RevokeToken(ctx context.Context, userID, tokenID string, at time.Time) error
+	ClaimLinks(ctx context.Context, from, to string) (int64, error)
+}
+
+// linkState returns ErrDeleted or ErrExpired for links that must not be
+// served anymore.
+func linkState(link models.Link, now time.Time) error {
+	if link.IsDeleted() {
+		return ErrDeleted
+	}
+	if link.IsExpired(now) {
+		return ErrExpired
+	}
+	return nil
+}
+
+// fromInput builds the link stored for the input.
+func fromInput(in models.LinkInput, now time.Time) models.Link {
+	return newLink(models.Link{
+		ID:          in.ID,
+		Domain:      in.Domain,
+		ShortURL:    in.ShortURL,
+		OriginalURL: in.OriginalURL,
+		Owner:       in.Owner,
+		ExpiresAt:   in.ExpiresAt,
+	}, now)
+}
+
+// countNew counts the inputs creating a link, stored reports the permanent
+// original URLs shortened before. Repeats of a permanent original URL share
+// one link.
+func countNew(inputs []models.LinkInput, stored func(originalKey) bool) int {
+	seen := make(map[originalKey]bool)
+	n := 0
+	for _, in := range inputs {
+		if in.ExpiresAt == nil {
+			original := originalKey{in.Domain, in.OriginalURL}
+			if seen[original] || stored(original) {
+				continue
+			}
+			seen[original] = true
+		}
+		n++
+	}
+	return n
+}
+
+// clickDay truncates the time to the UTC day the click is counted in.
+func clickDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// newLink fills the fields a backend sets on creation.
+func newLink(link models.Link, now time.Time) models.Link {
+	if link.CreatedAt.IsZero() {
+		link.CreatedAt = now
+	}
+	link.DeletedAt = nil
+	link.Status = models.LinkStatusActive
+	return link
 }
@@ -1,50 +1,343 @@
 package storage
 
 import (
+	"context"
+	"fmt"
+	"maps"
+	"sort"
 	"sync"
+	"time"
+
+	"github.com/Dnlbb/link-shortener/internal/models"
 )
 
+// originalKey identifies an original URL within a domain. Only links without
+// an expiry hold their original URL: an expiring link would keep it after it
+// expired, and a link asked for with an expiry must not be answered with a
+// permanent one.
+type originalKey struct {
+	domain      string
+	originalURL string
+}
+
+// dayClicks counts the clicks of a link during one day, by referer and user
+// agent too, and keeps the hashes of their visitors.
+type dayClicks struct {
+	clicks     int64
+	visitors   map[string]struct{}
+	referers   map[string]int64
+	userAgents map[string]int64
+}
+
 type InMemoryStorage struct {
-	data map[string]URLData
-	mu   sync.RWMutex
-	UUID int
+	data       map[models.LinkKey]models.Link
+	byOriginal map[originalKey]string
+	// clicks holds the click counters by link and UTC day.
+	clicks map[models.LinkKey]map[time.Time]*dayClicks
+	users  map[string]models.User
+	// tokens holds the API tokens by ID, tokenHashes maps hashes to IDs.
+	tokens      map[string]models.APIToken
+	tokenHashes map[string]string
+	mu          sync.RWMutex
+	// lastID is the last row ID given to a link or reserved with NextID.
+	lastID int64
 }
 
 func NewInMemoryStorage() *InMemoryStorage {
 	return &InMemoryStorage{
-		data: make(map[string]URLData),
+		data:        make(map[models.LinkKey]models.Link),
+		byOriginal:  make(map[originalKey]string),
+		clicks:      make(map[models.LinkKey]map[time.Time]*dayClicks),
+		users:       make(map[string]models.User),
+		tokens:      make(map[string]models.APIToken),
+		tokenHashes: make(map[string]string),
+	}
+}
+
+func (s *InMemoryStorage) GetUUID(ctx context.Context) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int(s.lastID), nil
+}
+
+func (s *InMemoryStorage) NextID(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	return s.lastID, nil
+}
+
+func (s *InMemoryStorage) Save(ctx context.Context, link models.Link) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.checkConflict(link); err != nil {
+		return err
+	}
+	s.put(newLink(link, time.Now()))
+	return nil
+}
+
+// checkConflict reports whether the original URL of a permanent link or the
+// short URL is taken in the domain, the caller must hold s.mu.
+func (s *InMemoryStorage) checkConflict(link models.Link) error {
+	if link.ExpiresAt == nil {
+		if existing, exists := s.byOriginal[originalKey{link.Domain, link.OriginalURL}]; exists {
+			return &ConflictError{ShortURL: existing}
+		}
+	}
+	if _, exists := s.data[link.Key()]; exists {
+		return ErrCodeTaken
 	}
+	return nil
 }
 
-type URLData struct {
-	OriginalURL string
-	OwnerID     string
+// put stores the link without any checks, giving it the next row ID unless
+// it carries a reserved one. The caller must hold s.mu.
+func (s *InMemoryStorage) put(link models.Link) {
+	if link.ID == 0 {
+		s.lastID++
+		link.ID = s.lastID
+	} else if link.ID > s.lastID {
+		s.lastID = link.ID
+	}
+	s.data[link.Key()] = link
+	if link.ExpiresAt == nil {
+		s.byOriginal[originalKey{link.Domain, link.OriginalURL}] = link.ShortURL
+	}
 }
 
-func (s *InMemoryStorage) GetUUID() int {
+func (s *InMemoryStorage) Find(ctx context.Context, key models.LinkKey) (models.Link, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Link{}, err
+	}
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.UUID
+	link, exists := s.data[key]
+	if !exists {
+		return models.Link{}, ErrNotFound
+	}
+	return link, linkState(link, time.Now())
 }
 
-func (s *InMemoryStorage) Save(shortURL, originalURL, owner string) error {
+func (s *InMemoryStorage) SaveBatch(ctx context.Context, inputs []models.LinkInput) ([]models.BatchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.data[shortURL] = URLData{OriginalURL: originalURL, OwnerID: owner}
-	s.UUID += 1
+	results, created, err := s.planBatch(inputs, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range created {
+		s.put(link)
+	}
+	return results, nil
+}
+
+// planBatch resolves the inputs against the stored links without changing
+// them and returns the links to create. Repeated original URLs of a domain
+// inside the batch share the first created permanent link, expiring links
+// are always created. The caller must hold s.mu.
+func (s *InMemoryStorage) planBatch(inputs []models.LinkInput, now time.Time) ([]models.BatchResult, []models.Link, error) {
+	results := make([]models.BatchResult, len(inputs))
+	pending := make(map[originalKey]models.Link)
+	pendingCodes := make(map[models.LinkKey]bool)
+	var created []models.Link
+	for i, in := range inputs {
+		original := originalKey{in.Domain, in.OriginalURL}
+		key := models.LinkKey{Domain: in.Domain, ShortURL: in.ShortURL}
+		permanent := in.ExpiresAt == nil
+		if link, exists := pending[original]; exists && permanent {
+			results[i] = models.BatchResult{Link: link}
+			continue
+		}
+		if code, exists := s.byOriginal[original]; exists && permanent {
+			results[i] = models.BatchResult{Link: s.data[models.LinkKey{Domain: in.Domain, ShortURL: code}], Existed: true}
+			continue
+		}
+		if _, exists := s.data[key]; exists || pendingCodes[key] {
+			return nil, nil, fmt.Errorf("%w: %s", ErrCodeTaken, in.ShortURL)
+		}
+		link := fromInput(in, now)
+		if permanent {
+			pending[original] = link
+		}
+		pendingCodes[key] = true
+		created = append(created, link)
+		results[i] = models.BatchResult{Link: link}
+	}
+	return results, created, nil
+}
+
+func (s *InMemoryStorage) FindAllByOwner(ctx context.Context, owner string, filter models.LinkFilter) ([]models.Link, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var links []models.Link
+	for _, link := range s.data {
+		if link.Owner == owner && filter.Match(link) {
+			links = append(links, link)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].CreatedAt.Before(links[j].CreatedAt)
+	})
+	return links, nil
+}
+
+func (s *InMemoryStorage) CountActiveByOwner(ctx context.Context, owner string, now time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	count := 0
+	for _, link := range s.data {
+		if link.Owner == owner && linkState(link, now) == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *InMemoryStorage) CountNewLinks(ctx context.Context, inputs []models.LinkInput) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return countNew(inputs, func(original originalKey) bool {
+		_, exists := s.byOriginal[original]
+		return exists
+	}), nil
+}
+
+func (s *InMemoryStorage) DeleteBatch(ctx context.Context, owner string, keys []models.LinkKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, key := range keys {
+		s.markDeleted(key, owner, now)
+	}
 	return nil
 }
 
-func (s *InMemoryStorage) Find(shortURL string) (string, bool) {
+// markDeleted soft-deletes the owner's link, the caller must hold s.mu.
+func (s *InMemoryStorage) markDeleted(key models.LinkKey, owner string, at time.Time) error {
+	link, exists := s.data[key]
+	if !exists || link.Owner != owner {
+		return ErrNotFound
+	}
+	if link.IsDeleted() {
+		return nil
+	}
+	link.DeletedAt = &at
+	link.Status = models.LinkStatusDeleted
+	s.data[key] = link
+	return nil
+}
+
+func (s *InMemoryStorage) PurgeExpired(ctx context.Context, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.purge(before), nil
+}
+
+// purge removes the links that expired before the given time, the caller
+// must hold s.mu.
+func (s *InMemoryStorage) purge(before time.Time) int64 {
+	var purged int64
+	for key, link := range s.data {
+		if link.ExpiresAt == nil || !link.ExpiresAt.Before(before) {
+			continue
+		}
+		delete(s.data, key)
+		delete(s.clicks, key)
+		original := originalKey{link.Domain, link.OriginalURL}
+		if s.byOriginal[original] == link.ShortURL {
+			delete(s.byOriginal, original)
+		}
+		purged++
+	}
+	return purged
+}
+
+func (s *InMemoryStorage) RecordClicks(ctx context.Context, counts []models.ClickCount) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addClicks(counts)
+	return nil
+}
+
+// addClicks adds the counts of known links, the caller must hold s.mu.
+func (s *InMemoryStorage) addClicks(counts []models.ClickCount) {
+	for _, c := range counts {
+		key := models.LinkKey{Domain: c.Domain, ShortURL: c.ShortURL}
+		if _, exists := s.data[key]; !exists {
+			continue
+		}
+		days, ok := s.clicks[key]
+		if !ok {
+			days = make(map[time.Time]*dayClicks)
+			s.clicks[key] = days
+		}
+		day, ok := days[clickDay(c.Day)]
+		if !ok {
+			day = &dayClicks{visitors: make(map[string]struct{}), referers: make(map[string]int64), userAgents: make(map[string]int64)}
+			days[clickDay(c.Day)] = day
+		}
+		day.clicks += c.Clicks
+		for _, ipHash := range c.IPHashes {
+			day.visitors[ipHash] = struct{}{}
+		}
+		for name, clicks := range c.Referers {
+			day.referers[name] += clicks
+		}
+		for name, clicks := range c.UserAgents {
+			day.userAgents[name] += clicks
+		}
+	}
+}
+
+func (s *InMemoryStorage) ClickStats(ctx context.Context, key models.LinkKey) ([]models.ClickCount, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	urlData, exists := s.data[shortURL]
-	if !exists {
-		return "", false
+	counts := make([]models.ClickCount, 0, len(s.clicks[key]))
+	for day, c := range s.clicks[key] {
+		counts = append(counts, models.ClickCount{
+			Domain:     key.Domain,
+			ShortURL:   key.ShortURL,
+			Day:        day,
+			Clicks:     c.clicks,
+			Visitors:   int64(len(c.visitors)),
+			Referers:   maps.Clone(c.referers),
+			UserAgents: maps.Clone(c.userAgents),
+		})
 	}
-	return urlData.OriginalURL, true
+	sort.Slice(counts, func(i, j int) bool {
+		return counts[i].Day.Before(counts[j].Day)
+	})
+	return counts, nil
 }
 
-func (s *InMemoryStorage) CreateTable() error {
+func (s *InMemoryStorage) CreateTable(ctx context.Context) error {
 	return nil
 }
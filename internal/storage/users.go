@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/Dnlbb/link-shortener/internal/models"
+)
+
+func (s *InMemoryStorage) CreateUser(ctx context.Context, user models.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user.ID] = user
+	return nil
+}
+
+func (s *InMemoryStorage) FindUser(ctx context.Context, id string) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, exists := s.users[id]
+	if !exists {
+		return models.User{}, ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (s *InMemoryStorage) SaveToken(ctx context.Context, token models.APIToken) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.users[token.UserID]; !exists {
+		return ErrUserNotFound
+	}
+	s.putToken(token)
+	return nil
+}
+
+// putToken stores the token without any checks, the caller must hold s.mu.
+func (s *InMemoryStorage) putToken(token models.APIToken) {
+	s.tokens[token.ID] = token
+	s.tokenHashes[token.Hash] = token.ID
+}
+
+func (s *InMemoryStorage) FindToken(ctx context.Context, hash string) (models.APIToken, error) {
+	if err := ctx.Err(); err != nil {
+		return models.APIToken{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	id, exists := s.tokenHashes[hash]
+	if !exists {
+		return models.APIToken{}, ErrTokenNotFound
+	}
+	return s.tokens[id], nil
+}
+
+func (s *InMemoryStorage) ListTokens(ctx context.Context, userID string) ([]models.APIToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var tokens []models.APIToken
+	for _, token := range s.tokens {
+		if token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+	return tokens, nil
+}
+
+func (s *InMemoryStorage) RevokeToken(ctx context.Context, userID, tokenID string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.revoke(userID, tokenID, at)
+}
+
+// revoke marks the user's token revoked, the caller must hold s.mu.
+func (s *InMemoryStorage) revoke(userID, tokenID string, at time.Time) error {
+	token, exists := s.tokens[tokenID]
+	if !exists || token.UserID != userID {
+		return ErrTokenNotFound
+	}
+	if token.IsRevoked() {
+		return nil
+	}
+	token.RevokedAt = &at
+	s.tokens[tokenID] = token
+	return nil
+}
+
+func (s *InMemoryStorage) ClaimLinks(ctx context.Context, from, to string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.claim(from, to), nil
+}
+
+// claim moves the links of one owner to another, the caller must hold s.mu.
+func (s *InMemoryStorage) claim(from, to string) int64 {
+	var claimed int64
+	for key, link := range s.data {
+		if link.Owner != from {
+			continue
+		}
+		link.Owner = to
+		s.data[key] = link
+		claimed++
+	}
+	return claimed
+}
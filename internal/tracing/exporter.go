@@ -0,0 +1,122 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Dnlbb/link-shortener/internal/logger"
+)
+
+// SpanData is a finished span as exporters see it.
+type SpanData struct {
+	TraceID      string            `json:"trace_id"`
+	SpanID       string            `json:"span_id"`
+	ParentSpanID string            `json:"parent_span_id,omitempty"`
+	Name         string            `json:"name"`
+	Start        time.Time         `json:"start"`
+	End          time.Time         `json:"end"`
+	DurationMs   float64           `json:"duration_ms"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// Exporter sends finished spans somewhere. Export is called on the request
+// path and must not block, Close flushes the spans still held.
+type Exporter interface {
+	Export(span SpanData)
+	Close(ctx context.Context) error
+}
+
+const exportQueueSize = 4096
+
+// WriterExporter writes the spans as JSON lines from a background worker.
+// Spans are dropped when the queue is full, a request never waits for the
+// exporter.
+type WriterExporter struct {
+	w         io.Writer
+	closeFile func() error
+	spans     chan SpanData
+
+	mu      sync.RWMutex
+	closed  bool
+	dropped atomic.Int64
+	done    chan struct{}
+}
+
+// NewWriterExporter writes the spans to w, os.Stdout for the stdout exporter.
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return newWriterExporter(w, func() error { return nil })
+}
+
+// NewFileExporter appends the spans to the file at path.
+func NewFileExporter(path string) (*WriterExporter, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening the span file: %w", err)
+	}
+	return newWriterExporter(file, file.Close), nil
+}
+
+func newWriterExporter(w io.Writer, closeFile func() error) *WriterExporter {
+	e := &WriterExporter{
+		w:         w,
+		closeFile: closeFile,
+		spans:     make(chan SpanData, exportQueueSize),
+		done:      make(chan struct{}),
+	}
+	go e.worker()
+	return e
+}
+
+func (e *WriterExporter) Export(span SpanData) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		e.dropped.Add(1)
+		return
+	}
+	select {
+	case e.spans <- span:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of spans lost because the queue was full.
+func (e *WriterExporter) Dropped() int64 {
+	return e.dropped.Load()
+}
+
+// Close stops accepting spans and waits until the queued ones are written
+// or ctx is done.
+func (e *WriterExporter) Close(ctx context.Context) error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.spans)
+	}
+	e.mu.Unlock()
+
+	select {
+	case <-e.done:
+		return e.closeFile()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *WriterExporter) worker() {
+	defer close(e.done)
+	enc := json.NewEncoder(e.w)
+	for span := range e.spans {
+		if err := enc.Encode(span); err != nil {
+			logger.Default().WithFields(map[string]interface{}{"span_id": span.SpanID, logger.FieldError: err}).Error("Error exporting span")
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package tracing
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Dnlbb/link-shortener/internal/logger"
+)
+
+// TraceparentHeader carries the span context between services.
+const TraceparentHeader = "traceparent"
+
+// Middleware starts the span of a request, continuing the trace of an
+// incoming traceparent. It must run on the chi router, the span is named
+// after the route once the request has been routed.
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if remote, ok := ParseTraceparent(r.Header.Get(TraceparentHeader)); ok {
+			ctx = ContextWithRemote(ctx, remote)
+		}
+		ctx, span := t.Start(ctx, r.Method)
+		defer span.End()
+
+		data := &logger.ResponseData{}
+		next.ServeHTTP(&logger.LoggingResponseWriter{ResponseWriter: w, ResponseData: data}, r.WithContext(ctx))
+
+		if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+			span.SetName(r.Method + " " + rctx.RoutePattern())
+			span.SetAttribute("http.route", rctx.RoutePattern())
+		}
+		if data.Status == 0 {
+			data.Status = http.StatusOK
+		}
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.status_code", strconv.Itoa(data.Status))
+		if data.Status >= http.StatusInternalServerError {
+			span.RecordError(statusError(data.Status))
+		}
+	})
+}
+
+type statusError int
+
+func (e statusError) Error() string {
+	return http.StatusText(int(e))
+}
+
+// Span wraps the rest of the chain in a span of the given name.
+func (t *Tracer) Span(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, span := t.Start(r.Context(), name)
+			defer span.End()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Trace wraps a middleware in a span of the given name. The span covers the
+// middleware and everything after it, the time of the middleware itself is
+// the span less its child.
+func (t *Tracer) Trace(name string, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	span := t.Span(name)
+	return func(next http.Handler) http.Handler {
+		return span(mw(next))
+	}
+}
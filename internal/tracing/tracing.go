@@ -0,0 +1,256 @@
+// Package tracing records spans of the requests and storage calls in the
+// W3C trace context model and hands the finished ones to an Exporter.
+package tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+func (id SpanID) IsValid() bool  { return id != SpanID{} }
+
+// SpanContext is the part of a span passed on to other services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats the span context as a traceparent header.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent reads a traceparent header. Versions after 00 may append
+// fields, only the ones of version 00 are read.
+func ParseTraceparent(header string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		(parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+	if _, err := decodeHex(parts[0], 1); err != nil {
+		return SpanContext{}, false
+	}
+	traceID, err := decodeHex(parts[1], 16)
+	if err != nil {
+		return SpanContext{}, false
+	}
+	spanID, err := decodeHex(parts[2], 8)
+	if err != nil {
+		return SpanContext{}, false
+	}
+	flags, err := decodeHex(parts[3], 1)
+	if err != nil {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// decodeHex decodes lowercase hex of n bytes, the header allows no other.
+func decodeHex(s string, n int) ([]byte, error) {
+	if len(s) != 2*n || strings.ToLower(s) != s {
+		return nil, hex.InvalidByteError(0)
+	}
+	return hex.DecodeString(s)
+}
+
+// Sampler decides whether a trace started here is recorded.
+type Sampler func(id TraceID) bool
+
+// RatioSampler keeps the given share of the traces. The decision depends on
+// the trace ID only, every service sampling by ratio agrees on it.
+func RatioSampler(ratio float64) Sampler {
+	switch {
+	case ratio >= 1:
+		return func(TraceID) bool { return true }
+	case ratio <= 0:
+		return func(TraceID) bool { return false }
+	}
+	bound := uint64(ratio * math.MaxUint64)
+	return func(id TraceID) bool {
+		return binary.BigEndian.Uint64(id[8:]) < bound
+	}
+}
+
+// Tracer starts spans. The spans of a sampled trace are exported when they
+// end, the others only carry the trace on.
+type Tracer struct {
+	exporter Exporter
+	sampler  Sampler
+	now      func() time.Time
+}
+
+func NewTracer(exporter Exporter, sampler Sampler) *Tracer {
+	return &Tracer{exporter: exporter, sampler: sampler, now: time.Now}
+}
+
+type (
+	spanKey   struct{}
+	remoteKey struct{}
+)
+
+// SpanFromContext returns the current span, nil when there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemote makes the span of another service the parent of the
+// spans started with the context.
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Start starts a span, a child of the span in ctx or of the remote one. The
+// span is stored in the returned context.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	span := &Span{tracer: t, name: name, start: t.now()}
+	parent, ok := SpanContext{}, false
+	if current := SpanFromContext(ctx); current != nil {
+		parent, ok = current.sc, true
+	} else if remote, isRemote := ctx.Value(remoteKey{}).(SpanContext); isRemote {
+		parent, ok = remote, true
+	}
+	if ok {
+		span.sc.TraceID, span.sc.Sampled = parent.TraceID, parent.Sampled
+		span.parent = parent.SpanID
+	} else {
+		span.sc.TraceID = newTraceID()
+		span.sc.Sampled = t.sampler(span.sc.TraceID)
+	}
+	span.sc.SpanID = newSpanID()
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		binary.BigEndian.PutUint64(id[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(id[8:], rand.Uint64())
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	}
+	return id
+}
+
+// Span is one timed operation of a trace.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent SpanID
+	start  time.Time
+
+	mu         sync.Mutex
+	name       string
+	attributes map[string]string
+	err        string
+	ended      bool
+}
+
+func (s *Span) SpanContext() SpanContext {
+	return s.sc
+}
+
+// SetName renames the span, the route of a request is known once it has
+// been routed.
+func (s *Span) SetName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+func (s *Span) SetAttribute(key, value string) {
+	if !s.sc.Sampled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attributes == nil {
+		s.attributes = make(map[string]string)
+	}
+	s.attributes[key] = value
+}
+
+// RecordError marks the span as failed.
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err.Error()
+}
+
+// End finishes the span, ending it again does nothing.
+func (s *Span) End() {
+	end := s.tracer.now()
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	data := SpanData{
+		TraceID:    s.sc.TraceID.String(),
+		SpanID:     s.sc.SpanID.String(),
+		Name:       s.name,
+		Start:      s.start,
+		End:        end,
+		DurationMs: float64(end.Sub(s.start)) / float64(time.Millisecond),
+		Attributes: s.attributes,
+		Error:      s.err,
+	}
+	s.mu.Unlock()
+
+	if !s.sc.Sampled {
+		return
+	}
+	if s.parent.IsValid() {
+		data.ParentSpanID = s.parent.String()
+	}
+	s.tracer.exporter.Export(data)
+}
+
+// Observe is the storage.Observer tracing every repository call.
+func (t *Tracer) Observe(ctx context.Context, op string) (context.Context, func(err error)) {
+	ctx, span := t.Start(ctx, "storage."+op)
+	return ctx, func(err error) {
+		span.RecordError(err)
+		span.End()
+	}
+}
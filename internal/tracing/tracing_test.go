@@ -0,0 +1,174 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (e *recordingExporter) Export(span SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+func (e *recordingExporter) Close(context.Context) error { return nil }
+
+func (e *recordingExporter) byName() map[string]SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	spans := make(map[string]SpanData, len(e.spans))
+	for _, span := range e.spans {
+		spans[span.Name] = span
+	}
+	return spans
+}
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := ParseTraceparent(traceparent)
+	require.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, traceparent, sc.Traceparent())
+
+	sc, ok = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future")
+	require.True(t, ok, "later versions may add fields")
+	assert.False(t, sc.Sampled)
+
+	for _, header := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+	} {
+		_, ok := ParseTraceparent(header)
+		assert.False(t, ok, header)
+	}
+}
+
+func TestRatioSampler(t *testing.T) {
+	assert.True(t, RatioSampler(1)(newTraceID()))
+	assert.False(t, RatioSampler(0)(newTraceID()))
+
+	half := RatioSampler(0.5)
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		id := newTraceID()
+		assert.Equal(t, half(id), half(id), "the decision depends on the trace only")
+		if half(id) {
+			kept++
+		}
+	}
+	assert.InDelta(t, 5000, kept, 500)
+}
+
+func TestTracerStartsChildren(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := NewTracer(exporter, RatioSampler(1))
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	ctx, done := tracer.Observe(ctx, "find")
+	assert.NotSame(t, root, SpanFromContext(ctx))
+	done(errors.New("connection refused"))
+	root.End()
+	root.End()
+
+	spans := exporter.byName()
+	require.Len(t, exporter.spans, 2, "spans are exported once")
+	assert.Equal(t, spans["root"].TraceID, spans["storage.find"].TraceID)
+	assert.Equal(t, spans["root"].SpanID, spans["storage.find"].ParentSpanID)
+	assert.Empty(t, spans["root"].ParentSpanID)
+	assert.Equal(t, "connection refused", spans["storage.find"].Error)
+}
+
+func TestTracerDropsUnsampledTraces(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := NewTracer(exporter, RatioSampler(0))
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	_, child := tracer.Start(ctx, "child")
+	assert.Equal(t, root.SpanContext().TraceID, child.SpanContext().TraceID, "unsampled spans still carry the trace")
+	child.End()
+	root.End()
+	assert.Empty(t, exporter.spans)
+}
+
+func TestMiddlewareContinuesIncomingTraces(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := NewTracer(exporter, RatioSampler(0))
+	identity := func(next http.Handler) http.Handler { return next }
+
+	router := chi.NewRouter()
+	router.Use(tracer.Middleware)
+	router.Use(tracer.Trace("auth", identity))
+	router.Get("/{shortURL}", func(w http.ResponseWriter, r *http.Request) {
+		_, done := tracer.Observe(r.Context(), "find")
+		done(nil)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+	req.Header.Set(TraceparentHeader, traceparent)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.byName()
+	require.Len(t, spans, 3, "the sampled flag of the caller wins over the sampler")
+	request := spans["GET /{shortURL}"]
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", request.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", request.ParentSpanID)
+	assert.Equal(t, map[string]string{"http.method": "GET", "http.route": "/{shortURL}", "http.status_code": "503"}, request.Attributes)
+	assert.Equal(t, "Service Unavailable", request.Error)
+	assert.Equal(t, request.SpanID, spans["auth"].ParentSpanID)
+	assert.Equal(t, spans["auth"].SpanID, spans["storage.find"].ParentSpanID)
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	exporter, err := NewFileExporter(path)
+	require.NoError(t, err)
+	tracer := NewTracer(exporter, RatioSampler(1))
+	for _, name := range []string{"first", "second"} {
+		_, span := tracer.Start(context.Background(), name)
+		span.SetAttribute("key", "value")
+		span.End()
+	}
+	require.NoError(t, exporter.Close(context.Background()))
+	exporter.Export(SpanData{Name: "late"})
+	assert.Equal(t, int64(1), exporter.Dropped())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	var names []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var span SpanData
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &span))
+		assert.Equal(t, map[string]string{"key": "value"}, span.Attributes)
+		names = append(names, span.Name)
+	}
+	assert.Equal(t, []string{"first", "second"}, names)
+}
@@ -0,0 +1,154 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Dnlbb/link-shortener/internal/models"
+	"github.com/Dnlbb/link-shortener/internal/storage"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInvalidToken means the bearer token is unknown or revoked.
+	ErrInvalidToken = errors.New("users: invalid token")
+	ErrInvalidName  = errors.New("users: invalid name")
+)
+
+// tokenPrefix marks the API tokens of the service, prefixLength characters of
+// a token are kept to tell tokens apart in listings.
+const (
+	tokenPrefix  = "lsk_"
+	prefixLength = 12
+	maxNameLen   = 100
+)
+
+// Repository is the part of storage.UserRepository the service needs.
+type Repository interface {
+	CreateUser(ctx context.Context, user models.User) error
+	FindUser(ctx context.Context, id string) (models.User, error)
+	SaveToken(ctx context.Context, token models.APIToken) error
+	FindToken(ctx context.Context, hash string) (models.APIToken, error)
+	ListTokens(ctx context.Context, userID string) ([]models.APIToken, error)
+	RevokeToken(ctx context.Context, userID, tokenID string, at time.Time) error
+	ClaimLinks(ctx context.Context, from, to string) (int64, error)
+}
+
+// Service manages accounts and their API tokens. Plain tokens are returned
+// once on issue and only their hash is stored.
+type Service struct {
+	repo Repository
+}
+
+func NewService(repo Repository) *Service {
+	return &Service{repo: repo}
+}
+
+// Register creates an account together with its first token.
+func (s *Service) Register(ctx context.Context, name string) (models.User, models.ResponseIssuedToken, error) {
+	name, err := validName(name)
+	if err != nil {
+		return models.User{}, models.ResponseIssuedToken{}, err
+	}
+	user := models.User{ID: uuid.New().String(), Name: name, CreatedAt: time.Now()}
+	if err := s.repo.CreateUser(ctx, user); err != nil {
+		return models.User{}, models.ResponseIssuedToken{}, err
+	}
+	token, err := s.IssueToken(ctx, user.ID, "default")
+	if err != nil {
+		return models.User{}, models.ResponseIssuedToken{}, err
+	}
+	return user, token, nil
+}
+
+// IssueToken creates a token for the user.
+func (s *Service) IssueToken(ctx context.Context, userID, name string) (models.ResponseIssuedToken, error) {
+	name, err := validName(name)
+	if err != nil {
+		return models.ResponseIssuedToken{}, err
+	}
+	raw, err := newToken()
+	if err != nil {
+		return models.ResponseIssuedToken{}, err
+	}
+	token := models.APIToken{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Name:      name,
+		Hash:      HashToken(raw),
+		Prefix:    raw[:prefixLength],
+		CreatedAt: time.Now(),
+	}
+	if err := s.repo.SaveToken(ctx, token); err != nil {
+		return models.ResponseIssuedToken{}, err
+	}
+	return models.ResponseIssuedToken{APIToken: token, Token: raw}, nil
+}
+
+func (s *Service) User(ctx context.Context, id string) (models.User, error) {
+	return s.repo.FindUser(ctx, id)
+}
+
+func (s *Service) ListTokens(ctx context.Context, userID string) ([]models.APIToken, error) {
+	return s.repo.ListTokens(ctx, userID)
+}
+
+func (s *Service) RevokeToken(ctx context.Context, userID, tokenID string) error {
+	return s.repo.RevokeToken(ctx, userID, tokenID, time.Now())
+}
+
+// Authenticate returns the ID of the user the token belongs to.
+func (s *Service) Authenticate(ctx context.Context, raw string) (string, error) {
+	if !strings.HasPrefix(raw, tokenPrefix) {
+		return "", ErrInvalidToken
+	}
+	token, err := s.repo.FindToken(ctx, HashToken(raw))
+	if errors.Is(err, storage.ErrTokenNotFound) || (err == nil && token.IsRevoked()) {
+		return "", ErrInvalidToken
+	}
+	if err != nil {
+		return "", err
+	}
+	return token.UserID, nil
+}
+
+// Claim moves the links of the anonymous session to the user.
+func (s *Service) Claim(ctx context.Context, userID, sessionID string) (int64, error) {
+	if sessionID == "" || sessionID == userID {
+		return 0, nil
+	}
+	return s.repo.ClaimLinks(ctx, sessionID, userID)
+}
+
+// HashToken returns the hex SHA-256 of the token. Tokens carry 256 random
+// bits, so a plain hash is enough to keep them unusable at rest.
+func HashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func validName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("%w: name must not be empty", ErrInvalidName)
+	}
+	if len(name) > maxNameLen {
+		return "", fmt.Errorf("%w: name is longer than %d bytes", ErrInvalidName, maxNameLen)
+	}
+	return name, nil
+}
@@ -0,0 +1,78 @@
+package users
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Dnlbb/link-shortener/internal/models"
+	"github.com/Dnlbb/link-shortener/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokens(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewInMemoryStorage()
+	s := NewService(repo)
+
+	user, first, err := s.Register(ctx, " ci ")
+	require.NoError(t, err)
+	assert.Equal(t, "ci", user.Name)
+	assert.True(t, strings.HasPrefix(first.Token, tokenPrefix))
+	assert.Equal(t, first.Token[:prefixLength], first.Prefix)
+
+	stored, err := repo.FindToken(ctx, HashToken(first.Token))
+	require.NoError(t, err)
+	assert.NotContains(t, stored.Hash, first.Token, "only the hash is stored")
+
+	userID, err := s.Authenticate(ctx, first.Token)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, userID)
+
+	second, err := s.IssueToken(ctx, user.ID, "deploy")
+	require.NoError(t, err)
+	tokens, err := s.ListTokens(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+
+	assert.ErrorIs(t, s.RevokeToken(ctx, "someone-else", first.ID), storage.ErrTokenNotFound)
+	require.NoError(t, s.RevokeToken(ctx, user.ID, first.ID))
+	_, err = s.Authenticate(ctx, first.Token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = s.Authenticate(ctx, second.Token)
+	assert.NoError(t, err)
+
+	for _, raw := range []string{"", "lsk_unknown", second.Token[len(tokenPrefix):]} {
+		_, err = s.Authenticate(ctx, raw)
+		assert.ErrorIs(t, err, ErrInvalidToken, raw)
+	}
+
+	_, _, err = s.Register(ctx, "  ")
+	assert.ErrorIs(t, err, ErrInvalidName)
+	_, err = s.IssueToken(ctx, "missing", "deploy")
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func TestClaim(t *testing.T) {
+	ctx := context.Background()
+	repo := storage.NewInMemoryStorage()
+	s := NewService(repo)
+	user, _, err := s.Register(ctx, "ci")
+	require.NoError(t, err)
+
+	require.NoError(t, repo.Save(ctx, models.Link{ShortURL: "abc12345", OriginalURL: "https://practicum.yandex.ru/", Owner: "session1"}))
+	require.NoError(t, repo.Save(ctx, models.Link{ShortURL: "def67890", OriginalURL: "https://google.com/", Owner: "session2"}))
+
+	claimed, err := s.Claim(ctx, user.ID, "session1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), claimed)
+	claimed, err = s.Claim(ctx, user.ID, user.ID)
+	require.NoError(t, err)
+	assert.Zero(t, claimed)
+
+	links, err := repo.FindAllByOwner(ctx, user.ID, models.LinkFilter{})
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	assert.Equal(t, "abc12345", links[0].ShortURL)
+}